@@ -0,0 +1,151 @@
+package aliyun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/issuer"
+	"whoyang.cn/update_cert/utils"
+)
+
+func init() {
+	issuer.RegisterDNSProvider("alidns", NewAlidnsProvider)
+}
+
+// 云解析默认接口地址
+const alidnsEndpoint = "alidns.aliyuncs.com"
+
+// 阿里云云解析 DNS-01 验证，添加和删除 _acme-challenge TXT 记录
+type alidnsProvider struct {
+	client *rpcClient
+
+	mu sync.Mutex
+	// 本次添加的记录 ID，key 为 fqdn 和记录值
+	records map[string]string
+}
+
+// 根据配置创建云解析 DNS 服务商，与 OSS、CAS 使用同样的 AccessKey
+func NewAlidnsProvider(dnsConfig config.DNSChallenge) (issuer.DNSProvider, error) {
+	access, err := newAccessConfig(dnsConfig.Aliyun)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := dnsConfig.Url
+	if endpoint == "" {
+		endpoint = alidnsEndpoint
+	}
+	client, err := newRpcClient(access, "Alidns", endpoint, "2015-01-09")
+	if err != nil {
+		return nil, err
+	}
+	return &alidnsProvider{client: client, records: make(map[string]string)}, nil
+}
+
+// 云解析中的解析记录
+type alidnsRecord struct {
+	RecordId string `json:"RecordId"`
+	RR       string `json:"RR"`
+	Type     string `json:"Type"`
+	Value    string `json:"Value"`
+}
+
+func (p *alidnsProvider) Present(ctx context.Context, fqdn string, value string) error {
+	domainName, rr, err := p.splitFqdn(fqdn)
+	if err != nil {
+		return err
+	}
+
+	result := struct {
+		RecordId string `json:"RecordId"`
+	}{}
+	err = p.client.call("AddDomainRecord", map[string]any{
+		"DomainName": domainName,
+		"RR":         rr,
+		"Type":       "TXT",
+		"Value":      value,
+		"TTL":        600,
+	}, &result)
+	if err != nil {
+		// 上次签发失败残留的同值记录可以直接使用
+		var apiError *utils.APIError
+		if !errors.As(err, &apiError) || apiError.Code != "DomainRecordDuplicate" {
+			return err
+		}
+		record, findErr := p.findRecord(domainName, rr, value)
+		if findErr != nil {
+			return err
+		}
+		result.RecordId = record.RecordId
+	}
+
+	p.mu.Lock()
+	p.records[fqdn+" "+value] = result.RecordId
+	p.mu.Unlock()
+	utils.Println("已添加云解析记录：", rr+"."+domainName, "TXT", value)
+	return nil
+}
+
+func (p *alidnsProvider) CleanUp(ctx context.Context, fqdn string, value string) error {
+	p.mu.Lock()
+	recordId, ok := p.records[fqdn+" "+value]
+	delete(p.records, fqdn+" "+value)
+	p.mu.Unlock()
+
+	if !ok {
+		domainName, rr, err := p.splitFqdn(fqdn)
+		if err != nil {
+			return err
+		}
+		record, err := p.findRecord(domainName, rr, value)
+		if err != nil {
+			return err
+		}
+		recordId = record.RecordId
+	}
+	return p.client.call("DeleteDomainRecord", map[string]any{"RecordId": recordId}, nil)
+}
+
+// 将 fqdn 拆分为云解析中的主域名和主机记录，例如 _acme-challenge.www.example.com. 拆分为 example.com 和 _acme-challenge.www
+func (p *alidnsProvider) splitFqdn(fqdn string) (string, string, error) {
+	result := struct {
+		DomainName string `json:"DomainName"`
+		RR         string `json:"RR"`
+	}{}
+	err := p.client.call("GetMainDomainName", map[string]any{"InputString": strings.TrimSuffix(fqdn, ".")}, &result)
+	if err != nil {
+		return "", "", err
+	}
+	if result.DomainName == "" {
+		return "", "", fmt.Errorf("云解析中找不到 %s 的主域名：%w", fqdn, utils.ErrNotFound)
+	}
+	return result.DomainName, result.RR, nil
+}
+
+// 查找主机记录和记录值都一致的 TXT 记录
+func (p *alidnsProvider) findRecord(domainName string, rr string, value string) (*alidnsRecord, error) {
+	result := struct {
+		DomainRecords struct {
+			Record []alidnsRecord `json:"Record"`
+		} `json:"DomainRecords"`
+	}{}
+	err := p.client.call("DescribeDomainRecords", map[string]any{
+		"DomainName": domainName,
+		"RRKeyWord":  rr,
+		"Type":       "TXT",
+		"PageSize":   100,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range result.DomainRecords.Record {
+		if record.RR == rr && record.Value == value {
+			return &record, nil
+		}
+	}
+	return nil, fmt.Errorf("云解析中找不到 %s.%s 的 TXT 记录：%w", rr, domainName, utils.ErrNotFound)
+}
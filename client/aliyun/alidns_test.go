@@ -0,0 +1,199 @@
+package aliyun
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 模拟云解析接口，保存 example.com 下的解析记录
+type alidnsServer struct {
+	mu      sync.Mutex
+	records []alidnsRecord
+	nextId  int
+	// 收到的接口调用，按顺序记录 Action
+	actions []string
+}
+
+func (s *alidnsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// 接口名称在 x-acs-action 请求头中，参数在查询字符串中
+	_ = r.ParseForm()
+	action := r.Header.Get("x-acs-action")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.actions = append(s.actions, action)
+
+	writeError := func(status int, code string) {
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]string{"Code": code, "Message": code, "RequestId": "req-1"})
+	}
+	var result any
+	switch action {
+	case "GetMainDomainName":
+		input := r.Form.Get("InputString")
+		if input != "example.com" && !strings.HasSuffix(input, ".example.com") {
+			writeError(http.StatusBadRequest, "InvalidDomainName.NoExist")
+			return
+		}
+		result = map[string]string{"DomainName": "example.com", "RR": strings.TrimSuffix(input, ".example.com")}
+	case "AddDomainRecord":
+		if r.Form.Get("DomainName") != "example.com" || r.Form.Get("Type") != "TXT" {
+			writeError(http.StatusBadRequest, "InvalidParameter")
+			return
+		}
+		for _, record := range s.records {
+			if record.RR == r.Form.Get("RR") && record.Value == r.Form.Get("Value") {
+				writeError(http.StatusBadRequest, "DomainRecordDuplicate")
+				return
+			}
+		}
+		s.nextId++
+		record := alidnsRecord{RecordId: fmt.Sprint(s.nextId), RR: r.Form.Get("RR"), Type: "TXT", Value: r.Form.Get("Value")}
+		s.records = append(s.records, record)
+		result = map[string]string{"RecordId": record.RecordId}
+	case "DescribeDomainRecords":
+		var matched []alidnsRecord
+		for _, record := range s.records {
+			if strings.Contains(record.RR, r.Form.Get("RRKeyWord")) {
+				matched = append(matched, record)
+			}
+		}
+		result = map[string]any{"DomainRecords": map[string]any{"Record": matched}}
+	case "DeleteDomainRecord":
+		for i, record := range s.records {
+			if record.RecordId == r.Form.Get("RecordId") {
+				s.records = append(s.records[:i], s.records[i+1:]...)
+				result = map[string]string{"RecordId": record.RecordId}
+				break
+			}
+		}
+		if result == nil {
+			writeError(http.StatusBadRequest, "DomainRecordNotBelongToUser")
+			return
+		}
+	default:
+		writeError(http.StatusNotFound, "InvalidAction.NotFound")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func (s *alidnsServer) recordCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)
+}
+
+func newAlidnsTest(t *testing.T) (*alidnsServer, *alidnsProvider) {
+	t.Helper()
+	server := &alidnsServer{}
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	provider, err := NewAlidnsProvider(config.DNSChallenge{
+		Provider: "alidns",
+		Url:      httpServer.URL,
+		Aliyun:   config.Aliyun{AccessKeyId: "id", AccessKeySecret: "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, provider.(*alidnsProvider)
+}
+
+func TestAlidnsPresentAndCleanUp(t *testing.T) {
+	server, provider := newAlidnsTest(t)
+	ctx := context.Background()
+	fqdn := "_acme-challenge.www.example.com."
+
+	if err := provider.Present(ctx, fqdn, "token-1"); err != nil {
+		t.Fatal(err)
+	}
+	server.mu.Lock()
+	record := server.records[0]
+	server.mu.Unlock()
+	if record.RR != "_acme-challenge.www" || record.Value != "token-1" {
+		t.Fatalf("添加的记录 = %+v", record)
+	}
+
+	if err := provider.CleanUp(ctx, fqdn, "token-1"); err != nil {
+		t.Fatal(err)
+	}
+	if server.recordCount() != 0 {
+		t.Fatalf("清理后剩余记录 %d 条", server.recordCount())
+	}
+	// 清理时使用 Present 保存的记录 ID，不再查询
+	for _, action := range server.actions {
+		if action == "DescribeDomainRecords" {
+			t.Fatalf("接口调用 = %v，不应查询解析记录", server.actions)
+		}
+	}
+}
+
+// 上次签发残留的同值记录直接使用，清理时删除
+func TestAlidnsPresentDuplicate(t *testing.T) {
+	server, provider := newAlidnsTest(t)
+	server.records = []alidnsRecord{
+		{RecordId: "100", RR: "_acme-challenge", Type: "TXT", Value: "other"},
+		{RecordId: "101", RR: "_acme-challenge", Type: "TXT", Value: "token-2"},
+	}
+	ctx := context.Background()
+
+	if err := provider.Present(ctx, "_acme-challenge.example.com.", "token-2"); err != nil {
+		t.Fatal(err)
+	}
+	if id := provider.records["_acme-challenge.example.com. token-2"]; id != "101" {
+		t.Fatalf("记录 ID = %q，期望使用已有的 101", id)
+	}
+	if err := provider.CleanUp(ctx, "_acme-challenge.example.com.", "token-2"); err != nil {
+		t.Fatal(err)
+	}
+	if server.recordCount() != 1 || server.records[0].RecordId != "100" {
+		t.Fatalf("剩余记录 = %+v，只应删除同值记录", server.records)
+	}
+}
+
+// 其他进程添加的记录，清理时按主机记录和记录值查找
+func TestAlidnsCleanUpUnknownRecord(t *testing.T) {
+	server, provider := newAlidnsTest(t)
+	server.records = []alidnsRecord{{RecordId: "7", RR: "_acme-challenge.api", Type: "TXT", Value: "token-3"}}
+	if err := provider.CleanUp(context.Background(), "_acme-challenge.api.example.com.", "token-3"); err != nil {
+		t.Fatal(err)
+	}
+	if server.recordCount() != 0 {
+		t.Fatalf("剩余记录 %d 条", server.recordCount())
+	}
+}
+
+func TestAlidnsErrors(t *testing.T) {
+	_, provider := newAlidnsTest(t)
+	ctx := context.Background()
+
+	err := provider.Present(ctx, "_acme-challenge.example.net.", "token")
+	var apiError *utils.APIError
+	if !errors.As(err, &apiError) || apiError.Code != "InvalidDomainName.NoExist" {
+		t.Fatalf("错误 = %v，期望返回云解析的错误码", err)
+	}
+
+	err = provider.CleanUp(ctx, "_acme-challenge.example.com.", "missing")
+	if !errors.Is(err, utils.ErrNotFound) {
+		t.Fatalf("错误 = %v，记录不存在时应返回 ErrNotFound", err)
+	}
+}
+
+func TestNewAlidnsProviderRequiresCredential(t *testing.T) {
+	_, err := NewAlidnsProvider(config.DNSChallenge{Provider: "alidns", Aliyun: config.Aliyun{AccessKeyId: "id"}})
+	if err == nil {
+		t.Fatal("缺少 AccessKey 密钥时应返回错误")
+	}
+}
@@ -1,140 +1,239 @@
 package aliyun
 
 import (
+	"errors"
 	"fmt"
 	cas20200407 "github.com/alibabacloud-go/cas-20200407/v4/client"
 	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
 	util "github.com/alibabacloud-go/tea-utils/v2/service"
 	"github.com/alibabacloud-go/tea/tea"
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
-	credential "github.com/aliyun/credentials-go/credentials"
-	"os"
-	"reflect"
+	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/metrics"
+	"whoyang.cn/update_cert/target"
 	"whoyang.cn/update_cert/utils"
 )
 
 type AccessConfig struct {
 	KeyId  string
 	Secret string
+	// 凭据配置，Type 由 newAccessConfig 补全
+	Credential config.Credential
+	// 使用该凭据的部署目标，接口调用按目标统计
+	Target string
 }
 
 type OssConfig struct {
-	Endpoint   string
-	BucketName string
-	Domain     string
+	Endpoint string
+	// 为空时更新账号下全部 Bucket
+	Buckets []string
+	// 为空时更新本地证书覆盖的全部绑定域名
+	Domain string
 }
 
-type CertConfig struct {
-	CrtPath string
-	KeyPath string
-}
-
-var accessKeyId string
-
-var accessKeySecret string
-
-// 创建OSS客户端
-var endpoint string
-var bucketName string
-var domain string
-var certCrtPath string
-var certKeyPath string
-
-var ossClient *oss.Client
-var casClient *cas20200407.Client
+// 将阿里云 SDK 返回的异常转换为 APIError
+func sdkError(service string, operation string, err error) error {
+	var teaError *tea.SDKError
+	if errors.As(err, &teaError) {
+		return utils.NewAPIError(service, operation, tea.IntValue(teaError.StatusCode),
+			tea.StringValue(teaError.Code), tea.StringValue(teaError.Message))
+	}
 
-func getCasClient() (casClient *cas20200407.Client) {
-	credentialConfig := &credential.Config{
-		Type: tea.String("access_key"),
-		// 必填，请确保代码运行环境设置了环境变量 ALIBABA_CLOUD_ACCESS_KEY_ID。
-		AccessKeyId: tea.String(accessKeyId),
-		// 必填，请确保代码运行环境设置了环境变量 ALIBABA_CLOUD_ACCESS_KEY_SECRET。
-		AccessKeySecret: tea.String(accessKeySecret),
+	var openapiError interface {
+		GetStatusCode() *int
+		GetCode() *string
+		GetMessage() *string
+	}
+	if errors.As(err, &openapiError) {
+		return utils.NewAPIError(service, operation, tea.IntValue(openapiError.GetStatusCode()),
+			tea.StringValue(openapiError.GetCode()), tea.StringValue(openapiError.GetMessage()))
 	}
 
-	credential, _err := credential.NewCredential(credentialConfig)
-	if _err != nil {
-		fmt.Println("获取 CAS 客户端发生异常：", _err)
-		os.Exit(-1)
+	var ossError oss.ServiceError
+	if errors.As(err, &ossError) {
+		apiError := utils.NewAPIError(service, operation, ossError.StatusCode, ossError.Code, ossError.Message)
+		apiError.RequestId = ossError.RequestID
+		return apiError
 	}
+	return fmt.Errorf("%s %s 接口调用异常：%w", service, operation, err)
+}
 
+// 创建 OpenAPI 配置，endpoint 可以带协议，例如测试时使用 http://127.0.0.1:8080
+func newOpenapiConfig(access AccessConfig, service string, endpoint string) (*openapi.Config, error) {
+	credential, err := newCredential(access)
+	if err != nil {
+		return nil, err
+	}
 	config := &openapi.Config{
 		Credential: credential,
+		HttpClient: &openapiHttpClient{target: access.Target, service: service},
+	}
+	if scheme, host, found := strings.Cut(endpoint, "://"); found {
+		config.Protocol = tea.String(strings.ToUpper(scheme))
+		endpoint = host
+	}
+	config.Endpoint = tea.String(endpoint)
+	return config, nil
+}
+
+// CAS 接口地址：优先使用 cas_endpoint，其次按 cas_region 生成，默认 cas.aliyuncs.com
+func CasEndpoint(aliyunConfig config.Aliyun) string {
+	if aliyunConfig.CasEndpoint != "" {
+		return aliyunConfig.CasEndpoint
 	}
+	if aliyunConfig.CasRegion != "" && aliyunConfig.CasRegion != defaultCasRegion {
+		return "cas." + aliyunConfig.CasRegion + ".aliyuncs.com"
+	}
+	return "cas.aliyuncs.com"
+}
+
+// CAS 所在地域，未配置时为 cn-hangzhou
+func casRegion(aliyunConfig config.Aliyun) string {
+	if aliyunConfig.CasRegion != "" {
+		return aliyunConfig.CasRegion
+	}
+	return defaultCasRegion
+}
 
+func getCasClient(access AccessConfig, endpoint string) (*cas20200407.Client, error) {
 	// Endpoint 请参考 https://api.aliyun.com/product/cas
-	config.Endpoint = tea.String("cas.aliyuncs.com")
+	config, _err := newOpenapiConfig(access, "CAS", endpoint)
+	if _err != nil {
+		return nil, fmt.Errorf("获取 CAS 客户端发生异常：%w", _err)
+	}
 
-	casClient = &cas20200407.Client{}
-	casClient, _err = cas20200407.NewClient(config)
+	casClient, _err := cas20200407.NewClient(config)
 	if _err != nil {
-		fmt.Println("获取 CAS 客户端发生异常：", _err)
-		os.Exit(-1)
+		return nil, fmt.Errorf("获取 CAS 客户端发生异常：%w", _err)
 	}
-	return casClient
+	return casClient, nil
 }
 
-func getOssClient(endpoint string) (ossClient *oss.Client) {
-	ossClient, err := oss.New(endpoint, accessKeyId, accessKeySecret)
+func getOssClient(access AccessConfig, endpoint string) (*oss.Client, error) {
+	var options []oss.ClientOption
+	if access.Credential.Type != credentialAccessKey {
+		//临时凭据由凭据链定期刷新，每次请求时获取
+		cred, err := newCredential(access)
+		if err != nil {
+			return nil, fmt.Errorf("获取 OSS 客户端发生异常：%w", err)
+		}
+		options = append(options, oss.SetCredentialsProvider(&ossCredentialsProvider{credential: cred}))
+	}
+	options = append(options, oss.HTTPClient(&http.Client{
+		Transport: metrics.Transport(access.Target, "OSS", nil),
+		Timeout:   apiTimeout,
+	}))
+	ossClient, err := oss.New(endpoint, access.KeyId, access.Secret, options...)
 	if err != nil {
-		fmt.Println("获取 OSS 客户端发生异常：", err)
-		os.Exit(-1)
+		return nil, fmt.Errorf("获取 OSS 客户端发生异常：%w", err)
 	}
-	return ossClient
+	return ossClient, nil
 }
 
-func listBucketCname(bucketName string) (certInfo any) {
+// 自定义 HTTP 客户端后 SDK 的读写超时不再生效，统一使用该超时时间
+const apiTimeout = time.Minute
+
+// OpenAPI 的 HTTP 客户端，按目标统计接口调用
+//
+// 与 SDK 默认的客户端一致，复用首次请求时按配置生成的 transport，避免每次请求新建连接池。
+type openapiHttpClient struct {
+	target  string
+	service string
+	mu      sync.Mutex
+	client  *http.Client
+}
 
-	//获取 OOS 对应的映射域名及 SSL证书的相关信息
-	bucketCname, err := ossClient.ListBucketCname(bucketName)
-	if err != nil {
-		fmt.Println("查看 Bucket 列表发生异常: ", bucketCname)
-		os.Exit(-1)
-	}
-
-	// 打印存储空间信息
-	for _, c := range bucketCname.Cname {
-		if c.Domain == domain {
-			if c.Certificate.CertId != "" {
-				return c.Certificate
-			} else {
-				return oss.Certificate{}
-			}
+func (c *openapiHttpClient) Call(request *http.Request, transport *http.Transport) (*http.Response, error) {
+	c.mu.Lock()
+	if c.client == nil {
+		c.client = &http.Client{
+			Transport: metrics.Transport(c.target, c.service, transport),
+			Timeout:   apiTimeout,
 		}
 	}
+	client := c.client
+	c.mu.Unlock()
+	return client.Do(request)
+}
 
-	return nil
+// 列举账号下全部 Bucket
+func listBuckets(ossClient *oss.Client) ([]oss.BucketProperties, error) {
+	var buckets []oss.BucketProperties
+	marker := ""
+	for {
+		result, err := ossClient.ListBuckets(oss.Marker(marker), oss.MaxKeys(1000))
+		if err != nil {
+			return nil, sdkError("OSS", "ListBuckets", err)
+		}
+		buckets = append(buckets, result.Buckets...)
+		if !result.IsTruncated {
+			return buckets, nil
+		}
+		marker = result.NextMarker
+	}
 }
 
-func putBucketCert() bool {
-	certId := uploadCert()
+// 查询 Bucket 所在地域，例如 oss-cn-hangzhou
+func getBucketLocation(ossClient *oss.Client, bucketName string) (string, error) {
+	location, err := ossClient.GetBucketLocation(bucketName)
+	if err != nil {
+		return "", sdkError("OSS", "GetBucketLocation", err)
+	}
+	return location, nil
+}
 
-	//验证证书
-	certIdStr, certExpired := getCertInfo(certId)
+// 列举 Bucket 绑定的全部域名及证书信息，域名已绑定但未添加证书时 Certificate 为空
+func listBucketCnames(ossClient *oss.Client, bucketName string) ([]oss.Cname, error) {
+	//获取 OOS 对应的映射域名及 SSL证书的相关信息
+	bucketCname, err := ossClient.ListBucketCname(bucketName)
+	if err != nil {
+		return nil, sdkError("OSS", "ListBucketCname", err)
+	}
+	return bucketCname.Cname, nil
+}
 
-	if certExpired {
-		fmt.Printf("%s 域名对应的证书过期，结束操作", domain)
-		return false
+// Bucket 所在地域的 OSS 服务地址，例如 oss-cn-hangzhou 对应 oss-cn-hangzhou.aliyuncs.com，
+// 保留配置中服务地址的协议；配置的不是阿里云地址时（例如测试用的本地服务）直接使用配置的地址
+func regionalOssEndpoint(endpoint string, location string) string {
+	scheme, host, found := strings.Cut(endpoint, "://")
+	if !found {
+		scheme, host = "", endpoint
+	}
+	if location == "" || !strings.HasSuffix(host, ".aliyuncs.com") {
+		return endpoint
 	}
+	host = location + ".aliyuncs.com"
+	if strings.Contains(endpoint, "-internal.aliyuncs.com") {
+		host = location + "-internal.aliyuncs.com"
+	}
+	if scheme == "" {
+		return host
+	}
+	return scheme + "://" + host
+}
 
+// 绑定证书，previousCertId 为当前绑定的证书，用于一次性替换
+func putBucketCert(ossClient *oss.Client, bucketName string, domain string, certIdStr string, previousCertId string) error {
 	bucketCnameConfig := oss.PutBucketCname{
 		Cname: domain,
 		CertificateConfiguration: &oss.CertificateConfiguration{
-			CertId: certIdStr,
+			CertId:         certIdStr,
+			PreviousCertId: previousCertId,
 		},
 	}
 	_err := ossClient.PutBucketCnameWithCertificate(bucketName, bucketCnameConfig)
 	if _err != nil {
-		fmt.Println("Bucket绑定证书发生异常: ", _err)
-		return false
+		return sdkError("OSS", "Bucket绑定证书", _err)
 	}
-	return true
+	return nil
 }
 
-func deleteBucketCert() bool {
+func deleteBucketCert(ossClient *oss.Client, bucketName string, domain string) error {
 	bucketCnameConfig := oss.PutBucketCname{
 		Cname: domain,
 		CertificateConfiguration: &oss.CertificateConfiguration{
@@ -143,17 +242,13 @@ func deleteBucketCert() bool {
 	}
 	_err := ossClient.PutBucketCnameWithCertificate(bucketName, bucketCnameConfig)
 	if _err != nil {
-		fmt.Println("Bucket解除证书绑定发生异常: ", _err)
-		return false
+		return sdkError("OSS", "Bucket解除证书绑定", _err)
 	}
 
-	return true
+	return nil
 }
 
-func uploadCert() int64 {
-	cert := utils.ReadFileOneLine(certCrtPath)
-	key := utils.ReadFileOneLine(certKeyPath)
-
+func uploadCert(casClient *cas20200407.Client, domain string, cert string, key string) (int64, error) {
 	certName := domain + "_" + time.Now().Format("200601021504")
 
 	uploadUserCertificateRequest := &cas20200407.UploadUserCertificateRequest{
@@ -165,154 +260,185 @@ func uploadCert() int64 {
 	runtime := &util.RuntimeOptions{}
 	uploadUserCertificeteResponse, err := casClient.UploadUserCertificateWithOptions(uploadUserCertificateRequest, runtime)
 	if err != nil {
-		var sdkError = &tea.SDKError{}
-		sdkError = err.(*tea.SDKError)
-		utils.ErrorLog("上传证书发生异常: ", tea.StringValue(sdkError.Message))
-		os.Exit(-1)
+		return 0, sdkError("CAS", "上传证书", err)
 	}
 	body := uploadUserCertificeteResponse.Body
-	return tea.Int64Value(body.CertId)
+	return tea.Int64Value(body.CertId), nil
 }
 
-func getCertInfo(certId int64) (certIdStr string, certExpired bool) {
+func getCertInfo(casClient *cas20200407.Client, certId int64) (certIdStr string, certExpired bool, err error) {
 	//18173192
 	//18151516-cn-hangzhou
+	userCertificateDetailBody, err := getCertDetail(casClient, certId, true)
+	if err != nil {
+		return "", false, err
+	}
+
+	// 返回证书标识符和证书是否过期
+	return tea.StringValue(userCertificateDetailBody.CertIdentifier), tea.BoolValue(userCertificateDetailBody.Expired), nil
+}
+
+// 获取证书详情，certFilter 为 false 时返回证书内容
+func getCertDetail(casClient *cas20200407.Client, certId int64, certFilter bool) (*cas20200407.GetUserCertificateDetailResponseBody, error) {
 	// 创建获取用户证书详情的请求
 	getUserCertificateDetailRequest := &cas20200407.GetUserCertificateDetailRequest{
 		CertId:     tea.Int64(certId),
-		CertFilter: tea.Bool(true),
+		CertFilter: tea.Bool(certFilter),
 	}
 	// 创建运行时选项
 	runtime := &util.RuntimeOptions{}
 
 	// 调用获取用户证书详情的接口
 	getUserCertificateDetailResponse, err := casClient.GetUserCertificateDetailWithOptions(getUserCertificateDetailRequest, runtime)
-	// 如果调用接口出错，则打印错误信息并退出程序
+	// 如果调用接口出错，则返回异常
 	if err != nil {
-		var sdkError = &tea.SDKError{}
-		sdkError = err.(*tea.SDKError)
-		utils.ErrorLog("获取证书详情发生异常: ", tea.StringValue(sdkError.Message))
-		os.Exit(-1)
+		return nil, sdkError("CAS", "获取证书详情", err)
 	}
 
 	// 获取用户证书详情的响应体
-	userCertificateDetailBody := getUserCertificateDetailResponse.Body
-
-	// 返回证书标识符和证书是否过期
-	return tea.StringValue(userCertificateDetailBody.CertIdentifier), tea.BoolValue(userCertificateDetailBody.Expired)
+	return getUserCertificateDetailResponse.Body, nil
 }
 
-func deleteCert(certId int64) bool {
+func deleteCert(casClient *cas20200407.Client, certId int64) error {
 	deleteUserCertificateRequest := &cas20200407.DeleteUserCertificateRequest{
 		CertId: tea.Int64(certId),
 	}
 	runtime := &util.RuntimeOptions{}
 	_, err := casClient.DeleteUserCertificateWithOptions(deleteUserCertificateRequest, runtime)
 	if err != nil {
-		var sdkError = &tea.SDKError{}
-		sdkError = err.(*tea.SDKError)
-		utils.ErrorLog("删除证书发生异常: ", tea.StringValue(sdkError.Message))
-		return false
+		return sdkError("CAS", "删除证书", err)
 	}
-	return true
+	return nil
 }
 
-func Run(access AccessConfig, config OssConfig, cert CertConfig, version string) {
+// 部署使用的 CAS 证书
+type casCert struct {
+	id int64
+	// 证书标识，例如 18151516-cn-hangzhou
+	identifier string
+	// 是否本次上传，复用 CAS 中已有的证书时为 false，部署失败时不删除
+	uploaded bool
+}
 
-	fmt.Println("====================================")
-	fmt.Println("阿里云 OSS 证书同步工具 ", version)
-	fmt.Println("====================================")
-	fmt.Println("")
+// 上传本地证书并确认 CAS 中的证书未过期，校验失败时删除上传的证书。
+// CAS 中已有指纹相同且未过期的证书时直接复用，不重复上传
+func uploadCheckedCert(casClient *cas20200407.Client, domain string, local target.Cert) (casCert, error) {
+	existing, err := findCertByFingerprint(casClient, local.Bundle.Leaf)
+	if err != nil {
+		utils.Println("查找 CAS 中的同指纹证书失败，重新上传：", err)
+	}
+	if existing != nil {
+		certIdStr, certExpired, err := getCertInfo(casClient, existing.CertificateId)
+		if err == nil && !certExpired {
+			utils.Println("CAS 中已有相同指纹的证书，直接使用：", existing.Name, "（", certIdStr, "）")
+			return casCert{id: existing.CertificateId, identifier: certIdStr}, nil
+		}
+	}
 
-	accessKeyId = access.KeyId
-	accessKeySecret = access.Secret
+	certId, err := uploadCert(casClient, domain, local.Bundle.CrtPEM, local.Bundle.KeyPEM)
+	if err != nil {
+		return casCert{}, err
+	}
+	uploaded := casCert{id: certId, uploaded: true}
+	certIdStr, certExpired, err := getCertInfo(casClient, certId)
+	if err == nil && certExpired {
+		err = fmt.Errorf("%s 域名对应的证书：%w", domain, utils.ErrCertExpired)
+	}
+	if err != nil {
+		discardCert(casClient, uploaded)
+		return casCert{}, err
+	}
+	uploaded.identifier = certIdStr
+	return uploaded, nil
+}
 
-	endpoint = config.Endpoint
-	bucketName = config.BucketName
-	domain = config.Domain
+// 部署计划中上传证书的步骤，CAS 中已有指纹相同且未过期的证书时复用
+func planUpload(casClient *cas20200407.Client, local target.Cert) target.Step {
+	existing, err := findCertByFingerprint(casClient, local.Bundle.Leaf)
+	if err != nil {
+		utils.DebugLog("查找 CAS 中的同指纹证书失败：", err)
+	}
+	if existing != nil && !existing.expired(time.Now()) {
+		return target.Step{Action: target.StepSkip,
+			Detail: fmt.Sprintf("CAS 中已有相同指纹的证书 %s（%d），不再上传", existing.Name, existing.CertificateId)}
+	}
+	return target.Step{Action: target.StepCreate, Detail: "上传本地证书 " + local.Name + " 到 CAS"}
+}
 
-	certCrtPath = cert.CrtPath
-	certKeyPath = cert.KeyPath
+// 部署计划中删除旧证书的步骤，没有旧证书时为空
+func planDeleteOld(what string, previousCertId string) []target.Step {
+	if previousCertId == "" {
+		return nil
+	}
+	return []target.Step{{Action: target.StepDeleteOld, Detail: fmt.Sprintf("新证书生效后删除旧的%s %s，仍被其他资源使用时保留", what, previousCertId)}}
+}
 
-	if accessKeyId == "" {
-		fmt.Println("RAM用户AccessKeyID不能为空")
-		return
+// 获取 CAS 中证书的内容并补全指纹
+func fillRemoteCert(casClient *cas20200407.Client, remote *target.RemoteCert) error {
+	certId, err := parseCertId(remote.Id)
+	if err != nil {
+		return err
 	}
-	if accessKeySecret == "" {
-		fmt.Println("RAM用户AccessKey密钥不能为空")
-		return
+	detail, err := getCertDetail(casClient, certId, false)
+	if err != nil {
+		return err
 	}
+	return remote.FillFromPEM(tea.StringValue(detail.Cert))
+}
 
-	if endpoint == "" {
-		fmt.Println("OSS配置项服务地址不能为空！详情参考：https://api.aliyun.com/product/Oss")
+// 删除未能生效的新证书，避免在 CAS 中残留，复用的已有证书不删除
+func discardCert(casClient *cas20200407.Client, cert casCert) {
+	if !cert.uploaded {
 		return
 	}
-	if bucketName == "" {
-		fmt.Println("OSS配置项Bucket名称不能为空")
-		return
-	}
-	if domain == "" {
-		fmt.Println("OSS配置项绑定域名不能为空！需要先手动再系统添加域名，详情查看：对象存储/Bucket 列表/XX/域名管理")
-		return
+	if err := deleteCert(casClient, cert.id); err != nil {
+		utils.ErrorLog("删除未生效的证书 ", cert.id, " 失败：", err)
 	}
+}
 
-	if certCrtPath == "" {
-		fmt.Println("域名对应的SSL证书公钥地址不能为空")
+// 新证书生效后删除被替换的旧证书，删除失败不影响本次部署；旧证书就是本次部署的证书时不删除。
+// 复用的证书可能同时部署在其他云资源上，仍在使用或无法确认时保留，留给 gc 清理
+func deletePreviousCert(casClient *cas20200407.Client, previousCertId string, deployed casCert) {
+	if previousCertId == "" {
 		return
 	}
-	if certKeyPath == "" {
-		fmt.Println("域名对应的SSL证书私钥地址不能为空")
+	previousId, err := parseCertId(previousCertId)
+	if err == nil && previousId == deployed.id {
 		return
 	}
-
-	ossClient = getOssClient(endpoint)
-	casClient = getCasClient()
-
-	// 列举存储空间中的域名
-	bucketCertInfo := listBucketCname(bucketName)
-
-	if bucketCertInfo == nil {
-		fmt.Println("当前对象存储的Bucket未绑定域名，结束")
-		return
+	if err == nil {
+		var inUse map[int64]bool
+		inUse, err = certsInUse(casClient)
+		if err == nil && inUse[previousId] {
+			utils.Printf("旧证书 %s 仍部署在其他云资源上，暂不删除\n", previousCertId)
+			return
+		}
 	}
+	if err == nil {
+		err = deleteCert(casClient, previousId)
+	}
+	if err != nil {
+		utils.ErrorLog("删除旧证书 ", previousCertId, " 失败，可稍后通过 gc 清理：", err)
+	}
+}
 
-	// 如果没有绑定域名，则结束
-	if !reflect.DeepEqual(bucketCertInfo, oss.Certificate{}) {
-		fmt.Println("")
-		fmt.Println("当前对象存储的Bucket已绑定域名，已添加证书，进行后续操作")
-		fmt.Println("")
-		fmt.Println("")
-		certInfo := bucketCertInfo.(oss.Certificate)
-		certIdStr := certInfo.CertId
-		certId, _ := strconv.ParseInt(certIdStr[0:strings.Index(certIdStr, "-")], 10, 64)
-
-		validEndDate, _ := time.Parse("Jan 02 15:04:05 2006 MST", certInfo.ValidEndDate)
-
-		timeSub := validEndDate.Sub(time.Now())
-		if timeSub.Hours() > 72 {
-			fmt.Printf("%s 域名对应的证书还在有效期，暂不更新\n", domain)
-		} else {
-			fmt.Printf("%s 域名对应的证书将要过期，上传本地证书替换\n", domain)
-
-			//删除存储空间与证书的绑定关系
-			deleteBucketCert()
+// 解析 CAS 证书标识，例如 18151516-cn-hangzhou 中的 18151516
+func parseCertId(certIdentifier string) (int64, error) {
+	certIdStr, _, _ := strings.Cut(certIdentifier, "-")
+	certId, err := strconv.ParseInt(certIdStr, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("证书标识 %s 不合法：%w", certIdentifier, err)
+	}
+	return certId, nil
+}
 
-			//删除旧证书
-			deleteCert(certId)
+// CAS 默认地域
+const defaultCasRegion = "cn-hangzhou"
 
-			//读取本地证书，上传并绑定证书
-			if putBucketCert() {
-				fmt.Printf("%s 域名对应的证书更新成功，结束操作\n", domain)
-			}
-		}
-		return
-	}
-	fmt.Println("")
-	fmt.Printf("%s 域名未添加证书，上传本地证书并绑定\n", domain)
-	fmt.Println("")
-	fmt.Println("")
-	//读取本地证书，上传并绑定证书
-	if putBucketCert() {
-		fmt.Printf("%s 域名绑定证书成功，结束操作\n", domain)
+// 从证书标识中取出地域，例如 18151516-cn-hangzhou 中的 cn-hangzhou
+func certRegion(certIdentifier string) string {
+	if _, region, found := strings.Cut(certIdentifier, "-"); found {
+		return region
 	}
+	return defaultCasRegion
 }
@@ -0,0 +1,145 @@
+package aliyun
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"whoyang.cn/update_cert/config"
+)
+
+func TestDeletePreviousCert(t *testing.T) {
+	tests := []struct {
+		name string
+		// 旧证书是否仍部署在其他云资源上
+		deployed bool
+		// 旧证书就是本次部署的证书
+		same bool
+		// 查询部署情况失败
+		listFailed bool
+		wantDelete bool
+	}{
+		{name: "不再使用的旧证书", wantDelete: true},
+		{name: "仍部署在其他云资源上", deployed: true},
+		{name: "旧证书就是本次部署的证书", same: true},
+		{name: "无法确认是否仍在使用", listFailed: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newRpcServer(t)
+			store := server.withCas()
+			if test.listFailed {
+				server.handle("ListCloudResources", func(url.Values) (any, string) { return nil, "Throttling" })
+			}
+			previousId := store.add("previous")
+			deployedId := store.add("deployed")
+			if test.same {
+				deployedId = previousId
+			}
+			store.deployed[deployedId] = true
+			if test.deployed {
+				store.deployed[previousId] = true
+			}
+			access, err := newAccessConfig(server.aliyunConfig())
+			if err != nil {
+				t.Fatal(err)
+			}
+			casClient, err := getCasClient(access, server.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			deletePreviousCert(casClient, strconv.FormatInt(previousId, 10)+"-cn-hangzhou", casCert{id: deployedId})
+			var want []int64
+			if test.wantDelete {
+				want = []int64{previousId}
+			}
+			if !reflect.DeepEqual(store.deleted, want) {
+				t.Fatalf("删除的证书 = %v，期望 %v", store.deleted, want)
+			}
+		})
+	}
+}
+
+func TestCasEndpoint(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     config.Aliyun
+		wantHost   string
+		wantRegion string
+	}{
+		{"默认杭州", config.Aliyun{}, "cas.aliyuncs.com", "cn-hangzhou"},
+		{"显式杭州", config.Aliyun{CasRegion: "cn-hangzhou"}, "cas.aliyuncs.com", "cn-hangzhou"},
+		{"国际站新加坡", config.Aliyun{CasRegion: "ap-southeast-1"}, "cas.ap-southeast-1.aliyuncs.com", "ap-southeast-1"},
+		{"指定接口地址", config.Aliyun{CasRegion: "ap-southeast-1", CasEndpoint: "http://127.0.0.1:8080"}, "http://127.0.0.1:8080", "ap-southeast-1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := CasEndpoint(test.config); got != test.wantHost {
+				t.Fatalf("CasEndpoint = %s，期望 %s", got, test.wantHost)
+			}
+			if got := casRegion(test.config); got != test.wantRegion {
+				t.Fatalf("casRegion = %s，期望 %s", got, test.wantRegion)
+			}
+		})
+	}
+
+	for identifier, want := range map[string]string{
+		"18151516-cn-hangzhou":    "cn-hangzhou",
+		"18151516-ap-southeast-1": "ap-southeast-1",
+		"18151516":                "cn-hangzhou",
+	} {
+		if got := certRegion(identifier); got != want {
+			t.Fatalf("certRegion(%s) = %s，期望 %s", identifier, got, want)
+		}
+	}
+}
+
+// 证书标识中的 CAS 地域传给引用 CAS 证书的云产品
+func TestCertRegionFromIdentifier(t *testing.T) {
+	const region = "ap-southeast-1"
+	ctx := context.Background()
+
+	t.Run("CDN", func(t *testing.T) {
+		_, store, domain, cdn := newCdnTest(t, cdnProduct, cdnCertInfo{ServerCertificateStatus: "off", SSLProtocol: "off"})
+		store.region = region
+		if err := cdn.Deploy(ctx, newLocalCert(t, "cdn.example.com")); err != nil {
+			t.Fatal(err)
+		}
+		if got := domain.sets[0].Get("CertRegion"); got != region {
+			t.Fatalf("CertRegion = %s，期望 %s", got, region)
+		}
+	})
+
+	t.Run("CLB", func(t *testing.T) {
+		server := newRpcServer(t)
+		store := server.withCas()
+		store.region = region
+		fake := newClbFake(server)
+		fake.addListener("lb-1", 443, "sc-default")
+		upload := server.handlers["UploadServerCertificate"]
+		var got string
+		server.handle("UploadServerCertificate", func(form url.Values) (any, string) {
+			got = form.Get("AliCloudCertificateRegionId")
+			return upload(form)
+		})
+
+		aliyunConfig := server.aliyunConfig()
+		aliyunConfig.Region = "cn-shanghai"
+		aliyunConfig.CasRegion = region
+		aliyunConfig.LoadBalancerId = "lb-1"
+		aliyunConfig.ListenerPort = 443
+		slb, err := NewSlbTarget(config.Target{Name: "clb", Aliyun: aliyunConfig})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := slb.Deploy(ctx, newLocalCert(t, "www.example.com")); err != nil {
+			t.Fatal(err)
+		}
+		if got != region {
+			t.Fatalf("AliCloudCertificateRegionId = %s，期望 %s", got, region)
+		}
+	})
+}
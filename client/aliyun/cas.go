@@ -0,0 +1,234 @@
+package aliyun
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+	"time"
+
+	cas20200407 "github.com/alibabacloud-go/cas-20200407/v4/client"
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/target"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 分页查询时每页的数量
+const casPageSize = 50
+
+// CAS 中上传的证书
+type casCertOrder struct {
+	CertificateId int64  `json:"CertificateId"`
+	Name          string `json:"Name"`
+	CommonName    string `json:"CommonName"`
+	Sans          string `json:"Sans"`
+	// 证书的 SHA-256 指纹
+	Sha2    string `json:"Sha2"`
+	Expired bool   `json:"Expired"`
+	EndDate string `json:"EndDate"`
+	// 毫秒时间戳
+	CertEndTime int64 `json:"CertEndTime"`
+}
+
+// 证书是否已过期
+func (c *casCertOrder) expired(now time.Time) bool {
+	return c.Expired || (c.CertEndTime > 0 && time.UnixMilli(c.CertEndTime).Before(now))
+}
+
+// SDK 中没有的 CAS 接口通过同一个客户端的通用接口调用
+func casApi(casClient *cas20200407.Client) *rpcClient {
+	return &rpcClient{service: "CAS", version: "2020-04-07", client: &casClient.Client}
+}
+
+// 列举上传的证书，keyword 按名称或域名筛选，为空时列举全部
+func listUserCerts(casClient *cas20200407.Client, keyword string) ([]casCertOrder, error) {
+	var certs []casCertOrder
+	for page := 1; ; page++ {
+		query := map[string]any{
+			"OrderType":   "UPLOAD",
+			"CurrentPage": page,
+			"ShowSize":    casPageSize,
+		}
+		if keyword != "" {
+			query["Keyword"] = keyword
+		}
+		result := struct {
+			TotalCount           int            `json:"TotalCount"`
+			CertificateOrderList []casCertOrder `json:"CertificateOrderList"`
+		}{}
+		if err := casApi(casClient).call("ListUserCertificateOrder", query, &result); err != nil {
+			return nil, err
+		}
+		certs = append(certs, result.CertificateOrderList...)
+		if len(result.CertificateOrderList) < casPageSize || len(certs) >= result.TotalCount {
+			return certs, nil
+		}
+	}
+}
+
+// 查找 CAS 中与本地证书指纹相同的证书，没有时返回 nil
+func findCertByFingerprint(casClient *cas20200407.Client, leaf *x509.Certificate) (*casCertOrder, error) {
+	keyword := leaf.Subject.CommonName
+	if keyword == "" && len(leaf.DNSNames) > 0 {
+		keyword = leaf.DNSNames[0]
+	}
+	certs, err := listUserCerts(casClient, keyword)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := utils.CertFingerprint(leaf)
+	for _, cert := range certs {
+		if normalizeFingerprint(cert.Sha2) == fingerprint {
+			return &cert, nil
+		}
+	}
+	return nil, nil
+}
+
+// 已部署到云产品的证书 ID
+func certsInUse(casClient *cas20200407.Client) (map[int64]bool, error) {
+	inUse := make(map[int64]bool)
+	for page, total := 1, 0; ; page++ {
+		result := struct {
+			Total int `json:"Total"`
+			Data  []struct {
+				CertId int64 `json:"CertId"`
+			} `json:"Data"`
+		}{}
+		err := casApi(casClient).call("ListCloudResources", map[string]any{
+			"CurrentPage": page,
+			"ShowSize":    casPageSize,
+		}, &result)
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range result.Data {
+			inUse[resource.CertId] = true
+		}
+		total += len(result.Data)
+		if len(result.Data) < casPageSize || total >= result.Total {
+			return inUse, nil
+		}
+	}
+}
+
+// 清理 CAS 证书的选项
+type GCOptions struct {
+	// 只列出不删除
+	DryRun bool
+	// 同时清理未部署到任何云产品的证书，默认只清理已过期的证书
+	Unbound bool
+	// 配置的目标当前使用的证书，按证书 ID 或指纹匹配，不会被清理；为 nil 时表示无法确认，不清理未部署的证书
+	InUse []*target.RemoteCert
+}
+
+// 清理 CAS 中已过期的上传证书，开启 Unbound 时同时清理未部署到任何云产品的证书，返回删除失败的数量
+func CollectGarbage(aliyunConfig config.Aliyun, options GCOptions) (int, error) {
+	access, err := newAccessConfig(aliyunConfig)
+	if err != nil {
+		return 0, err
+	}
+	casClient, err := getCasClient(access, CasEndpoint(aliyunConfig))
+	if err != nil {
+		return 0, err
+	}
+
+	certs, err := listUserCerts(casClient, "")
+	if err != nil {
+		return 0, err
+	}
+	var deployed map[int64]bool
+	if options.Unbound {
+		// 查询不到部署情况或目标当前使用的证书时只清理过期证书，避免误删正在使用的证书
+		if deployed, err = certsInUse(casClient); err != nil {
+			utils.Println("查询证书部署情况失败，只清理已过期的证书：", err)
+		} else if options.InUse == nil {
+			utils.Println("无法确认配置的目标当前使用的证书，只清理已过期的证书")
+			deployed = nil
+		}
+	}
+	garbage := selectGarbage(certs, deployed, options.InUse, time.Now())
+	failed := 0
+	for _, cert := range garbage {
+		if options.DryRun {
+			utils.Printf("[dry-run] 将删除证书 %d %s（%s）：%s\n", cert.CertificateId, cert.Name, cert.CommonName, cert.reason)
+			continue
+		}
+		if err := deleteCert(casClient, cert.CertificateId); err != nil {
+			failed++
+			utils.ErrorLog("删除证书 ", cert.CertificateId, " ", cert.Name, " 失败：", err)
+			continue
+		}
+		utils.Printf("已删除证书 %d %s（%s）：%s\n", cert.CertificateId, cert.Name, cert.CommonName, cert.reason)
+	}
+	utils.Printf("CAS 中共 %d 张上传证书，需要清理 %d 张\n", len(certs), len(garbage))
+	return failed, nil
+}
+
+// 需要清理的证书及原因
+type garbageCert struct {
+	casCertOrder
+	reason string
+}
+
+// 选出已过期的证书，deployed 不为 nil 时还包括未部署的证书；配置的目标正在使用的证书不清理
+func selectGarbage(certs []casCertOrder, deployed map[int64]bool, inUse []*target.RemoteCert, now time.Time) []garbageCert {
+	protectedIds, protectedFingerprints := protectedCerts(inUse)
+	var garbage []garbageCert
+	for _, cert := range certs {
+		var reason string
+		switch {
+		case cert.expired(now):
+			reason = "已过期（" + cert.EndDate + "）"
+		case deployed != nil && !deployed[cert.CertificateId]:
+			reason = "未部署到任何云产品"
+		default:
+			continue
+		}
+		if protectedIds[cert.CertificateId] || protectedFingerprints[normalizeFingerprint(cert.Sha2)] {
+			utils.Printf("证书 %d %s（%s）%s，但配置的目标正在使用，跳过\n", cert.CertificateId, cert.Name, cert.CommonName, reason)
+			continue
+		}
+		garbage = append(garbage, garbageCert{casCertOrder: cert, reason: reason})
+	}
+	return garbage
+}
+
+// 目标当前使用的证书 ID 和指纹，负载均衡服务器证书等不是 CAS 证书 ID 的只按指纹匹配
+func protectedCerts(inUse []*target.RemoteCert) (map[int64]bool, map[string]bool) {
+	ids, fingerprints := make(map[int64]bool), make(map[string]bool)
+	for _, remote := range inUse {
+		if certId, err := parseCertId(remote.Id); err == nil {
+			ids[certId] = true
+		}
+		if remote.Fingerprint != "" {
+			fingerprints[remote.Fingerprint] = true
+		}
+	}
+	return ids, fingerprints
+}
+
+// CAS 返回的 SHA-256 指纹转换为 utils.CertFingerprint 的格式
+func normalizeFingerprint(sha2 string) string {
+	return strings.ToUpper(strings.ReplaceAll(sha2, ":", ""))
+}
+
+// 目标当前绑定的全部证书：OSS 目标返回每个绑定域名的证书，其他目标为 Describe 的结果
+func BoundCerts(ctx context.Context, t target.Target) ([]*target.RemoteCert, error) {
+	if ossTarget, ok := t.(*ossTarget); ok {
+		if err := ossTarget.refresh(); err != nil {
+			return nil, err
+		}
+		var certs []*target.RemoteCert
+		for _, binding := range ossTarget.bindings {
+			if binding.current != nil {
+				certs = append(certs, binding.current)
+			}
+		}
+		return certs, nil
+	}
+	current, err := t.Describe(ctx)
+	if err != nil || current == nil {
+		return nil, err
+	}
+	return []*target.RemoteCert{current}, nil
+}
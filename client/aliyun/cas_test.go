@@ -0,0 +1,119 @@
+package aliyun
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/target"
+)
+
+func TestSelectGarbage(t *testing.T) {
+	now := time.Now()
+	expired := now.Add(-time.Hour).UnixMilli()
+	valid := now.Add(30 * 24 * time.Hour).UnixMilli()
+	certs := []casCertOrder{
+		{CertificateId: 1, Name: "expired", CertEndTime: expired},
+		{CertificateId: 2, Name: "deployed", CertEndTime: valid},
+		{CertificateId: 3, Name: "unbound", CertEndTime: valid},
+		{CertificateId: 4, Name: "bound-by-id", CertEndTime: valid},
+		{CertificateId: 5, Name: "bound-by-fingerprint", CertEndTime: valid, Sha2: "ab:cd:ef"},
+		{CertificateId: 6, Name: "expired-bound", Expired: true},
+	}
+	deployed := map[int64]bool{2: true}
+	inUse := []*target.RemoteCert{
+		{Id: "4-cn-hangzhou"},
+		// 负载均衡服务器证书的 ID 不是 CAS 证书 ID，按指纹匹配
+		{Id: "1231579085529123_166f8204689", Fingerprint: "ABCDEF"},
+		{Id: "6"},
+	}
+
+	tests := []struct {
+		name     string
+		deployed map[int64]bool
+		inUse    []*target.RemoteCert
+		want     []int64
+	}{
+		{"只清理过期证书", nil, nil, []int64{1, 6}},
+		{"只清理过期证书，跳过目标使用的证书", nil, inUse, []int64{1}},
+		{"清理未部署的证书", deployed, inUse, []int64{1, 3}},
+		{"没有目标使用的证书", deployed, []*target.RemoteCert{}, []int64{1, 3, 4, 5, 6}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []int64
+			for _, cert := range selectGarbage(certs, test.deployed, test.inUse, now) {
+				got = append(got, cert.CertificateId)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("清理的证书 = %v，期望 %v", got, test.want)
+			}
+		})
+	}
+}
+
+// 模拟 CAS 的列举接口，按 ShowSize 分页
+func newCasListServer(t *testing.T, certCount int, deployed []int64) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		page, _ := strconv.Atoi(r.Form.Get("CurrentPage"))
+		size, _ := strconv.Atoi(r.Form.Get("ShowSize"))
+		from, to := min((page-1)*size, certCount), min(page*size, certCount)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Header.Get("x-acs-action") {
+		case "ListUserCertificateOrder":
+			if r.Form.Get("OrderType") != "UPLOAD" {
+				t.Errorf("OrderType = %q", r.Form.Get("OrderType"))
+			}
+			certs := []casCertOrder{}
+			for id := from + 1; id <= to; id++ {
+				certs = append(certs, casCertOrder{CertificateId: int64(id)})
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"TotalCount": certCount, "CertificateOrderList": certs})
+		case "ListCloudResources":
+			var data []map[string]int64
+			for _, certId := range deployed {
+				data = append(data, map[string]int64{"CertId": certId})
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"Total": len(data), "Data": data})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string]string{"Code": "InvalidAction.NotFound"})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestListCasCerts(t *testing.T) {
+	server := newCasListServer(t, casPageSize+3, []int64{2, 7})
+	access, err := newAccessConfig(config.Aliyun{AccessKeyId: "id", AccessKeySecret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	casClient, err := getCasClient(access, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs, err := listUserCerts(casClient, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != casPageSize+3 || certs[casPageSize].CertificateId != casPageSize+1 {
+		t.Fatalf("分页列举到 %d 张证书", len(certs))
+	}
+	inUse, err := certsInUse(casClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(inUse, map[int64]bool{2: true, 7: true}) {
+		t.Fatalf("已部署的证书 = %v", inUse)
+	}
+}
@@ -0,0 +1,285 @@
+package aliyun
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	cas20200407 "github.com/alibabacloud-go/cas-20200407/v4/client"
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/target"
+	"whoyang.cn/update_cert/utils"
+)
+
+func init() {
+	target.Register(config.TypeAliyunCdn, func(targetConfig config.Target) (target.Target, error) {
+		return newCdnTarget(targetConfig, cdnProduct)
+	})
+	target.Register(config.TypeAliyunDcdn, func(targetConfig config.Target) (target.Target, error) {
+		return newCdnTarget(targetConfig, dcdnProduct)
+	})
+}
+
+// CDN 和 DCDN 的证书接口只有名称不同
+type accelerateProduct struct {
+	service        string
+	endpoint       string
+	version        string
+	describeAction string
+	setAction      string
+}
+
+var (
+	cdnProduct = accelerateProduct{
+		service:        "CDN",
+		endpoint:       "cdn.aliyuncs.com",
+		version:        "2018-05-10",
+		describeAction: "DescribeDomainCertificateInfo",
+		setAction:      "SetCdnDomainSSLCertificate",
+	}
+	dcdnProduct = accelerateProduct{
+		service:        "DCDN",
+		endpoint:       "dcdn.aliyuncs.com",
+		version:        "2018-01-15",
+		describeAction: "DescribeDcdnDomainCertificateInfo",
+		setAction:      "SetDcdnDomainSSLCertificate",
+	}
+)
+
+// 阿里云 CDN / DCDN 加速域名证书部署目标
+type cdnTarget struct {
+	name      string
+	domain    string
+	product   accelerateProduct
+	client    *rpcClient
+	casClient *cas20200407.Client
+	// 回滚时旧证书缺少地域信息使用的 CAS 地域
+	casRegion string
+	policy    target.Policy
+
+	// 本次部署绑定的证书 ID
+	deployedCertId string
+}
+
+func newCdnTarget(targetConfig config.Target, product accelerateProduct) (target.Target, error) {
+	access, err := newAccessConfig(targetConfig.Aliyun)
+	if err != nil {
+		return nil, err
+	}
+	access.Target = targetConfig.Name
+	if targetConfig.Aliyun.Domain == "" {
+		return nil, fmt.Errorf("%s 加速域名不能为空！需要先在控制台添加加速域名", product.service)
+	}
+
+	policy, err := target.NewPolicy(targetConfig.Renew)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := targetConfig.Aliyun.Endpoint
+	if endpoint == "" {
+		endpoint = product.endpoint
+	}
+	client, err := newRpcClient(access, product.service, endpoint, product.version)
+	if err != nil {
+		return nil, err
+	}
+	casClient, err := getCasClient(access, CasEndpoint(targetConfig.Aliyun))
+	if err != nil {
+		return nil, err
+	}
+
+	return &cdnTarget{
+		name:      targetConfig.Name,
+		domain:    targetConfig.Aliyun.Domain,
+		product:   product,
+		client:    client,
+		casClient: casClient,
+		casRegion: casRegion(targetConfig.Aliyun),
+		policy:    policy,
+	}, nil
+}
+
+func (t *cdnTarget) Name() string {
+	return t.name
+}
+
+func (t *cdnTarget) Domains() []string {
+	return []string{t.domain}
+}
+
+// 加速域名的证书信息
+type cdnCertInfo struct {
+	DomainName string `json:"DomainName"`
+	CertId     string `json:"CertId"`
+	CertName   string `json:"CertName"`
+	// 格式为 2006-01-02T15:04:05Z
+	CertExpireTime string `json:"CertExpireTime"`
+	// CDN 返回 ServerCertificateStatus，DCDN 返回 SSLProtocol，开启时为 on
+	ServerCertificateStatus string `json:"ServerCertificateStatus"`
+	SSLProtocol             string `json:"SSLProtocol"`
+	CertRegion              string `json:"CertRegion"`
+	// 证书类型：cas 为 CAS 证书，free 为免费证书，upload 为直接上传的证书
+	CertType string `json:"CertType"`
+}
+
+// 是否已开启 HTTPS
+func (c *cdnCertInfo) httpsOn() bool {
+	return c.ServerCertificateStatus == "on" || c.SSLProtocol == "on"
+}
+
+// 是否已开启 HTTPS 并使用 CAS 中的证书
+func (c *cdnCertInfo) bound() bool {
+	return c.httpsOn() && c.CertId != "" && (c.CertType == "" || c.CertType == "cas")
+}
+
+// 查询加速域名的证书信息
+func (t *cdnTarget) certInfo() (*cdnCertInfo, error) {
+	result := struct {
+		CertInfos struct {
+			CertInfo []cdnCertInfo `json:"CertInfo"`
+		} `json:"CertInfos"`
+	}{}
+	if err := t.client.call(t.product.describeAction, map[string]any{"DomainName": t.domain}, &result); err != nil {
+		return nil, err
+	}
+	for _, certInfo := range result.CertInfos.CertInfo {
+		if certInfo.DomainName == t.domain {
+			return &certInfo, nil
+		}
+	}
+	return nil, fmt.Errorf("%s 加速域名 %s 不存在：%w", t.product.service, t.domain, utils.ErrNotFound)
+}
+
+func (t *cdnTarget) Describe(ctx context.Context) (*target.RemoteCert, error) {
+	certInfo, err := t.certInfo()
+	if err != nil {
+		return nil, err
+	}
+	// 未开启 HTTPS 或者证书不是 CAS 证书
+	if !certInfo.bound() {
+		return nil, nil
+	}
+
+	expireTime, _ := time.Parse(time.RFC3339, certInfo.CertExpireTime)
+	remote := &target.RemoteCert{
+		Id:       certInfo.CertId,
+		Domains:  []string{t.domain},
+		NotAfter: expireTime,
+	}
+	//从 CAS 获取绑定证书的内容用于指纹比较，失败时只按有效期判断
+	if err := fillRemoteCert(t.casClient, remote); err != nil {
+		utils.Println(t.domain, "绑定的证书内容无法获取，按有效期判断是否更新：", err)
+	}
+	return remote, nil
+}
+
+func (t *cdnTarget) NeedRenew(ctx context.Context, current *target.RemoteCert, local target.Cert) (bool, string, error) {
+	renew, reason := t.policy.Decide(current, local, time.Now())
+	return renew, reason, nil
+}
+
+func (t *cdnTarget) Plan(ctx context.Context, current *target.RemoteCert, local target.Cert) ([]target.Step, error) {
+	steps := []target.Step{
+		planUpload(t.casClient, local),
+		{Action: target.StepBind, Detail: fmt.Sprintf("%s 加速域名 %s 开启 HTTPS 并切换到新证书", t.product.service, t.domain)},
+	}
+	if current != nil {
+		steps = append(steps, planDeleteOld("CAS 证书", current.Id)...)
+	}
+	return steps, nil
+}
+
+func (t *cdnTarget) Deploy(ctx context.Context, local target.Cert) error {
+	current, err := t.certInfo()
+	if err != nil {
+		return err
+	}
+	// 记录切换前的 HTTPS 状态和证书，回滚时恢复
+	previous := *current
+	previousCertId := ""
+	if previous.bound() {
+		previousCertId = previous.CertId
+	}
+
+	//1、上传到 CAS 并校验，此时加速域名仍使用旧证书
+	cert, err := uploadCheckedCert(t.casClient, t.domain, local)
+	if err != nil {
+		return err
+	}
+	newCertId := strconv.FormatInt(cert.id, 10)
+
+	//2、将加速域名切换到新证书
+	if err := t.setCert(newCertId, certRegion(cert.identifier)); err != nil {
+		discardCert(t.casClient, cert)
+		return err
+	}
+
+	//3、确认切换已生效，失败时回滚到旧证书
+	bound, err := t.Describe(ctx)
+	if err == nil && (bound == nil || bound.Id != newCertId) {
+		err = fmt.Errorf("%s 加速域名 %s 的证书未切换到 %s", t.product.service, t.domain, newCertId)
+	}
+	if err != nil {
+		if rollbackErr := t.rollback(previous); rollbackErr != nil {
+			return fmt.Errorf("%w；回滚失败：%v", err, rollbackErr)
+		}
+		discardCert(t.casClient, cert)
+		if previousCertId == "" {
+			return fmt.Errorf("%w，已回滚并关闭 HTTPS", err)
+		}
+		return fmt.Errorf("%w，已回滚到旧证书 %s", err, previousCertId)
+	}
+	t.deployedCertId = newCertId
+
+	//4、新证书生效后再删除旧证书
+	deletePreviousCert(t.casClient, previousCertId, cert)
+	return nil
+}
+
+// 开启 HTTPS 并使用 CAS 中的证书
+func (t *cdnTarget) setCert(certId string, region string) error {
+	return t.client.call(t.product.setAction, map[string]any{
+		"DomainName":  t.domain,
+		"SSLProtocol": "on",
+		"CertType":    "cas",
+		"CertId":      certId,
+		"CertRegion":  region,
+	}, nil)
+}
+
+// 恢复切换前的状态：原来未开启 HTTPS 时关闭 HTTPS，原来使用 CAS 证书时恢复为旧证书。
+// 原来开启了 HTTPS 但不是 CAS 证书时无法恢复，保持 HTTPS 开启并继续使用新证书
+func (t *cdnTarget) rollback(previous cdnCertInfo) error {
+	if !previous.httpsOn() {
+		return t.client.call(t.product.setAction, map[string]any{
+			"DomainName":  t.domain,
+			"SSLProtocol": "off",
+		}, nil)
+	}
+	if !previous.bound() {
+		return fmt.Errorf("原来使用的 %s 证书 %s 不是 CAS 证书，无法恢复，HTTPS 保持开启并继续使用新证书",
+			previous.CertType, previous.CertName)
+	}
+	region := previous.CertRegion
+	if region == "" {
+		region = t.casRegion
+	}
+	return t.setCert(previous.CertId, region)
+}
+
+func (t *cdnTarget) Verify(ctx context.Context, local target.Cert) error {
+	current, err := t.Describe(ctx)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Id != t.deployedCertId {
+		return fmt.Errorf("%s 加速域名 %s 绑定的证书与上传的证书不一致", t.product.service, t.domain)
+	}
+	if current.Fingerprint != "" && current.Fingerprint != utils.CertFingerprint(local.Bundle.Leaf) {
+		return fmt.Errorf("%s 加速域名 %s 绑定的证书指纹与本地证书不一致", t.product.service, t.domain)
+	}
+	utils.Printf("%s 加速域名 %s 的证书更新成功，有效期至：%s\n", t.product.service, t.domain,
+		current.NotAfter.Local().Format("2006-01-02 15:04:05"))
+	return nil
+}
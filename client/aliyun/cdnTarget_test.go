@@ -0,0 +1,171 @@
+package aliyun
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"whoyang.cn/update_cert/config"
+)
+
+// 模拟加速域名 cdn.example.com 的证书配置
+type cdnDomain struct {
+	info cdnCertInfo
+	// 切换证书的请求，按顺序记录
+	sets []url.Values
+	// 为 true 时切换请求返回成功但不生效，用于触发回滚
+	stuck bool
+}
+
+func (d *cdnDomain) register(server *rpcServer, product accelerateProduct) {
+	server.handle(product.describeAction, func(form url.Values) (any, string) {
+		if form.Get("DomainName") != d.info.DomainName {
+			return nil, "InvalidDomain.NotFound"
+		}
+		return map[string]any{"CertInfos": map[string]any{"CertInfo": []cdnCertInfo{d.info}}}, ""
+	})
+	server.handle(product.setAction, func(form url.Values) (any, string) {
+		d.sets = append(d.sets, form)
+		if d.stuck {
+			return nil, ""
+		}
+		if form.Get("SSLProtocol") == "off" {
+			d.info = cdnCertInfo{DomainName: d.info.DomainName, ServerCertificateStatus: "off", SSLProtocol: "off"}
+			return nil, ""
+		}
+		d.info.ServerCertificateStatus, d.info.SSLProtocol = "on", "on"
+		d.info.CertId, d.info.CertType, d.info.CertRegion = form.Get("CertId"), form.Get("CertType"), form.Get("CertRegion")
+		return nil, ""
+	})
+}
+
+func newCdnTest(t *testing.T, product accelerateProduct, info cdnCertInfo) (*rpcServer, *casStore, *cdnDomain, *cdnTarget) {
+	t.Helper()
+	server := newRpcServer(t)
+	store := server.withCas()
+	info.DomainName = "cdn.example.com"
+	domain := &cdnDomain{info: info}
+	domain.register(server, product)
+
+	aliyunConfig := server.aliyunConfig()
+	aliyunConfig.Domain = "cdn.example.com"
+	cdn, err := newCdnTarget(config.Target{Name: "cdn", Aliyun: aliyunConfig}, product)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, store, domain, cdn.(*cdnTarget)
+}
+
+func TestCdnDeploy(t *testing.T) {
+	for _, product := range []accelerateProduct{cdnProduct, dcdnProduct} {
+		t.Run(product.service, func(t *testing.T) {
+			local := newLocalCert(t, "cdn.example.com")
+			old := newLocalCert(t, "cdn.example.com")
+			server, store, domain, cdn := newCdnTest(t, product, cdnCertInfo{})
+			oldId := store.add(old.Bundle.CrtPEM)
+			domain.info = cdnCertInfo{DomainName: "cdn.example.com", SSLProtocol: "on", ServerCertificateStatus: "on",
+				CertId: strconv.FormatInt(oldId, 10), CertType: "cas"}
+
+			ctx := context.Background()
+			current, err := cdn.Describe(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if current == nil || current.Fingerprint == "" {
+				t.Fatalf("旧证书 = %+v，期望从 CAS 获取到指纹", current)
+			}
+			if err := cdn.Deploy(ctx, local); err != nil {
+				t.Fatal(err)
+			}
+			if err := cdn.Verify(ctx, local); err != nil {
+				t.Fatal(err)
+			}
+			if domain.info.CertType != "cas" || domain.info.CertId == current.Id {
+				t.Fatalf("加速域名的证书 = %+v", domain.info)
+			}
+			if len(store.deleted) != 1 || store.deleted[0] != oldId {
+				t.Fatalf("删除的证书 = %v，期望删除旧证书 %d", store.deleted, oldId)
+			}
+			if !server.called(product.setAction) {
+				t.Fatalf("没有调用 %s", product.setAction)
+			}
+		})
+	}
+}
+
+func TestCdnRollback(t *testing.T) {
+	tests := []struct {
+		name string
+		info cdnCertInfo
+		// 旧证书是 CAS 证书时放入 CAS
+		casCert bool
+		// 最后一次切换请求的 SSLProtocol 和 CertId，CertId 为 old、new 时表示旧证书、新证书
+		wantProtocol string
+		wantCertId   string
+		// 新证书是否删除
+		wantDiscard bool
+		wantErr     string
+	}{
+		{
+			name:         "原来未开启 HTTPS 时关闭",
+			info:         cdnCertInfo{ServerCertificateStatus: "off", SSLProtocol: "off"},
+			wantProtocol: "off",
+			wantDiscard:  true,
+			wantErr:      "已回滚并关闭 HTTPS",
+		},
+		{
+			name:         "原来使用 CAS 证书时恢复",
+			info:         cdnCertInfo{ServerCertificateStatus: "on", SSLProtocol: "on", CertType: "cas"},
+			casCert:      true,
+			wantProtocol: "on",
+			wantCertId:   "old",
+			wantDiscard:  true,
+			wantErr:      "已回滚到旧证书",
+		},
+		{
+			name:         "原来使用免费证书时保持 HTTPS 开启",
+			info:         cdnCertInfo{ServerCertificateStatus: "on", SSLProtocol: "on", CertType: "free", CertId: "9", CertName: "free-cert"},
+			wantProtocol: "on",
+			wantCertId:   "new",
+			wantErr:      "无法恢复",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			local := newLocalCert(t, "cdn.example.com")
+			_, store, domain, cdn := newCdnTest(t, cdnProduct, test.info)
+			oldId := ""
+			if test.casCert {
+				oldId = strconv.FormatInt(store.add(newLocalCert(t, "cdn.example.com").Bundle.CrtPEM), 10)
+				domain.info.CertId = oldId
+			}
+			domain.stuck = true
+
+			err := cdn.Deploy(context.Background(), local)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("err = %v，期望包含 %q", err, test.wantErr)
+			}
+			last := domain.sets[len(domain.sets)-1]
+			wantCertId := test.wantCertId
+			switch wantCertId {
+			case "old":
+				wantCertId = oldId
+			case "new":
+				wantCertId = strconv.FormatInt(store.nextId, 10)
+			}
+			if last.Get("SSLProtocol") != test.wantProtocol || last.Get("CertId") != wantCertId {
+				t.Fatalf("最后一次切换 = %v，期望 SSLProtocol=%s CertId=%s", last, test.wantProtocol, wantCertId)
+			}
+			for _, set := range domain.sets {
+				if test.info.SSLProtocol == "on" && set.Get("SSLProtocol") == "off" {
+					t.Fatal("原来开启了 HTTPS，回滚时不能关闭")
+				}
+			}
+			if discarded := len(store.deleted) == 1; discarded != test.wantDiscard {
+				t.Fatalf("删除的证书 = %v，期望删除新证书 %v", store.deleted, test.wantDiscard)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package aliyun
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/alibabacloud-go/tea/tea"
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	credential "github.com/aliyun/credentials-go/credentials"
+	"github.com/aliyun/credentials-go/credentials/providers"
+	"whoyang.cn/update_cert/config"
+)
+
+// 凭据类型
+const (
+	credentialAccessKey      = "access_key"
+	credentialSts            = "sts"
+	credentialRamRoleArn     = "ram_role_arn"
+	credentialOidcRoleArn    = "oidc_role_arn"
+	credentialEcsRamRole     = "ecs_ram_role"
+	credentialCredentialsUri = "credentials_uri"
+	credentialProfile        = "profile"
+	credentialDefault        = "default"
+)
+
+// 从配置中读取凭据，未指定类型时填写了 AccessKey 使用 access_key，否则使用默认凭据链
+func newAccessConfig(aliyunConfig config.Aliyun) (AccessConfig, error) {
+	access := AccessConfig{
+		KeyId:      aliyunConfig.AccessKeyId,
+		Secret:     aliyunConfig.AccessKeySecret,
+		Credential: aliyunConfig.Credential,
+	}
+	credentialConfig := &access.Credential
+	if credentialConfig.Type == "" {
+		credentialConfig.Type = credentialDefault
+		if access.KeyId != "" || access.Secret != "" {
+			credentialConfig.Type = credentialAccessKey
+		}
+	}
+
+	switch credentialConfig.Type {
+	case credentialAccessKey, credentialSts, credentialRamRoleArn:
+		if access.KeyId == "" {
+			return AccessConfig{}, fmt.Errorf("RAM用户AccessKeyID不能为空")
+		}
+		if access.Secret == "" {
+			return AccessConfig{}, fmt.Errorf("RAM用户AccessKey密钥不能为空")
+		}
+		if credentialConfig.Type == credentialSts && credentialConfig.SecurityToken == "" {
+			return AccessConfig{}, fmt.Errorf("sts 凭据的 security_token 不能为空")
+		}
+		if credentialConfig.Type == credentialRamRoleArn && credentialConfig.RoleArn == "" {
+			return AccessConfig{}, fmt.Errorf("ram_role_arn 凭据的 role_arn 不能为空")
+		}
+	case credentialCredentialsUri:
+		if credentialConfig.Url == "" {
+			return AccessConfig{}, fmt.Errorf("credentials_uri 凭据的 url 不能为空")
+		}
+	case credentialEcsRamRole:
+		if credentialConfig.MetadataEndpoint != "" {
+			endpoint, err := url.Parse(credentialConfig.MetadataEndpoint)
+			if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+				return AccessConfig{}, fmt.Errorf("ecs_ram_role 凭据的 metadata_endpoint %s 不合法", credentialConfig.MetadataEndpoint)
+			}
+		}
+	case credentialOidcRoleArn, credentialProfile, credentialDefault:
+	default:
+		return AccessConfig{}, fmt.Errorf("阿里云凭据类型 %s 不支持，可选：%s、%s、%s、%s、%s、%s、%s、%s",
+			credentialConfig.Type, credentialAccessKey, credentialSts, credentialRamRoleArn, credentialOidcRoleArn,
+			credentialEcsRamRole, credentialCredentialsUri, credentialProfile, credentialDefault)
+	}
+	return access, nil
+}
+
+// 按凭据类型创建阿里云凭据，临时凭据由 credentials-go 在过期前自动刷新
+func newCredential(access AccessConfig) (credential.Credential, error) {
+	credentialConfig := access.Credential
+	switch credentialConfig.Type {
+	case credentialDefault:
+		return credential.NewCredential(nil)
+	case credentialProfile:
+		provider, err := providers.NewProfileCredentialsProviderBuilder().
+			WithProfileName(credentialConfig.Profile).
+			Build()
+		if err != nil {
+			return nil, err
+		}
+		return credential.FromCredentialsProvider(credentialProfile, provider), nil
+	case credentialEcsRamRole:
+		builder := providers.NewECSRAMRoleCredentialsProviderBuilder().WithRoleName(credentialConfig.RoleName)
+		if credentialConfig.MetadataEndpoint != "" {
+			builder.WithHttpOptions(&providers.HttpOptions{Proxy: credentialConfig.MetadataEndpoint})
+		}
+		provider, err := builder.Build()
+		if err != nil {
+			return nil, err
+		}
+		return credential.FromCredentialsProvider(credentialEcsRamRole, provider), nil
+	}
+
+	sdkConfig := &credential.Config{
+		Type: tea.String(credentialConfig.Type),
+	}
+	switch credentialConfig.Type {
+	case credentialAccessKey, credentialSts, credentialRamRoleArn:
+		// 请确保代码运行环境设置了 AccessKey，不要在代码中写入明文
+		sdkConfig.AccessKeyId = tea.String(access.KeyId)
+		sdkConfig.AccessKeySecret = tea.String(access.Secret)
+		sdkConfig.SecurityToken = tea.String(credentialConfig.SecurityToken)
+	}
+	if credentialConfig.RoleArn != "" {
+		sdkConfig.RoleArn = tea.String(credentialConfig.RoleArn)
+	}
+	sessionName := credentialConfig.RoleSessionName
+	if sessionName == "" {
+		sessionName = "update_cert"
+	}
+	sdkConfig.RoleSessionName = tea.String(sessionName)
+	if credentialConfig.ExternalId != "" {
+		sdkConfig.ExternalId = tea.String(credentialConfig.ExternalId)
+	}
+	if credentialConfig.StsEndpoint != "" {
+		sdkConfig.STSEndpoint = tea.String(credentialConfig.StsEndpoint)
+	}
+	if credentialConfig.OIDCProviderArn != "" {
+		sdkConfig.OIDCProviderArn = tea.String(credentialConfig.OIDCProviderArn)
+	}
+	if credentialConfig.OIDCTokenFile != "" {
+		sdkConfig.OIDCTokenFilePath = tea.String(credentialConfig.OIDCTokenFile)
+	}
+	if credentialConfig.Url != "" {
+		sdkConfig.Url = tea.String(credentialConfig.Url)
+	}
+	return credential.NewCredential(sdkConfig)
+}
+
+// 将阿里云凭据转换为 OSS SDK 的凭据接口，每次请求时获取，过期前由凭据自动刷新
+type ossCredentialsProvider struct {
+	credential credential.Credential
+}
+
+type ossCredentials struct {
+	keyId         string
+	secret        string
+	securityToken string
+}
+
+func (c *ossCredentials) GetAccessKeyID() string {
+	return c.keyId
+}
+
+func (c *ossCredentials) GetAccessKeySecret() string {
+	return c.secret
+}
+
+func (c *ossCredentials) GetSecurityToken() string {
+	return c.securityToken
+}
+
+func (p *ossCredentialsProvider) GetCredentials() oss.Credentials {
+	credentials, _ := p.GetCredentialsE()
+	return credentials
+}
+
+func (p *ossCredentialsProvider) GetCredentialsE() (oss.Credentials, error) {
+	model, err := p.credential.GetCredential()
+	if err != nil {
+		return &ossCredentials{}, fmt.Errorf("获取阿里云凭据异常：%w", err)
+	}
+	return &ossCredentials{
+		keyId:         tea.StringValue(model.AccessKeyId),
+		secret:        tea.StringValue(model.AccessKeySecret),
+		securityToken: tea.StringValue(model.SecurityToken),
+	}, nil
+}
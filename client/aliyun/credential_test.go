@@ -0,0 +1,261 @@
+package aliyun
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alibabacloud-go/tea/tea"
+	"whoyang.cn/update_cert/config"
+)
+
+// STS 只支持 HTTPS，测试进程信任 httptest 的证书，在任何 TLS 连接之前设置
+func TestMain(m *testing.M) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	caFile := filepath.Join(os.TempDir(), fmt.Sprintf("update_cert_test_ca_%d.pem", os.Getpid()))
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	server.Close()
+	if err := os.WriteFile(caFile, caPEM, 0644); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	os.Setenv("SSL_CERT_FILE", caFile)
+	code := m.Run()
+	os.Remove(caFile)
+	os.Exit(code)
+}
+
+// 临时凭据的返回内容
+func sessionCredential(name string) map[string]string {
+	return map[string]string{
+		"AccessKeyId":     "STS." + name,
+		"AccessKeySecret": name + "-secret",
+		"SecurityToken":   name + "-token",
+		"Expiration":      time.Now().UTC().Add(time.Hour).Format("2006-01-02T15:04:05Z"),
+	}
+}
+
+// 模拟 STS：AssumeRole 检查 RoleArn，AssumeRoleWithOIDC 还检查身份提供商和 OIDC Token
+func newStsServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		action := r.Form.Get("Action")
+		switch {
+		case action == "AssumeRole" && r.Form.Get("RoleArn") == "acs:ram::1:role/update-cert" &&
+			r.Form.Get("RoleSessionName") == "update_cert" && r.Form.Get("AccessKeyId") == "LTAI-role":
+			_ = json.NewEncoder(w).Encode(map[string]any{"Credentials": sessionCredential("role")})
+		case action == "AssumeRoleWithOIDC" && r.Form.Get("RoleArn") == "acs:ram::1:role/update-cert" &&
+			r.Form.Get("OIDCProviderArn") == "acs:ram::1:oidc-provider/ack" && r.Form.Get("OIDCToken") == "oidc-jwt":
+			_ = json.NewEncoder(w).Encode(map[string]any{"Credentials": sessionCredential("oidc")})
+		default:
+			http.Error(w, `{"Code":"InvalidParameter"}`, http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// 模拟 ECS 实例元数据（作为 HTTP 代理接收发往 100.100.100.200 的请求）和 credentials_uri
+func newMetadataServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/credentials":
+			_ = json.NewEncoder(w).Encode(sessionCredential("uri"))
+		case r.Host != "100.100.100.200":
+			http.NotFound(w, r)
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			fmt.Fprint(w, "metadata-token")
+		case r.Header.Get("X-Aliyun-Ecs-Metadata-Token") != "metadata-token":
+			http.Error(w, "forbidden", http.StatusForbidden)
+		case r.URL.Path == "/latest/meta-data/ram/security-credentials/":
+			fmt.Fprint(w, "update-cert")
+		case r.URL.Path == "/latest/meta-data/ram/security-credentials/update-cert":
+			response := sessionCredential("ecs")
+			response["Code"] = "Success"
+			_ = json.NewEncoder(w).Encode(response)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// 清除会影响默认凭据链的环境变量
+func clearCredentialEnv(t *testing.T) {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	for _, name := range []string{"ALIBABA_CLOUD_ACCESS_KEY_ID", "ALIBABA_CLOUD_ACCESS_KEY_SECRET", "ALIBABA_CLOUD_SECURITY_TOKEN",
+		"ALIBABA_CLOUD_ROLE_ARN", "ALIBABA_CLOUD_OIDC_PROVIDER_ARN", "ALIBABA_CLOUD_OIDC_TOKEN_FILE", "ALIBABA_CLOUD_PROFILE",
+		"ALIBABA_CLOUD_CREDENTIALS_FILE", "ALIBABA_CLOUD_CREDENTIALS_URI", "ALIBABA_CLOUD_ECS_METADATA"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("ALIBABA_CLOUD_CONFIG_FILE", filepath.Join(home, "config.json"))
+	t.Setenv("ALIBABA_CLOUD_ECS_METADATA_DISABLED", "true")
+}
+
+func TestCredential(t *testing.T) {
+	clearCredentialEnv(t)
+	sts := newStsServer(t)
+	stsEndpoint := strings.TrimPrefix(sts.URL, "https://")
+	metadata := newMetadataServer(t)
+
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "oidc-token")
+	if err := os.WriteFile(tokenFile, []byte("oidc-jwt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	profileFile := filepath.Join(dir, "credentials")
+	profiles := "[default]\ntype = access_key\naccess_key_id = LTAI-default\naccess_key_secret = x\n\n" +
+		"[deploy]\ntype = access_key\naccess_key_id = LTAI-profile\naccess_key_secret = profile-secret\n"
+	if err := os.WriteFile(profileFile, []byte(profiles), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		aliyun config.Aliyun
+		env    map[string]string
+		// 期望的错误，为空时期望获取到 keyId 和 token
+		wantErr string
+		keyId   string
+		token   string
+	}{
+		{name: "未填写类型使用 AccessKey",
+			aliyun: config.Aliyun{AccessKeyId: "LTAI-ak", AccessKeySecret: "secret"},
+			keyId:  "LTAI-ak"},
+		{name: "access_key 缺少密钥",
+			aliyun:  config.Aliyun{AccessKeyId: "LTAI-ak", Credential: config.Credential{Type: "access_key"}},
+			wantErr: "AccessKey密钥不能为空"},
+		{name: "access_key 缺少 AccessKeyID",
+			aliyun:  config.Aliyun{AccessKeySecret: "secret", Credential: config.Credential{Type: "access_key"}},
+			wantErr: "AccessKeyID不能为空"},
+		{name: "sts",
+			aliyun: config.Aliyun{AccessKeyId: "STS.ak", AccessKeySecret: "secret",
+				Credential: config.Credential{Type: "sts", SecurityToken: "sts-token"}},
+			keyId: "STS.ak", token: "sts-token"},
+		{name: "sts 缺少 security_token",
+			aliyun:  config.Aliyun{AccessKeyId: "STS.ak", AccessKeySecret: "secret", Credential: config.Credential{Type: "sts"}},
+			wantErr: "security_token 不能为空"},
+		{name: "ram_role_arn",
+			aliyun: config.Aliyun{AccessKeyId: "LTAI-role", AccessKeySecret: "secret", Credential: config.Credential{
+				Type: "ram_role_arn", RoleArn: "acs:ram::1:role/update-cert", StsEndpoint: stsEndpoint}},
+			keyId: "STS.role", token: "role-token"},
+		{name: "ram_role_arn 缺少 role_arn",
+			aliyun:  config.Aliyun{AccessKeyId: "LTAI-role", AccessKeySecret: "secret", Credential: config.Credential{Type: "ram_role_arn"}},
+			wantErr: "role_arn 不能为空"},
+		{name: "ram_role_arn 扮演失败",
+			aliyun: config.Aliyun{AccessKeyId: "LTAI-other", AccessKeySecret: "secret", Credential: config.Credential{
+				Type: "ram_role_arn", RoleArn: "acs:ram::1:role/update-cert", StsEndpoint: stsEndpoint}},
+			wantErr: "InvalidParameter"},
+		{name: "oidc_role_arn",
+			aliyun: config.Aliyun{Credential: config.Credential{Type: "oidc_role_arn", RoleArn: "acs:ram::1:role/update-cert",
+				OIDCProviderArn: "acs:ram::1:oidc-provider/ack", OIDCTokenFile: tokenFile, StsEndpoint: stsEndpoint}},
+			keyId: "STS.oidc", token: "oidc-token"},
+		{name: "oidc_role_arn 从环境变量读取",
+			aliyun: config.Aliyun{Credential: config.Credential{Type: "oidc_role_arn", StsEndpoint: stsEndpoint}},
+			env: map[string]string{"ALIBABA_CLOUD_ROLE_ARN": "acs:ram::1:role/update-cert",
+				"ALIBABA_CLOUD_OIDC_PROVIDER_ARN": "acs:ram::1:oidc-provider/ack", "ALIBABA_CLOUD_OIDC_TOKEN_FILE": tokenFile},
+			keyId: "STS.oidc", token: "oidc-token"},
+		{name: "oidc_role_arn 缺少 Token 文件",
+			aliyun: config.Aliyun{Credential: config.Credential{Type: "oidc_role_arn", RoleArn: "acs:ram::1:role/update-cert",
+				OIDCProviderArn: "acs:ram::1:oidc-provider/ack"}},
+			wantErr: "OIDCTokenFilePath is empty"},
+		{name: "oidc_role_arn 缺少身份提供商",
+			aliyun:  config.Aliyun{Credential: config.Credential{Type: "oidc_role_arn", RoleArn: "acs:ram::1:role/update-cert", OIDCTokenFile: tokenFile}},
+			wantErr: "OIDCProviderARN is empty"},
+		{name: "ecs_ram_role 自动获取角色名称",
+			aliyun: config.Aliyun{Credential: config.Credential{Type: "ecs_ram_role", MetadataEndpoint: metadata.URL}},
+			env:    map[string]string{"ALIBABA_CLOUD_ECS_METADATA_DISABLED": ""},
+			keyId:  "STS.ecs", token: "ecs-token"},
+		{name: "ecs_ram_role 指定角色名称",
+			aliyun: config.Aliyun{Credential: config.Credential{Type: "ecs_ram_role", RoleName: "update-cert", MetadataEndpoint: metadata.URL}},
+			env:    map[string]string{"ALIBABA_CLOUD_ECS_METADATA_DISABLED": ""},
+			keyId:  "STS.ecs", token: "ecs-token"},
+		{name: "ecs_ram_role 角色不存在",
+			aliyun:  config.Aliyun{Credential: config.Credential{Type: "ecs_ram_role", RoleName: "missing", MetadataEndpoint: metadata.URL}},
+			env:     map[string]string{"ALIBABA_CLOUD_ECS_METADATA_DISABLED": ""},
+			wantErr: "404"},
+		{name: "ecs_ram_role metadata_endpoint 不合法",
+			aliyun:  config.Aliyun{Credential: config.Credential{Type: "ecs_ram_role", MetadataEndpoint: "127.0.0.1:8080"}},
+			wantErr: "metadata_endpoint 127.0.0.1:8080 不合法"},
+		{name: "credentials_uri",
+			aliyun: config.Aliyun{Credential: config.Credential{Type: "credentials_uri", Url: metadata.URL + "/credentials"}},
+			keyId:  "STS.uri", token: "uri-token"},
+		{name: "credentials_uri 缺少 url",
+			aliyun:  config.Aliyun{Credential: config.Credential{Type: "credentials_uri"}},
+			wantErr: "url 不能为空"},
+		{name: "credentials_uri 返回错误",
+			aliyun:  config.Aliyun{Credential: config.Credential{Type: "credentials_uri", Url: metadata.URL + "/missing"}},
+			wantErr: "failed"},
+		{name: "profile",
+			aliyun: config.Aliyun{Credential: config.Credential{Type: "profile", Profile: "deploy"}},
+			env:    map[string]string{"ALIBABA_CLOUD_CREDENTIALS_FILE": profileFile},
+			keyId:  "LTAI-profile"},
+		{name: "profile 默认使用 default",
+			aliyun: config.Aliyun{Credential: config.Credential{Type: "profile"}},
+			env:    map[string]string{"ALIBABA_CLOUD_CREDENTIALS_FILE": profileFile},
+			keyId:  "LTAI-default"},
+		{name: "profile 不存在",
+			aliyun:  config.Aliyun{Credential: config.Credential{Type: "profile", Profile: "missing"}},
+			env:     map[string]string{"ALIBABA_CLOUD_CREDENTIALS_FILE": profileFile},
+			wantErr: "missing"},
+		{name: "默认凭据链读取环境变量",
+			aliyun: config.Aliyun{},
+			env:    map[string]string{"ALIBABA_CLOUD_ACCESS_KEY_ID": "LTAI-env", "ALIBABA_CLOUD_ACCESS_KEY_SECRET": "env-secret"},
+			keyId:  "LTAI-env"},
+		{name: "默认凭据链没有可用凭据",
+			aliyun:  config.Aliyun{Credential: config.Credential{Type: "default"}},
+			wantErr: "unable to get credentials"},
+		{name: "不支持的类型",
+			aliyun:  config.Aliyun{Credential: config.Credential{Type: "ak"}},
+			wantErr: "阿里云凭据类型 ak 不支持"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			keyId, token, err := loadCredential(test.aliyun)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("错误 = %v，期望包含 %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keyId != test.keyId || token != test.token {
+				t.Fatalf("AccessKeyId = %q，SecurityToken = %q，期望 %q、%q", keyId, token, test.keyId, test.token)
+			}
+		})
+	}
+}
+
+// 按配置创建凭据并获取一次
+func loadCredential(aliyunConfig config.Aliyun) (string, string, error) {
+	access, err := newAccessConfig(aliyunConfig)
+	if err != nil {
+		return "", "", err
+	}
+	cred, err := newCredential(access)
+	if err != nil {
+		return "", "", err
+	}
+	model, err := cred.GetCredential()
+	if err != nil {
+		return "", "", err
+	}
+	return tea.StringValue(model.AccessKeyId), tea.StringValue(model.SecurityToken), nil
+}
@@ -0,0 +1,385 @@
+package aliyun
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cas20200407 "github.com/alibabacloud-go/cas-20200407/v4/client"
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/target"
+	"whoyang.cn/update_cert/utils"
+)
+
+func init() {
+	target.Register(config.TypeAliyunAlb, func(targetConfig config.Target) (target.Target, error) {
+		return newListenerTarget(targetConfig, albProduct)
+	})
+	target.Register(config.TypeAliyunNlb, func(targetConfig config.Target) (target.Target, error) {
+		return newListenerTarget(targetConfig, nlbProduct)
+	})
+}
+
+// 监听证书的关联是异步完成的，部署后等待关联完成的时间和检查间隔，测试中会缩短
+var (
+	listenerWaitTimeout  = 2 * time.Minute
+	listenerWaitInterval = 5 * time.Second
+)
+
+// ALB 和 NLB 的监听证书接口基本一致，只有参数名称不同
+type listenerProduct struct {
+	service string
+	// 接口地址前缀，完整地址为 <prefix>.<region>.aliyuncs.com
+	endpointPrefix string
+	version        string
+	// 是否需要传 RegionId
+	regionId bool
+	// 修改默认证书和关联、解除扩展证书的参数名称
+	defaultParam    string
+	additionalParam string
+}
+
+var (
+	albProduct = listenerProduct{
+		service:         "ALB",
+		endpointPrefix:  "alb",
+		version:         "2020-06-16",
+		defaultParam:    "Certificates.1.CertificateId",
+		additionalParam: "Certificates.1.CertificateId",
+	}
+	nlbProduct = listenerProduct{
+		service:         "NLB",
+		endpointPrefix:  "nlb",
+		version:         "2022-04-30",
+		regionId:        true,
+		defaultParam:    "CertificateIds.1",
+		additionalParam: "AdditionalCertificateIds.1",
+	}
+)
+
+// 阿里云应用型负载均衡 ALB / 网络型负载均衡 NLB 监听证书部署目标，直接使用 CAS 中的证书
+type listenerTarget struct {
+	name       string
+	region     string
+	listenerId string
+	domain     string
+	additional bool
+	product    listenerProduct
+	client     *rpcClient
+	casClient  *cas20200407.Client
+	policy     target.Policy
+
+	// 本次部署关联的证书标识
+	deployedCertId string
+}
+
+func newListenerTarget(targetConfig config.Target, product listenerProduct) (target.Target, error) {
+	access, err := newAccessConfig(targetConfig.Aliyun)
+	if err != nil {
+		return nil, err
+	}
+	access.Target = targetConfig.Name
+	aliyunConfig := targetConfig.Aliyun
+	if aliyunConfig.Region == "" {
+		return nil, fmt.Errorf("%s 所在地域 region 不能为空", product.service)
+	}
+	if aliyunConfig.ListenerId == "" {
+		return nil, fmt.Errorf("%s 监听 ID listener_id 不能为空", product.service)
+	}
+	additional, err := isAdditional(aliyunConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := target.NewPolicy(targetConfig.Renew)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := aliyunConfig.Endpoint
+	if endpoint == "" {
+		endpoint = product.endpointPrefix + "." + aliyunConfig.Region + ".aliyuncs.com"
+	}
+	client, err := newRpcClient(access, product.service, endpoint, product.version)
+	if err != nil {
+		return nil, err
+	}
+	casClient, err := getCasClient(access, CasEndpoint(aliyunConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	return &listenerTarget{
+		name:       targetConfig.Name,
+		region:     aliyunConfig.Region,
+		listenerId: aliyunConfig.ListenerId,
+		domain:     aliyunConfig.Domain,
+		additional: additional,
+		product:    product,
+		client:     client,
+		casClient:  casClient,
+		policy:     policy,
+	}, nil
+}
+
+func (t *listenerTarget) Name() string {
+	return t.name
+}
+
+func (t *listenerTarget) Domains() []string {
+	if t.domain == "" {
+		return nil
+	}
+	return []string{t.domain}
+}
+
+// 监听关联的证书
+type listenerCert struct {
+	CertificateId string `json:"CertificateId"`
+	// ALB 返回 IsDefault，NLB 返回 IsDefaultCertificate
+	IsDefault            bool `json:"IsDefault"`
+	IsDefaultCertificate bool `json:"IsDefaultCertificate"`
+	// Associating、Associated、Dissociating 等
+	Status string `json:"Status"`
+}
+
+func (c listenerCert) isDefault() bool {
+	return c.IsDefault || c.IsDefaultCertificate
+}
+
+// 调用接口，NLB 需要补充 RegionId
+func (t *listenerTarget) call(action string, query map[string]any, result any) error {
+	query["ListenerId"] = t.listenerId
+	if t.product.regionId {
+		query["RegionId"] = t.region
+	}
+	return t.client.call(action, query, result)
+}
+
+// 查询监听关联的服务器证书
+func (t *listenerTarget) certificates() ([]listenerCert, error) {
+	result := struct {
+		Certificates []listenerCert `json:"Certificates"`
+	}{}
+	err := t.call("ListListenerCertificates", map[string]any{
+		"CertificateType": "Server",
+		"MaxResults":      100,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	return result.Certificates, nil
+}
+
+// 查找需要替换的证书：默认证书，或者覆盖 domain 的扩展证书，没有时返回 nil
+func (t *listenerTarget) current() (*target.RemoteCert, error) {
+	certs, err := t.certificates()
+	if err != nil {
+		return nil, err
+	}
+	for _, cert := range certs {
+		if cert.isDefault() == t.additional {
+			continue
+		}
+		remote := &target.RemoteCert{Id: cert.CertificateId}
+		fillErr := fillRemoteCert(t.casClient, remote)
+		if !t.additional {
+			//从 CAS 获取证书内容用于指纹比较，失败时默认证书视为需要更新
+			if fillErr != nil {
+				utils.Println(t.listener(), "默认证书内容无法获取，按需要更新处理：", fillErr)
+			}
+			return remote, nil
+		}
+		//扩展证书需要根据证书内容判断是否覆盖 domain
+		if fillErr != nil {
+			utils.DebugLog(t.listener(), "扩展证书", cert.CertificateId, "内容无法获取：", fillErr)
+			continue
+		}
+		if leaf, err := utils.ParseLeafPEM(remote.Crt); err == nil && utils.CertCoversDomain(leaf, t.domain) {
+			return remote, nil
+		}
+	}
+	return nil, nil
+}
+
+func (t *listenerTarget) Describe(ctx context.Context) (*target.RemoteCert, error) {
+	return t.current()
+}
+
+func (t *listenerTarget) NeedRenew(ctx context.Context, current *target.RemoteCert, local target.Cert) (bool, string, error) {
+	renew, reason := t.policy.Decide(current, local, time.Now())
+	return renew, reason, nil
+}
+
+func (t *listenerTarget) Plan(ctx context.Context, current *target.RemoteCert, local target.Cert) ([]target.Step, error) {
+	steps := []target.Step{planUpload(t.casClient, local)}
+	if t.additional {
+		steps = append(steps, target.Step{Action: target.StepBind, Detail: t.listener() + " 关联新证书"})
+	} else {
+		steps = append(steps, target.Step{Action: target.StepBind, Detail: t.listener() + " 的默认证书替换为新证书"})
+	}
+	if current != nil {
+		if t.additional {
+			steps = append(steps, target.Step{Action: target.StepDeleteOld, Detail: "新证书关联完成后解除旧的扩展证书 " + current.Id})
+		}
+		steps = append(steps, planDeleteOld("CAS 证书", current.Id)...)
+	}
+	return steps, nil
+}
+
+func (t *listenerTarget) Deploy(ctx context.Context, local target.Cert) error {
+	current, err := t.current()
+	if err != nil {
+		return err
+	}
+	previousCertId := ""
+	if current != nil {
+		previousCertId = current.Id
+	}
+
+	//1、上传到 CAS 并校验，此时监听仍使用旧证书
+	domain := t.domain
+	if domain == "" {
+		domain = t.listenerId
+	}
+	cert, err := uploadCheckedCert(t.casClient, domain, local)
+	if err != nil {
+		return err
+	}
+
+	//2、替换默认证书，或者在旧的扩展证书之外关联新证书
+	if t.additional {
+		err = t.call("AssociateAdditionalCertificatesWithListener", map[string]any{t.product.additionalParam: cert.identifier}, nil)
+	} else {
+		err = t.setDefault(cert.identifier)
+	}
+	if err != nil {
+		discardCert(t.casClient, cert)
+		return err
+	}
+
+	//3、等待新证书关联完成，失败时回滚到旧证书
+	if err := t.waitAssociated(ctx, cert.identifier); err != nil {
+		if rollbackErr := t.rollback(previousCertId, cert.identifier); rollbackErr != nil {
+			return fmt.Errorf("%w；回滚到旧证书 %s 失败：%v", err, previousCertId, rollbackErr)
+		}
+		discardCert(t.casClient, cert)
+		return fmt.Errorf("%w，已回滚到旧证书 %s", err, previousCertId)
+	}
+	t.deployedCertId = cert.identifier
+
+	//4、新证书生效后再解除旧的扩展证书并删除旧证书
+	if previousCertId == "" || previousCertId == cert.identifier {
+		return nil
+	}
+	if t.additional {
+		if err := t.dissociate(ctx, previousCertId); err != nil {
+			utils.ErrorLog("解除旧的扩展证书 ", previousCertId, " 失败：", err)
+			return nil
+		}
+	}
+	deletePreviousCert(t.casClient, previousCertId, cert)
+	return nil
+}
+
+// 修改监听的默认证书
+func (t *listenerTarget) setDefault(certId string) error {
+	return t.call("UpdateListenerAttribute", map[string]any{t.product.defaultParam: certId}, nil)
+}
+
+// 恢复为旧证书：默认证书改回旧证书，扩展证书解除新证书即可
+func (t *listenerTarget) rollback(previousCertId string, newCertId string) error {
+	if t.additional {
+		return t.call("DissociateAdditionalCertificatesFromListener", map[string]any{t.product.additionalParam: newCertId}, nil)
+	}
+	if previousCertId == "" {
+		return nil
+	}
+	return t.setDefault(previousCertId)
+}
+
+// 解除扩展证书并等待解除完成，之后才能删除 CAS 中的证书
+func (t *listenerTarget) dissociate(ctx context.Context, certId string) error {
+	err := t.call("DissociateAdditionalCertificatesFromListener", map[string]any{t.product.additionalParam: certId}, nil)
+	if err != nil {
+		return err
+	}
+	return t.wait(ctx, fmt.Sprintf("解除扩展证书 %s", certId), func(certs []listenerCert) bool {
+		for _, cert := range certs {
+			if cert.CertificateId == certId {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// 等待新证书关联完成，替换默认证书时还要求新证书已成为默认证书
+func (t *listenerTarget) waitAssociated(ctx context.Context, certId string) error {
+	return t.wait(ctx, fmt.Sprintf("关联证书 %s", certId), func(certs []listenerCert) bool {
+		for _, cert := range certs {
+			if cert.CertificateId == certId {
+				return cert.Status == "Associated" && cert.isDefault() != t.additional
+			}
+		}
+		return false
+	})
+}
+
+// 定期查询监听证书直到满足条件
+func (t *listenerTarget) wait(ctx context.Context, operation string, done func(certs []listenerCert) bool) error {
+	ctx, cancel := context.WithTimeout(ctx, listenerWaitTimeout)
+	defer cancel()
+	ticker := time.NewTicker(listenerWaitInterval)
+	defer ticker.Stop()
+	for {
+		certs, err := t.certificates()
+		if err != nil {
+			return err
+		}
+		if done(certs) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s %s 超时", t.listener(), operation)
+		case <-ticker.C:
+			utils.Println("等待", t.listener(), operation)
+		}
+	}
+}
+
+// 日志中使用的监听名称
+func (t *listenerTarget) listener() string {
+	name := fmt.Sprintf("%s 监听 %s", t.product.service, t.listenerId)
+	if t.additional {
+		name += " 的扩展证书 " + t.domain
+	}
+	return name
+}
+
+func (t *listenerTarget) Verify(ctx context.Context, local target.Cert) error {
+	certs, err := t.certificates()
+	if err != nil {
+		return err
+	}
+	associated := false
+	for _, cert := range certs {
+		if cert.CertificateId == t.deployedCertId {
+			associated = cert.Status == "Associated" && cert.isDefault() != t.additional
+		}
+	}
+	if !associated {
+		return fmt.Errorf("%s 关联的证书与上传的证书不一致", t.listener())
+	}
+
+	current := &target.RemoteCert{Id: t.deployedCertId}
+	if err := fillRemoteCert(t.casClient, current); err != nil {
+		return err
+	}
+	if current.Fingerprint != utils.CertFingerprint(local.Bundle.Leaf) {
+		return fmt.Errorf("%s 关联的证书指纹与本地证书不一致", t.listener())
+	}
+	utils.Printf("%s 的证书更新成功，有效期至：%s\n", t.listener(),
+		current.NotAfter.Local().Format("2006-01-02 15:04:05"))
+	return nil
+}
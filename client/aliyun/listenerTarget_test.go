@@ -0,0 +1,212 @@
+package aliyun
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+)
+
+// 模拟 ALB / NLB 监听关联的证书，关联和解除在下一次查询时完成
+type listenerFake struct {
+	product listenerProduct
+	certs   []listenerCert
+	// 为 true 时新证书一直处于 Associating，用于触发回滚
+	stuck bool
+}
+
+func newListenerFake(server *rpcServer, product listenerProduct) *listenerFake {
+	fake := &listenerFake{product: product}
+	server.handle("ListListenerCertificates", func(form url.Values) (any, string) {
+		if form.Get("ListenerId") != "lsn-1" {
+			return nil, "ResourceNotFound.Listener"
+		}
+		if product.regionId && form.Get("RegionId") != "cn-shanghai" {
+			return nil, "MissingRegionId"
+		}
+		certs := slices.Clone(fake.certs)
+		fake.progress()
+		return map[string]any{"Certificates": certs}, ""
+	})
+	server.handle("UpdateListenerAttribute", func(form url.Values) (any, string) {
+		certId := form.Get(product.defaultParam)
+		fake.certs = slices.DeleteFunc(fake.certs, listenerCert.isDefault)
+		fake.certs = append(fake.certs, fake.newCert(certId, true))
+		return nil, ""
+	})
+	server.handle("AssociateAdditionalCertificatesWithListener", func(form url.Values) (any, string) {
+		fake.certs = append(fake.certs, fake.newCert(form.Get(product.additionalParam), false))
+		return nil, ""
+	})
+	server.handle("DissociateAdditionalCertificatesFromListener", func(form url.Values) (any, string) {
+		for i := range fake.certs {
+			if fake.certs[i].CertificateId == form.Get(product.additionalParam) && !fake.certs[i].isDefault() {
+				fake.certs[i].Status = "Dissociating"
+				return nil, ""
+			}
+		}
+		return nil, "ResourceNotFound.Certificate"
+	})
+	return fake
+}
+
+// ALB 和 NLB 返回的默认证书字段不同
+func (fake *listenerFake) newCert(certId string, isDefault bool) listenerCert {
+	cert := listenerCert{CertificateId: certId, Status: "Associating"}
+	if fake.product.regionId {
+		cert.IsDefaultCertificate = isDefault
+	} else {
+		cert.IsDefault = isDefault
+	}
+	return cert
+}
+
+// 推进异步的关联和解除
+func (fake *listenerFake) progress() {
+	fake.certs = slices.DeleteFunc(fake.certs, func(cert listenerCert) bool {
+		return cert.Status == "Dissociating"
+	})
+	for i := range fake.certs {
+		if fake.certs[i].Status == "Associating" && !fake.stuck {
+			fake.certs[i].Status = "Associated"
+		}
+	}
+}
+
+// 放入已关联的证书
+func (fake *listenerFake) add(certId string, isDefault bool) {
+	cert := fake.newCert(certId, isDefault)
+	cert.Status = "Associated"
+	fake.certs = append(fake.certs, cert)
+}
+
+// 监听关联的证书，默认证书排在最前
+func (fake *listenerFake) associated() []string {
+	var ids []string
+	for _, cert := range fake.certs {
+		if cert.isDefault() {
+			ids = slices.Insert(ids, 0, cert.CertificateId)
+		} else {
+			ids = append(ids, cert.CertificateId)
+		}
+	}
+	return ids
+}
+
+func newListenerTest(t *testing.T, product listenerProduct, certificate string) (*rpcServer, *casStore, *listenerFake, *listenerTarget) {
+	t.Helper()
+	timeout, interval := listenerWaitTimeout, listenerWaitInterval
+	listenerWaitTimeout, listenerWaitInterval = 200*time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() { listenerWaitTimeout, listenerWaitInterval = timeout, interval })
+
+	server := newRpcServer(t)
+	store := server.withCas()
+	fake := newListenerFake(server, product)
+
+	aliyunConfig := server.aliyunConfig()
+	aliyunConfig.Region = "cn-shanghai"
+	aliyunConfig.ListenerId = "lsn-1"
+	aliyunConfig.Certificate = certificate
+	if certificate == certificateAdditional {
+		aliyunConfig.Domain = "api.example.com"
+	}
+	listener, err := newListenerTarget(config.Target{Name: "listener", Aliyun: aliyunConfig}, product)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, store, fake, listener.(*listenerTarget)
+}
+
+func TestListenerDeploy(t *testing.T) {
+	for _, product := range []listenerProduct{albProduct, nlbProduct} {
+		for _, certificate := range []string{certificateDefault, certificateAdditional} {
+			t.Run(product.service+"/"+certificate, func(t *testing.T) {
+				server, store, fake, listener := newListenerTest(t, product, certificate)
+				oldId := store.add(newOldCert(t, "api.example.com").Bundle.CrtPEM)
+				otherId := store.add(newLocalCert(t, "www.example.com").Bundle.CrtPEM)
+				// 替换默认证书时另有一张扩展证书，替换扩展证书时默认证书是其他域名的证书
+				fake.add(strconv.FormatInt(oldId, 10), certificate == certificateDefault)
+				fake.add(strconv.FormatInt(otherId, 10), certificate == certificateAdditional)
+
+				ctx := context.Background()
+				local := newLocalCert(t, "api.example.com")
+				current, err := listener.Describe(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if current == nil || current.Id != strconv.FormatInt(oldId, 10) {
+					t.Fatalf("当前证书 = %+v，期望 %d", current, oldId)
+				}
+				if renew, reason, _ := listener.NeedRenew(ctx, current, local); !renew {
+					t.Fatalf("期望需要部署：%s", reason)
+				}
+				if err := listener.Deploy(ctx, local); err != nil {
+					t.Fatal(err)
+				}
+				if err := listener.Verify(ctx, local); err != nil {
+					t.Fatal(err)
+				}
+
+				want := []string{listener.deployedCertId, strconv.FormatInt(otherId, 10)}
+				if certificate == certificateAdditional {
+					want[0], want[1] = want[1], want[0]
+				}
+				if got := fake.associated(); !slices.Equal(got, want) {
+					t.Fatalf("监听关联的证书 = %v，期望 %v", got, want)
+				}
+				// 新证书关联完成后才解除并删除旧证书
+				associate := "UpdateListenerAttribute"
+				if certificate == certificateAdditional {
+					associate = "AssociateAdditionalCertificatesWithListener"
+				}
+				upload, bind, remove := server.index("UploadUserCertificate"), server.index(associate), server.index("DeleteUserCertificate")
+				if upload < 0 || bind < upload || remove < bind {
+					t.Fatalf("接口调用顺序不正确：%v", server.actions)
+				}
+				if certificate == certificateAdditional {
+					if dissociate := server.index("DissociateAdditionalCertificatesFromListener"); dissociate < bind || remove < dissociate {
+						t.Fatalf("接口调用顺序不正确：%v", server.actions)
+					}
+				}
+				if !slices.Equal(store.deleted, []int64{oldId}) {
+					t.Fatalf("删除的证书 = %v，期望删除旧证书 %d", store.deleted, oldId)
+				}
+			})
+		}
+	}
+}
+
+func TestListenerRollback(t *testing.T) {
+	for _, product := range []listenerProduct{albProduct, nlbProduct} {
+		for _, certificate := range []string{certificateDefault, certificateAdditional} {
+			t.Run(product.service+"/"+certificate, func(t *testing.T) {
+				server, store, fake, listener := newListenerTest(t, product, certificate)
+				oldCertId := strconv.FormatInt(store.add(newOldCert(t, "api.example.com").Bundle.CrtPEM), 10)
+				fake.add(oldCertId, certificate == certificateDefault)
+				fake.stuck = true
+
+				err := listener.Deploy(context.Background(), newLocalCert(t, "api.example.com"))
+				if err == nil || !strings.Contains(err.Error(), "已回滚到旧证书 "+oldCertId) {
+					t.Fatalf("err = %v", err)
+				}
+				// 回滚后只剩旧证书，默认证书改回旧证书，扩展证书解除新证书
+				fake.stuck = false
+				fake.progress()
+				if got := fake.associated(); !slices.Equal(got, []string{oldCertId}) {
+					t.Fatalf("监听关联的证书 = %v，期望只有旧证书 %s", got, oldCertId)
+				}
+				if certificate == certificateDefault && strings.Count(strings.Join(server.actions, ","), "UpdateListenerAttribute") != 2 {
+					t.Fatalf("接口调用 = %v，期望改回旧的默认证书", server.actions)
+				}
+				if len(store.deleted) != 1 || strconv.FormatInt(store.deleted[0], 10) == oldCertId {
+					t.Fatalf("删除的证书 = %v，期望只删除新证书", store.deleted)
+				}
+			})
+		}
+	}
+}
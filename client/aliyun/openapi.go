@@ -0,0 +1,65 @@
+package aliyun
+
+import (
+	"encoding/json"
+	"fmt"
+
+	openapi "github.com/alibabacloud-go/darabonba-openapi/v2/client"
+	util "github.com/alibabacloud-go/tea-utils/v2/service"
+	"github.com/alibabacloud-go/tea/tea"
+)
+
+// 没有引入专用 SDK 的产品（云解析、CDN 等）通过 OpenAPI 通用接口调用
+type rpcClient struct {
+	// 错误信息中使用的服务名称
+	service string
+	version string
+	client  *openapi.Client
+}
+
+func newRpcClient(access AccessConfig, service string, endpoint string, version string) (*rpcClient, error) {
+	config, err := newOpenapiConfig(access, service, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("获取 %s 客户端发生异常：%w", service, err)
+	}
+	client, err := openapi.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("获取 %s 客户端发生异常：%w", service, err)
+	}
+	return &rpcClient{service: service, version: version, client: client}, nil
+}
+
+// 调用 RPC 风格接口，返回体解析到 result 中，result 为 nil 时忽略返回体
+func (c *rpcClient) call(action string, query map[string]any, result any) error {
+	params := &openapi.Params{
+		Action:      tea.String(action),
+		Version:     tea.String(c.version),
+		Protocol:    tea.String("HTTPS"),
+		Pathname:    tea.String("/"),
+		Method:      tea.String("POST"),
+		AuthType:    tea.String("AK"),
+		Style:       tea.String("RPC"),
+		ReqBodyType: tea.String("formData"),
+		BodyType:    tea.String("json"),
+	}
+	request := &openapi.OpenApiRequest{Query: make(map[string]*string)}
+	for key, value := range query {
+		request.Query[key] = tea.String(fmt.Sprint(value))
+	}
+
+	response, err := c.client.CallApi(params, request, &util.RuntimeOptions{})
+	if err != nil {
+		return sdkError(c.service, action, err)
+	}
+	if result == nil {
+		return nil
+	}
+	body, err := json.Marshal(response["body"])
+	if err != nil {
+		return fmt.Errorf("%s %s 返回体解析异常：%w", c.service, action, err)
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("%s %s 返回体解析异常：%w", c.service, action, err)
+	}
+	return nil
+}
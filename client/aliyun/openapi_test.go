@@ -0,0 +1,207 @@
+package aliyun
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/target"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 模拟阿里云 RPC 风格接口，按 Action 分发到注册的处理函数
+type rpcServer struct {
+	mu       sync.Mutex
+	handlers map[string]rpcHandler
+	// 收到的接口调用，按顺序记录 Action
+	actions []string
+	url     string
+	// 非 RPC 风格的请求（例如 OSS），在持有锁时调用，自行记录 actions
+	rest http.Handler
+}
+
+// 返回值为返回体和错误码，错误码不为空时按接口错误返回
+type rpcHandler func(form url.Values) (any, string)
+
+func newRpcServer(t *testing.T) *rpcServer {
+	t.Helper()
+	server := &rpcServer{handlers: make(map[string]rpcHandler)}
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	server.url = httpServer.URL
+	return server
+}
+
+func (s *rpcServer) handle(action string, handler rpcHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[action] = handler
+}
+
+func (s *rpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// 接口名称在 x-acs-action 请求头中，参数在查询字符串或表单中
+	_ = r.ParseForm()
+	action := r.Header.Get("x-acs-action")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if action == "" && s.rest != nil {
+		s.rest.ServeHTTP(w, r)
+		return
+	}
+	s.actions = append(s.actions, action)
+
+	result, code := any(nil), "InvalidAction.NotFound"
+	if handler, ok := s.handlers[action]; ok {
+		result, code = handler(r.Form)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if code != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{"Code": code, "Message": code, "RequestId": "req-1"})
+		return
+	}
+	if result == nil {
+		result = map[string]string{}
+	}
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// 收到的接口调用中是否有 action
+func (s *rpcServer) called(action string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Contains(s.actions, action)
+}
+
+// 接口第一次被调用的顺序，没有调用时为 -1
+func (s *rpcServer) index(action string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Index(s.actions, action)
+}
+
+// 指向模拟服务的阿里云配置
+func (s *rpcServer) aliyunConfig() config.Aliyun {
+	return config.Aliyun{AccessKeyId: "id", AccessKeySecret: "secret", CasEndpoint: s.url, Endpoint: s.url}
+}
+
+// 模拟 CAS 中上传的证书
+type casStore struct {
+	nextId int64
+	// 证书标识中的地域，默认为 cn-hangzhou
+	region string
+	certs  map[int64]string
+	// 已部署到云产品的证书
+	deployed map[int64]bool
+	deleted  []int64
+}
+
+// 在模拟服务上注册 CAS 接口，证书 ID 从 1001 开始分配
+func (s *rpcServer) withCas() *casStore {
+	store := &casStore{nextId: 1000, region: defaultCasRegion, certs: make(map[int64]string), deployed: make(map[int64]bool)}
+	certId := func(form url.Values) int64 {
+		id, _ := strconv.ParseInt(form.Get("CertId"), 10, 64)
+		return id
+	}
+	s.handle("UploadUserCertificate", func(form url.Values) (any, string) {
+		store.nextId++
+		store.certs[store.nextId] = form.Get("Cert")
+		return map[string]int64{"CertId": store.nextId}, ""
+	})
+	s.handle("GetUserCertificateDetail", func(form url.Values) (any, string) {
+		id := certId(form)
+		cert, ok := store.certs[id]
+		if !ok {
+			return nil, "NotFound"
+		}
+		return map[string]any{"Id": id, "CertIdentifier": strconv.FormatInt(id, 10) + "-" + store.region, "Expired": false, "Cert": cert}, ""
+	})
+	s.handle("DeleteUserCertificate", func(form url.Values) (any, string) {
+		id := certId(form)
+		if _, ok := store.certs[id]; !ok {
+			return nil, "NotFound"
+		}
+		delete(store.certs, id)
+		store.deleted = append(store.deleted, id)
+		return nil, ""
+	})
+	s.handle("ListUserCertificateOrder", func(form url.Values) (any, string) {
+		return map[string]any{"TotalCount": 0, "CertificateOrderList": []casCertOrder{}}, ""
+	})
+	s.handle("ListCloudResources", func(form url.Values) (any, string) {
+		var data []map[string]int64
+		for id := range store.deployed {
+			data = append(data, map[string]int64{"CertId": id})
+		}
+		return map[string]any{"Total": len(data), "Data": data}, ""
+	})
+	return store
+}
+
+// 直接放入一张证书，模拟之前部署时上传的证书
+func (store *casStore) add(certPEM string) int64 {
+	store.nextId++
+	store.certs[store.nextId] = certPEM
+	return store.nextId
+}
+
+// 模拟 CAS 返回的证书标识，例如 1001-cn-hangzhou
+func identifierOf(certId int64) string {
+	return strconv.FormatInt(certId, 10) + "-" + defaultCasRegion
+}
+
+// 生成覆盖 domains 的自签名本地证书
+func newLocalCert(t *testing.T, domains ...string) target.Cert {
+	t.Helper()
+	return newCertValid(t, time.Now().Add(-time.Hour), time.Now().AddDate(0, 3, 0), domains...)
+}
+
+// 生成之前签发、即将过期的证书，作为远端的旧证书
+func newOldCert(t *testing.T, domains ...string) target.Cert {
+	t.Helper()
+	return newCertValid(t, time.Now().AddDate(0, 0, -80), time.Now().AddDate(0, 0, 10), domains...)
+}
+
+func newCertValid(t *testing.T, notBefore time.Time, notAfter time.Time, domains ...string) target.Cert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: domains[0]},
+		DNSNames:     domains,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := utils.ParseCertBundle(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return target.Cert{Name: domains[0], Bundle: bundle}
+}
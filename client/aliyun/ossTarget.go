@@ -0,0 +1,458 @@
+package aliyun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	cas20200407 "github.com/alibabacloud-go/cas-20200407/v4/client"
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"sort"
+	"strings"
+	"time"
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/target"
+	"whoyang.cn/update_cert/utils"
+)
+
+func init() {
+	target.Register(config.TypeAliyunOss, NewOssTarget)
+}
+
+// 阿里云 OSS 域名证书部署目标，一次更新多个 Bucket 的多个绑定域名，证书只上传一次
+type ossTarget struct {
+	name      string
+	access    AccessConfig
+	oss       OssConfig
+	ossClient *oss.Client
+	casClient *cas20200407.Client
+	policy    target.Policy
+	// 未配置服务地址时按 Bucket 所在地域生成
+	regional bool
+
+	// 需要更新的绑定域名，Resolve 时查找
+	bindings []*ossBinding
+	// 查找绑定域名的 Bucket 及其所在地域的客户端，Resolve 时查找
+	clients map[string]*oss.Client
+	// 本次部署绑定的证书标识
+	deployedCertId string
+}
+
+// Bucket 绑定的域名
+type ossBinding struct {
+	bucket string
+	domain string
+	// Bucket 所在地域的 OSS 客户端
+	client *oss.Client
+	// 当前绑定的证书，未添加证书时为 nil
+	current *target.RemoteCert
+	// 是否需要更新，NeedRenew 时判断
+	renew bool
+}
+
+// 根据配置创建阿里云 OSS 部署目标
+func NewOssTarget(targetConfig config.Target) (target.Target, error) {
+	access, err := newAccessConfig(targetConfig.Aliyun)
+	if err != nil {
+		return nil, err
+	}
+	access.Target = targetConfig.Name
+	ossConfig := OssConfig{
+		Endpoint: targetConfig.Aliyun.OssEndpoint,
+		Domain:   targetConfig.Aliyun.Domain,
+	}
+	if targetConfig.Aliyun.BucketName != "" {
+		ossConfig.Buckets = append(ossConfig.Buckets, targetConfig.Aliyun.BucketName)
+	}
+	ossConfig.Buckets = append(ossConfig.Buckets, targetConfig.Aliyun.Buckets...)
+
+	//未配置服务地址时先用 region（默认 cn-hangzhou）的地址查询 Bucket 所在地域
+	regional := ossConfig.Endpoint == ""
+	if regional {
+		region := targetConfig.Aliyun.Region
+		if region == "" {
+			region = "cn-hangzhou"
+		}
+		ossConfig.Endpoint = "https://oss-" + region + ".aliyuncs.com"
+	}
+
+	policy, err := target.NewPolicy(targetConfig.Renew)
+	if err != nil {
+		return nil, err
+	}
+
+	ossClient, err := getOssClient(access, ossConfig.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	casClient, err := getCasClient(access, CasEndpoint(targetConfig.Aliyun))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ossTarget{
+		name:      targetConfig.Name,
+		access:    access,
+		oss:       ossConfig,
+		ossClient: ossClient,
+		casClient: casClient,
+		policy:    policy,
+		regional:  regional,
+	}, nil
+}
+
+func (t *ossTarget) Name() string {
+	return t.name
+}
+
+func (t *ossTarget) Domains() []string {
+	if len(t.bindings) == 0 && t.oss.Domain != "" {
+		return []string{t.oss.Domain}
+	}
+	var domains []string
+	for _, binding := range t.bindings {
+		domains = append(domains, binding.domain)
+	}
+	return domains
+}
+
+// 查找需要更新的绑定域名：配置了 domain 时只更新该域名，否则更新本地证书覆盖的全部域名（支持通配符）
+func (t *ossTarget) Resolve(ctx context.Context, local target.Cert) error {
+	buckets, err := t.buckets()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(buckets))
+	for bucket := range buckets {
+		names = append(names, bucket)
+	}
+	sort.Strings(names)
+
+	t.clients = buckets
+	t.bindings = nil
+	for _, bucket := range names {
+		client := buckets[bucket]
+		cnames, err := listBucketCnames(client, bucket)
+		if err != nil {
+			return fmt.Errorf("Bucket %s：%w", bucket, err)
+		}
+		for _, cname := range cnames {
+			if t.oss.Domain != "" && cname.Domain != t.oss.Domain {
+				continue
+			}
+			if t.oss.Domain == "" && !utils.CertCoversDomain(local.Bundle.Leaf, cname.Domain) {
+				continue
+			}
+			t.bindings = append(t.bindings, &ossBinding{bucket: bucket, domain: cname.Domain, client: client})
+		}
+	}
+
+	if len(t.bindings) == 0 {
+		if t.oss.Domain != "" {
+			return fmt.Errorf("当前对象存储的Bucket %s 未绑定域名 %s：%w", strings.Join(t.oss.Buckets, ","), t.oss.Domain, utils.ErrNotFound)
+		}
+		return fmt.Errorf("对象存储中没有本地证书（%s）覆盖的绑定域名：%w", strings.Join(local.Bundle.Leaf.DNSNames, ","), utils.ErrNotFound)
+	}
+	for _, binding := range t.bindings {
+		utils.Println("匹配到 OSS 绑定域名：", binding.domain, "（ Bucket", binding.bucket, "）")
+	}
+	return nil
+}
+
+// 需要查找绑定域名的 Bucket 及其所在地域的客户端，未配置时列举账号下全部 Bucket
+func (t *ossTarget) buckets() (map[string]*oss.Client, error) {
+	buckets := make(map[string]*oss.Client)
+	clients := make(map[string]*oss.Client)
+	//Bucket 所在地域的客户端，相同地域共用
+	regionalClient := func(location string) (*oss.Client, error) {
+		endpoint := regionalOssEndpoint(t.oss.Endpoint, location)
+		if client, ok := clients[endpoint]; ok {
+			return client, nil
+		}
+		client, err := getOssClient(t.access, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		clients[endpoint] = client
+		return client, nil
+	}
+
+	if len(t.oss.Buckets) > 0 {
+		for _, bucket := range t.oss.Buckets {
+			//配置了服务地址时直接使用，否则查询 Bucket 所在地域
+			if !t.regional {
+				buckets[bucket] = t.ossClient
+				continue
+			}
+			location, err := getBucketLocation(t.ossClient, bucket)
+			if err != nil {
+				return nil, fmt.Errorf("Bucket %s：%w", bucket, err)
+			}
+			if buckets[bucket], err = regionalClient(location); err != nil {
+				return nil, err
+			}
+		}
+		return buckets, nil
+	}
+
+	properties, err := listBuckets(t.ossClient)
+	if err != nil {
+		return nil, err
+	}
+	for _, bucket := range properties {
+		if buckets[bucket.Name], err = regionalClient(bucket.Location); err != nil {
+			return nil, err
+		}
+	}
+	return buckets, nil
+}
+
+// 获取全部绑定域名当前的证书，返回剩余有效期最短的证书，有域名未添加证书时返回 nil
+func (t *ossTarget) Describe(ctx context.Context) (*target.RemoteCert, error) {
+	if err := t.refresh(); err != nil {
+		return nil, err
+	}
+	var earliest *target.RemoteCert
+	for _, binding := range t.bindings {
+		if binding.current == nil {
+			return nil, nil
+		}
+		if earliest == nil || binding.current.NotAfter.Before(earliest.NotAfter) {
+			earliest = binding.current
+		}
+	}
+	return earliest, nil
+}
+
+// 重新查询绑定域名当前的证书，同一张证书只从 CAS 获取一次内容
+func (t *ossTarget) refresh() error {
+	cnames := make(map[string][]oss.Cname)
+	remotes := make(map[string]*target.RemoteCert)
+	for _, binding := range t.bindings {
+		if _, ok := cnames[binding.bucket]; !ok {
+			list, err := listBucketCnames(binding.client, binding.bucket)
+			if err != nil {
+				return fmt.Errorf("Bucket %s：%w", binding.bucket, err)
+			}
+			cnames[binding.bucket] = list
+		}
+
+		var certificate *oss.Certificate
+		for _, cname := range cnames[binding.bucket] {
+			if cname.Domain == binding.domain {
+				certificate = &cname.Certificate
+				break
+			}
+		}
+		if certificate == nil {
+			return fmt.Errorf("当前对象存储的Bucket %s 未绑定域名 %s：%w", binding.bucket, binding.domain, utils.ErrNotFound)
+		}
+		// 已绑定域名但未添加证书
+		if certificate.CertId == "" {
+			binding.current = nil
+			continue
+		}
+
+		remote, ok := remotes[certificate.CertId]
+		if !ok {
+			validEndDate, _ := time.Parse("Jan 02 15:04:05 2006 MST", certificate.ValidEndDate)
+			remote = &target.RemoteCert{
+				Id:       certificate.CertId,
+				Domains:  []string{binding.domain},
+				NotAfter: validEndDate,
+			}
+			//从 CAS 获取绑定证书的内容用于指纹比较，失败时只按有效期判断
+			if err := fillRemoteCert(t.casClient, remote); err != nil {
+				utils.Println(binding.domain, "绑定的证书内容无法获取，按有效期判断是否更新：", err)
+			}
+			remotes[certificate.CertId] = remote
+		}
+		binding.current = remote
+	}
+	return nil
+}
+
+// 逐个域名按策略判断，有任一域名需要更新时部署，只更新需要更新的域名
+func (t *ossTarget) NeedRenew(ctx context.Context, current *target.RemoteCert, local target.Cert) (bool, string, error) {
+	now := time.Now()
+	var renewReasons, skipReasons []string
+	for _, binding := range t.bindings {
+		renew, reason := t.policy.Decide(binding.current, local, now)
+		binding.renew = renew
+		if renew {
+			renewReasons = append(renewReasons, binding.domain+"："+reason)
+		} else {
+			skipReasons = append(skipReasons, binding.domain+"："+reason)
+		}
+	}
+	if len(renewReasons) == 0 {
+		return false, strings.Join(skipReasons, "；"), nil
+	}
+	return true, strings.Join(renewReasons, "；"), nil
+}
+
+func (t *ossTarget) Plan(ctx context.Context, current *target.RemoteCert, local target.Cert) ([]target.Step, error) {
+	steps := []target.Step{planUpload(t.casClient, local)}
+	previousCertIds := make(map[string]bool)
+	for _, binding := range t.bindings {
+		if !binding.renew {
+			continue
+		}
+		steps = append(steps, target.Step{Action: target.StepBind,
+			Detail: fmt.Sprintf("Bucket %s 的绑定域名 %s 切换到新证书", binding.bucket, binding.domain)})
+		if binding.current != nil {
+			previousCertIds[binding.current.Id] = true
+		}
+	}
+	//仍被不需要更新的域名使用的旧证书不删除
+	for _, binding := range t.bindings {
+		if !binding.renew && binding.current != nil {
+			delete(previousCertIds, binding.current.Id)
+		}
+	}
+	ids := make([]string, 0, len(previousCertIds))
+	for previousCertId := range previousCertIds {
+		ids = append(ids, previousCertId)
+	}
+	sort.Strings(ids)
+	for _, previousCertId := range ids {
+		steps = append(steps, planDeleteOld("CAS 证书", previousCertId)...)
+	}
+	return steps, nil
+}
+
+func (t *ossTarget) Deploy(ctx context.Context, local target.Cert) error {
+	var pending []*ossBinding
+	for _, binding := range t.bindings {
+		if binding.renew {
+			pending = append(pending, binding)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	//1、2、读取本地证书上传到 CAS 并校验，此时域名仍使用旧证书，全部域名共用这一张证书
+	cert, err := uploadCheckedCert(t.casClient, pending[0].domain, local)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	previousCertIds := make(map[string]bool)
+	for _, binding := range pending {
+		previousCertId := ""
+		if binding.current != nil {
+			previousCertId = binding.current.Id
+		}
+		if err := t.bind(binding, cert.identifier, previousCertId); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if previousCertId != "" && previousCertId != cert.identifier {
+			previousCertIds[previousCertId] = true
+		}
+	}
+
+	//全部域名都未能切换时删除新证书
+	if len(errs) == len(pending) {
+		discardCert(t.casClient, cert)
+		return errors.Join(errs...)
+	}
+	t.deployedCertId = cert.identifier
+
+	//5、新证书生效后再删除不再被任何域名使用的旧证书，包括未更新的域名
+	if inUse, err := t.boundCertIds(); err == nil {
+		for previousCertId := range previousCertIds {
+			if !inUse[previousCertId] {
+				deletePreviousCert(t.casClient, previousCertId, cert)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 查找的 Bucket 中全部绑定域名当前使用的证书标识
+func (t *ossTarget) boundCertIds() (map[string]bool, error) {
+	inUse := make(map[string]bool)
+	for bucket, client := range t.clients {
+		cnames, err := listBucketCnames(client, bucket)
+		if err != nil {
+			return nil, fmt.Errorf("Bucket %s：%w", bucket, err)
+		}
+		for _, cname := range cnames {
+			if cname.Certificate.CertId != "" {
+				inUse[cname.Certificate.CertId] = true
+			}
+		}
+	}
+	return inUse, nil
+}
+
+// 将单个域名切换到新证书并确认绑定已生效，失败时回滚到旧证书
+func (t *ossTarget) bind(binding *ossBinding, certIdStr string, previousCertId string) error {
+	//3、一次性将域名绑定切换到新证书
+	if err := putBucketCert(binding.client, binding.bucket, binding.domain, certIdStr, previousCertId); err != nil {
+		return fmt.Errorf("%s：%w", binding.domain, err)
+	}
+
+	//4、确认绑定已生效，失败时回滚到旧证书，无法查询时按已切换处理
+	boundCertId, err := t.boundCertId(binding)
+	if err != nil {
+		boundCertId = certIdStr
+	} else if boundCertId != certIdStr {
+		err = fmt.Errorf("%s 域名绑定的证书未切换到 %s", binding.domain, certIdStr)
+	}
+	if err != nil {
+		if rollbackErr := t.rollback(binding, previousCertId, boundCertId); rollbackErr != nil {
+			return fmt.Errorf("%w；回滚到旧证书 %s 失败：%v", err, previousCertId, rollbackErr)
+		}
+		return fmt.Errorf("%w，已回滚到旧证书 %s", err, previousCertId)
+	}
+	return nil
+}
+
+// 查询域名当前绑定的证书标识
+func (t *ossTarget) boundCertId(binding *ossBinding) (string, error) {
+	cnames, err := listBucketCnames(binding.client, binding.bucket)
+	if err != nil {
+		return "", err
+	}
+	for _, cname := range cnames {
+		if cname.Domain == binding.domain {
+			return cname.Certificate.CertId, nil
+		}
+	}
+	return "", fmt.Errorf("当前对象存储的Bucket %s 未绑定域名 %s：%w", binding.bucket, binding.domain, utils.ErrNotFound)
+}
+
+// 将域名绑定恢复为旧证书，原来没有证书时解除绑定；boundCertId 为域名当前绑定的证书，仍是旧证书时无需恢复
+func (t *ossTarget) rollback(binding *ossBinding, previousCertId string, boundCertId string) error {
+	if boundCertId == previousCertId {
+		return nil
+	}
+	if previousCertId == "" {
+		return deleteBucketCert(binding.client, binding.bucket, binding.domain)
+	}
+	return putBucketCert(binding.client, binding.bucket, binding.domain, previousCertId, boundCertId)
+}
+
+func (t *ossTarget) Verify(ctx context.Context, local target.Cert) error {
+	var errs []error
+	for _, binding := range t.bindings {
+		if !binding.renew {
+			continue
+		}
+		boundCertId, err := t.boundCertId(binding)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if boundCertId != t.deployedCertId {
+			errs = append(errs, fmt.Errorf("%s 域名绑定的证书与上传的证书不一致", binding.domain))
+			continue
+		}
+		utils.Printf("%s 域名对应的证书更新成功，结束操作\n", binding.domain)
+	}
+	return errors.Join(errs...)
+}
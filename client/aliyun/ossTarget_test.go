@@ -0,0 +1,316 @@
+package aliyun
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/target"
+)
+
+// 模拟 OSS 的 Bucket 绑定域名接口，服务地址为 IP 时 SDK 使用 /bucket/?cname 形式的路径
+type ossFake struct {
+	server *rpcServer
+	// Bucket -> 绑定的域名
+	buckets map[string][]oss.Cname
+	// 切换请求返回成功但不生效的域名，用于触发回滚
+	stuck map[string]bool
+	// 切换请求返回错误的域名
+	failed map[string]bool
+	// 为 true 时有切换请求后查询绑定域名失败
+	listFailed bool
+	// 绑定证书的请求，按顺序记录
+	puts []oss.PutBucketCname
+}
+
+func newOssFake(server *rpcServer) *ossFake {
+	fake := &ossFake{server: server, buckets: make(map[string][]oss.Cname), stuck: make(map[string]bool), failed: make(map[string]bool)}
+	server.rest = fake
+	return fake
+}
+
+// 绑定域名，certId 为空时表示未添加证书
+func (fake *ossFake) addCname(bucket string, domain string, certId string) {
+	fake.buckets[bucket] = append(fake.buckets[bucket], oss.Cname{Domain: domain, Status: "Enabled", Certificate: oss.Certificate{
+		CertId: certId, ValidEndDate: time.Now().AddDate(0, 0, 10).UTC().Format("Jan 02 15:04:05 2006 MST"),
+	}})
+}
+
+// 域名当前绑定的证书标识
+func (fake *ossFake) certId(domain string) string {
+	for _, cnames := range fake.buckets {
+		for _, cname := range cnames {
+			if cname.Domain == domain {
+				return cname.Certificate.CertId
+			}
+		}
+	}
+	return ""
+}
+
+// 在模拟服务的锁内调用
+func (fake *ossFake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket := strings.Trim(r.URL.Path, "/")
+	query := r.URL.Query()
+	writeError := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = io.WriteString(w, "<Error><Code>"+code+"</Code><Message>"+code+"</Message><RequestId>req-1</RequestId></Error>")
+	}
+	switch {
+	case bucket == "" && r.Method == http.MethodGet:
+		fake.server.actions = append(fake.server.actions, "ListBuckets")
+		var names []string
+		for name := range fake.buckets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		result := oss.ListBucketsResult{}
+		for _, name := range names {
+			result.Buckets = append(result.Buckets, oss.BucketProperties{Name: name, Location: "oss-cn-hangzhou"})
+		}
+		_ = xml.NewEncoder(w).Encode(result)
+	case query.Has("cname") && r.Method == http.MethodGet:
+		fake.server.actions = append(fake.server.actions, "ListBucketCname")
+		cnames, ok := fake.buckets[bucket]
+		if fake.listFailed && len(fake.puts) > 0 {
+			writeError("InternalError")
+			return
+		}
+		if !ok {
+			writeError("NoSuchBucket")
+			return
+		}
+		_ = xml.NewEncoder(w).Encode(oss.ListBucketCnameResult{Bucket: bucket, Cname: cnames})
+	case query.Has("cname") && query.Get("comp") == "add":
+		fake.server.actions = append(fake.server.actions, "PutBucketCnameWithCertificate")
+		var put oss.PutBucketCname
+		if err := xml.NewDecoder(r.Body).Decode(&put); err != nil {
+			writeError("MalformedXML")
+			return
+		}
+		fake.puts = append(fake.puts, put)
+		if fake.failed[put.Cname] {
+			writeError("InternalError")
+			return
+		}
+		for i, cname := range fake.buckets[bucket] {
+			if cname.Domain != put.Cname {
+				continue
+			}
+			certificate := put.CertificateConfiguration
+			// 一次性替换时 PreviousCertId 需要与当前证书一致
+			if !certificate.DeleteCertificate && cname.Certificate.CertId != "" && certificate.PreviousCertId != cname.Certificate.CertId {
+				writeError("CertIdMismatch")
+				return
+			}
+			if fake.stuck[put.Cname] {
+				return
+			}
+			fake.buckets[bucket][i].Certificate = oss.Certificate{CertId: certificate.CertId,
+				ValidEndDate: time.Now().AddDate(0, 3, 0).UTC().Format("Jan 02 15:04:05 2006 MST")}
+			return
+		}
+		writeError("NoSuchCname")
+	default:
+		writeError("NotImplemented")
+	}
+}
+
+func newOssTest(t *testing.T, aliyunConfig func(*config.Aliyun)) (*rpcServer, *casStore, *ossFake, *ossTarget) {
+	t.Helper()
+	server := newRpcServer(t)
+	store := server.withCas()
+	fake := newOssFake(server)
+
+	ossConfig := server.aliyunConfig()
+	ossConfig.OssEndpoint = server.url
+	aliyunConfig(&ossConfig)
+	bucketTarget, err := NewOssTarget(config.Target{Name: "oss", Aliyun: ossConfig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, store, fake, bucketTarget.(*ossTarget)
+}
+
+// 按 plan、apply 的顺序获取远端证书、判断并部署
+func deployOss(t *testing.T, bucketTarget *ossTarget, local target.Cert) error {
+	t.Helper()
+	ctx := context.Background()
+	if err := bucketTarget.Resolve(ctx, local); err != nil {
+		t.Fatal(err)
+	}
+	current, err := bucketTarget.Describe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renew, reason, _ := bucketTarget.NeedRenew(ctx, current, local); !renew {
+		t.Fatalf("期望需要部署：%s", reason)
+	}
+	if err := bucketTarget.Deploy(ctx, local); err != nil {
+		return err
+	}
+	return bucketTarget.Verify(ctx, local)
+}
+
+func TestOssDeployOrder(t *testing.T) {
+	server, store, fake, bucketTarget := newOssTest(t, func(aliyunConfig *config.Aliyun) {
+		aliyunConfig.BucketName = "static"
+	})
+	oldId := store.add(newOldCert(t, "img.example.com").Bundle.CrtPEM)
+	oldCertId := identifierOf(oldId)
+	fake.addCname("static", "img.example.com", oldCertId)
+
+	if err := deployOss(t, bucketTarget, newLocalCert(t, "img.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	newCertId := fake.certId("img.example.com")
+	if newCertId == oldCertId || newCertId != bucketTarget.deployedCertId {
+		t.Fatalf("绑定的证书 = %s，部署的证书 = %s", newCertId, bucketTarget.deployedCertId)
+	}
+	// 先上传到 CAS，再一次性切换绑定，确认生效后才删除旧证书
+	upload, put, remove := server.index("UploadUserCertificate"), server.index("PutBucketCnameWithCertificate"), server.index("DeleteUserCertificate")
+	if upload < 0 || put < upload || remove < put {
+		t.Fatalf("接口调用顺序不正确：%v", server.actions)
+	}
+	if len(fake.puts) != 1 || fake.puts[0].CertificateConfiguration.PreviousCertId != oldCertId {
+		t.Fatalf("绑定请求 = %+v，期望一次性替换 %s", fake.puts, oldCertId)
+	}
+	if !slices.Equal(store.deleted, []int64{oldId}) {
+		t.Fatalf("删除的证书 = %v，期望删除旧证书 %d", store.deleted, oldId)
+	}
+}
+
+func TestOssRollback(t *testing.T) {
+	tests := []struct {
+		name string
+		// 原来是否已添加证书
+		previous bool
+		// 切换请求不生效，或切换后无法确认是否生效
+		stuck      bool
+		listFailed bool
+	}{
+		{name: "未生效时保留旧证书", previous: true, stuck: true},
+		{name: "原来没有证书且未生效", stuck: true},
+		{name: "无法确认时恢复为旧证书", previous: true, listFailed: true},
+		{name: "原来没有证书时解除绑定", listFailed: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, store, fake, bucketTarget := newOssTest(t, func(aliyunConfig *config.Aliyun) {
+				aliyunConfig.BucketName = "static"
+			})
+			oldCertId := ""
+			if test.previous {
+				oldCertId = identifierOf(store.add(newOldCert(t, "img.example.com").Bundle.CrtPEM))
+			}
+			fake.addCname("static", "img.example.com", oldCertId)
+			fake.stuck["img.example.com"] = test.stuck
+			fake.listFailed = test.listFailed
+
+			err := deployOss(t, bucketTarget, newLocalCert(t, "img.example.com"))
+			if err == nil || !strings.Contains(err.Error(), "已回滚到旧证书") {
+				t.Fatalf("err = %v", err)
+			}
+			// 切换未生效时域名仍使用旧证书，不再发送回滚请求
+			if test.stuck && len(fake.puts) != 1 {
+				t.Fatalf("绑定请求 = %+v，期望不发送回滚请求", fake.puts)
+			}
+			if test.listFailed {
+				rollback := fake.puts[len(fake.puts)-1].CertificateConfiguration
+				newCertId := fake.puts[0].CertificateConfiguration.CertId
+				if test.previous && (rollback.CertId != oldCertId || rollback.PreviousCertId != newCertId || rollback.DeleteCertificate) {
+					t.Fatalf("回滚请求 = %+v，期望从 %s 恢复为 %s", rollback, newCertId, oldCertId)
+				}
+				if !test.previous && !rollback.DeleteCertificate {
+					t.Fatalf("回滚请求 = %+v，期望解除证书绑定", rollback)
+				}
+			}
+			if fake.certId("img.example.com") != oldCertId {
+				t.Fatalf("回滚后绑定 %s，期望 %q", fake.certId("img.example.com"), oldCertId)
+			}
+			// 全部域名都未能切换时删除新上传的证书，旧证书保留
+			if len(store.deleted) != 1 || identifierOf(store.deleted[0]) == oldCertId {
+				t.Fatalf("删除的证书 = %v", store.deleted)
+			}
+		})
+	}
+}
+
+func TestOssMultipleBuckets(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(*config.Aliyun)
+		// 期望切换到新证书的域名
+		want []string
+	}{
+		{"列举全部 Bucket", func(*config.Aliyun) {}, []string{"cdn.example.com", "img.example.com", "www.example.com"}},
+		{"指定多个 Bucket", func(aliyunConfig *config.Aliyun) {
+			aliyunConfig.BucketName = "static"
+			aliyunConfig.Buckets = []string{"assets"}
+		}, []string{"cdn.example.com", "img.example.com", "www.example.com"}},
+		{"只更新指定 Bucket", func(aliyunConfig *config.Aliyun) {
+			aliyunConfig.Buckets = []string{"assets"}
+		}, []string{"cdn.example.com"}},
+		{"只更新指定域名", func(aliyunConfig *config.Aliyun) {
+			aliyunConfig.Domain = "www.example.com"
+		}, []string{"www.example.com"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, store, fake, bucketTarget := newOssTest(t, test.config)
+			local := newLocalCert(t, "*.example.com")
+			oldId := store.add(newOldCert(t, "*.example.com").Bundle.CrtPEM)
+			fake.addCname("static", "img.example.com", identifierOf(oldId))
+			fake.addCname("static", "www.example.com", identifierOf(oldId))
+			// 本地证书不覆盖的域名不更新
+			fake.addCname("static", "www.example.org", "")
+			fake.addCname("assets", "cdn.example.com", "")
+
+			if err := deployOss(t, bucketTarget, local); err != nil {
+				t.Fatal(err)
+			}
+			var updated []string
+			for _, domain := range []string{"cdn.example.com", "img.example.com", "www.example.com", "www.example.org"} {
+				if certId := fake.certId(domain); certId != "" && certId == bucketTarget.deployedCertId {
+					updated = append(updated, domain)
+				}
+			}
+			if !slices.Equal(updated, test.want) {
+				t.Fatalf("切换到新证书的域名 = %v，期望 %v", updated, test.want)
+			}
+			// 旧证书仍被未更新的域名使用时不删除
+			stillUsed := !slices.Contains(test.want, "img.example.com") || !slices.Contains(test.want, "www.example.com")
+			if slices.Contains(store.deleted, oldId) == stillUsed {
+				t.Fatalf("删除的证书 = %v，旧证书仍在使用 %v", store.deleted, stillUsed)
+			}
+		})
+	}
+}
+
+func TestOssPartialFailure(t *testing.T) {
+	_, store, fake, bucketTarget := newOssTest(t, func(*config.Aliyun) {})
+	oldId := store.add(newOldCert(t, "*.example.com").Bundle.CrtPEM)
+	fake.addCname("static", "img.example.com", identifierOf(oldId))
+	fake.addCname("assets", "cdn.example.com", identifierOf(oldId))
+	fake.failed["cdn.example.com"] = true
+
+	err := deployOss(t, bucketTarget, newLocalCert(t, "*.example.com"))
+	if err == nil || !strings.Contains(err.Error(), "cdn.example.com") {
+		t.Fatalf("err = %v，期望包含失败的域名", err)
+	}
+	// 部分域名切换成功时保留新证书，旧证书仍被失败的域名使用，也不删除
+	if fake.certId("img.example.com") != bucketTarget.deployedCertId || fake.certId("cdn.example.com") != identifierOf(oldId) {
+		t.Fatalf("img 绑定 %s，cdn 绑定 %s", fake.certId("img.example.com"), fake.certId("cdn.example.com"))
+	}
+	if len(store.deleted) != 0 {
+		t.Fatalf("删除的证书 = %v，期望都保留", store.deleted)
+	}
+}
@@ -0,0 +1,519 @@
+package aliyun
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	cas20200407 "github.com/alibabacloud-go/cas-20200407/v4/client"
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/target"
+	"whoyang.cn/update_cert/utils"
+)
+
+func init() {
+	target.Register(config.TypeAliyunSlb, NewSlbTarget)
+}
+
+// 负载均衡替换的证书
+const (
+	certificateDefault    = "default"
+	certificateAdditional = "additional"
+)
+
+// 阿里云传统型负载均衡 CLB（原 SLB）HTTPS 监听证书部署目标
+//
+// CLB 不能直接使用 CAS 证书，需要先将 CAS 证书导入为 CLB 的服务器证书，
+// 默认证书绑定在监听上，扩展证书绑定在监听的扩展域名上
+type slbTarget struct {
+	name           string
+	region         string
+	loadBalancerId string
+	listenerPort   int
+	domain         string
+	additional     bool
+	client         *rpcClient
+	casClient      *cas20200407.Client
+	policy         target.Policy
+
+	// 本次部署绑定的服务器证书 ID
+	deployedCertId string
+}
+
+// 根据配置创建 CLB 部署目标
+func NewSlbTarget(targetConfig config.Target) (target.Target, error) {
+	access, err := newAccessConfig(targetConfig.Aliyun)
+	if err != nil {
+		return nil, err
+	}
+	access.Target = targetConfig.Name
+	aliyunConfig := targetConfig.Aliyun
+	if aliyunConfig.Region == "" {
+		return nil, fmt.Errorf("CLB 所在地域 region 不能为空")
+	}
+	if aliyunConfig.LoadBalancerId == "" {
+		return nil, fmt.Errorf("CLB 实例 ID load_balancer_id 不能为空")
+	}
+	if aliyunConfig.ListenerPort == 0 {
+		return nil, fmt.Errorf("CLB HTTPS 监听端口 listener_port 不能为空")
+	}
+	additional, err := isAdditional(aliyunConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := target.NewPolicy(targetConfig.Renew)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := aliyunConfig.Endpoint
+	if endpoint == "" {
+		endpoint = "slb." + aliyunConfig.Region + ".aliyuncs.com"
+	}
+	client, err := newRpcClient(access, "CLB", endpoint, "2014-05-15")
+	if err != nil {
+		return nil, err
+	}
+	casClient, err := getCasClient(access, CasEndpoint(aliyunConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	return &slbTarget{
+		name:           targetConfig.Name,
+		region:         aliyunConfig.Region,
+		loadBalancerId: aliyunConfig.LoadBalancerId,
+		listenerPort:   aliyunConfig.ListenerPort,
+		domain:         aliyunConfig.Domain,
+		additional:     additional,
+		client:         client,
+		casClient:      casClient,
+		policy:         policy,
+	}, nil
+}
+
+// 替换扩展证书时需要填写扩展证书对应的域名
+func isAdditional(aliyunConfig config.Aliyun) (bool, error) {
+	switch aliyunConfig.Certificate {
+	case "", certificateDefault:
+		return false, nil
+	case certificateAdditional:
+		if aliyunConfig.Domain == "" {
+			return false, fmt.Errorf("替换扩展证书时 domain 不能为空")
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("负载均衡证书类型 %s 不支持，可选：%s、%s",
+		aliyunConfig.Certificate, certificateDefault, certificateAdditional)
+}
+
+func (t *slbTarget) Name() string {
+	return t.name
+}
+
+func (t *slbTarget) Domains() []string {
+	if t.domain == "" {
+		return nil
+	}
+	return []string{t.domain}
+}
+
+// CLB 服务器证书
+type slbServerCert struct {
+	ServerCertificateId string `json:"ServerCertificateId"`
+	// 毫秒时间戳
+	ExpireTimeStamp         int64  `json:"ExpireTimeStamp"`
+	CommonName              string `json:"CommonName"`
+	AliCloudCertificateId   string `json:"AliCloudCertificateId"`
+	SubjectAlternativeNames struct {
+		SubjectAlternativeName []string `json:"SubjectAlternativeName"`
+	} `json:"SubjectAlternativeNames"`
+}
+
+// 监听当前绑定证书的位置
+type slbBinding struct {
+	serverCertId string
+	// 扩展证书所在的扩展域名，扩展域名不存在时为空
+	domainExtensionId string
+}
+
+// 查询监听或扩展域名当前绑定的服务器证书
+func (t *slbTarget) binding() (slbBinding, error) {
+	if !t.additional {
+		serverCertId, err := t.listenerCert(t.loadBalancerId, t.listenerPort)
+		if err != nil {
+			return slbBinding{}, err
+		}
+		return slbBinding{serverCertId: serverCertId}, nil
+	}
+
+	extensions, err := t.domainExtensions(t.loadBalancerId, t.listenerPort)
+	if err != nil {
+		return slbBinding{}, err
+	}
+	for _, extension := range extensions {
+		if extension.Domain == t.domain {
+			return slbBinding{serverCertId: extension.ServerCertificateId, domainExtensionId: extension.DomainExtensionId}, nil
+		}
+	}
+	return slbBinding{}, nil
+}
+
+// CLB HTTPS 监听的扩展域名
+type slbDomainExtension struct {
+	DomainExtensionId   string `json:"DomainExtensionId"`
+	Domain              string `json:"Domain"`
+	ServerCertificateId string `json:"ServerCertificateId"`
+}
+
+// 查询 HTTPS 监听绑定的默认服务器证书
+func (t *slbTarget) listenerCert(loadBalancerId string, listenerPort int) (string, error) {
+	result := struct {
+		ServerCertificateId string `json:"ServerCertificateId"`
+	}{}
+	err := t.client.call("DescribeLoadBalancerHTTPSListenerAttribute", map[string]any{
+		"RegionId":       t.region,
+		"LoadBalancerId": loadBalancerId,
+		"ListenerPort":   listenerPort,
+	}, &result)
+	return result.ServerCertificateId, err
+}
+
+// 查询 HTTPS 监听的扩展域名
+func (t *slbTarget) domainExtensions(loadBalancerId string, listenerPort int) ([]slbDomainExtension, error) {
+	result := struct {
+		DomainExtensions struct {
+			DomainExtension []slbDomainExtension `json:"DomainExtension"`
+		} `json:"DomainExtensions"`
+	}{}
+	err := t.client.call("DescribeDomainExtensions", map[string]any{
+		"RegionId":       t.region,
+		"LoadBalancerId": loadBalancerId,
+		"ListenerPort":   listenerPort,
+	}, &result)
+	return result.DomainExtensions.DomainExtension, err
+}
+
+// 分页查询 CLB 实例时每页的数量
+const slbPageSize = 100
+
+// 服务器证书是否仍被地域内任一 CLB 的 HTTPS 监听或扩展域名使用
+func (t *slbTarget) serverCertInUse(serverCertId string) (bool, error) {
+	for page, total := 1, 0; ; page++ {
+		result := struct {
+			TotalCount    int `json:"TotalCount"`
+			LoadBalancers struct {
+				LoadBalancer []struct {
+					LoadBalancerId string `json:"LoadBalancerId"`
+				} `json:"LoadBalancer"`
+			} `json:"LoadBalancers"`
+		}{}
+		err := t.client.call("DescribeLoadBalancers", map[string]any{
+			"RegionId":   t.region,
+			"PageNumber": page,
+			"PageSize":   slbPageSize,
+		}, &result)
+		if err != nil {
+			return false, err
+		}
+		for _, loadBalancer := range result.LoadBalancers.LoadBalancer {
+			inUse, err := t.serverCertInUseBy(loadBalancer.LoadBalancerId, serverCertId)
+			if err != nil || inUse {
+				return inUse, err
+			}
+		}
+		total += len(result.LoadBalancers.LoadBalancer)
+		if len(result.LoadBalancers.LoadBalancer) < slbPageSize || total >= result.TotalCount {
+			return false, nil
+		}
+	}
+}
+
+// 服务器证书是否被 CLB 实例的 HTTPS 监听或扩展域名使用
+func (t *slbTarget) serverCertInUseBy(loadBalancerId string, serverCertId string) (bool, error) {
+	result := struct {
+		ListenerPortsAndProtocol struct {
+			ListenerPortAndProtocol []struct {
+				ListenerPort     int    `json:"ListenerPort"`
+				ListenerProtocol string `json:"ListenerProtocol"`
+			} `json:"ListenerPortAndProtocol"`
+		} `json:"ListenerPortsAndProtocol"`
+	}{}
+	err := t.client.call("DescribeLoadBalancerAttribute", map[string]any{
+		"RegionId":       t.region,
+		"LoadBalancerId": loadBalancerId,
+	}, &result)
+	if err != nil {
+		return false, err
+	}
+	for _, listener := range result.ListenerPortsAndProtocol.ListenerPortAndProtocol {
+		if !strings.EqualFold(listener.ListenerProtocol, "https") {
+			continue
+		}
+		listenerCertId, err := t.listenerCert(loadBalancerId, listener.ListenerPort)
+		if err != nil {
+			return false, err
+		}
+		if listenerCertId == serverCertId {
+			return true, nil
+		}
+		extensions, err := t.domainExtensions(loadBalancerId, listener.ListenerPort)
+		if err != nil {
+			return false, err
+		}
+		for _, extension := range extensions {
+			if extension.ServerCertificateId == serverCertId {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// 查询服务器证书
+func (t *slbTarget) serverCert(serverCertId string) (*slbServerCert, error) {
+	result := struct {
+		ServerCertificates struct {
+			ServerCertificate []slbServerCert `json:"ServerCertificate"`
+		} `json:"ServerCertificates"`
+	}{}
+	err := t.client.call("DescribeServerCertificates", map[string]any{
+		"RegionId":            t.region,
+		"ServerCertificateId": serverCertId,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	for _, serverCert := range result.ServerCertificates.ServerCertificate {
+		if serverCert.ServerCertificateId == serverCertId {
+			return &serverCert, nil
+		}
+	}
+	return nil, fmt.Errorf("CLB 服务器证书 %s 不存在：%w", serverCertId, utils.ErrNotFound)
+}
+
+func (t *slbTarget) Describe(ctx context.Context) (*target.RemoteCert, error) {
+	binding, err := t.binding()
+	if err != nil {
+		return nil, err
+	}
+	if binding.serverCertId == "" {
+		return nil, nil
+	}
+	serverCert, err := t.serverCert(binding.serverCertId)
+	if err != nil {
+		return nil, err
+	}
+
+	domains := serverCert.SubjectAlternativeNames.SubjectAlternativeName
+	if len(domains) == 0 && serverCert.CommonName != "" {
+		domains = []string{serverCert.CommonName}
+	}
+	remote := &target.RemoteCert{
+		Id:       serverCert.ServerCertificateId,
+		Domains:  domains,
+		NotAfter: time.UnixMilli(serverCert.ExpireTimeStamp),
+	}
+	//从 CAS 导入的服务器证书可以获取证书内容用于指纹比较，失败时只按有效期判断
+	if serverCert.AliCloudCertificateId != "" {
+		detail := &target.RemoteCert{Id: serverCert.AliCloudCertificateId}
+		if err := fillRemoteCert(t.casClient, detail); err != nil {
+			utils.Println(t.listener(), "绑定的证书内容无法获取，按有效期判断是否更新：", err)
+		} else {
+			detail.Id = remote.Id
+			remote = detail
+		}
+	}
+	return remote, nil
+}
+
+func (t *slbTarget) NeedRenew(ctx context.Context, current *target.RemoteCert, local target.Cert) (bool, string, error) {
+	renew, reason := t.policy.Decide(current, local, time.Now())
+	return renew, reason, nil
+}
+
+func (t *slbTarget) Plan(ctx context.Context, current *target.RemoteCert, local target.Cert) ([]target.Step, error) {
+	previous, err := t.binding()
+	if err != nil {
+		return nil, err
+	}
+	steps := []target.Step{
+		planUpload(t.casClient, local),
+		{Action: target.StepCreate, Detail: "将新证书导入为 CLB 服务器证书"},
+	}
+	if t.additional && previous.domainExtensionId == "" {
+		steps = append(steps, target.Step{Action: target.StepBind, Detail: "创建" + t.listener() + "并绑定新证书"})
+	} else {
+		steps = append(steps, target.Step{Action: target.StepBind, Detail: t.listener() + "切换到新证书"})
+	}
+	if previous.serverCertId == "" {
+		return steps, nil
+	}
+	steps = append(steps, planDeleteOld("服务器证书", previous.serverCertId)...)
+	if serverCert, err := t.serverCert(previous.serverCertId); err == nil {
+		steps = append(steps, planDeleteOld("CAS 证书", serverCert.AliCloudCertificateId)...)
+	}
+	return steps, nil
+}
+
+func (t *slbTarget) Deploy(ctx context.Context, local target.Cert) error {
+	previous, err := t.binding()
+	if err != nil {
+		return err
+	}
+	previousCasCertId := ""
+	if previous.serverCertId != "" {
+		if serverCert, err := t.serverCert(previous.serverCertId); err == nil {
+			previousCasCertId = serverCert.AliCloudCertificateId
+		}
+	}
+
+	//1、上传到 CAS 并校验，再导入为 CLB 服务器证书，此时监听仍使用旧证书
+	domain := t.domain
+	if domain == "" {
+		domain = t.loadBalancerId
+	}
+	cert, err := uploadCheckedCert(t.casClient, domain, local)
+	if err != nil {
+		return err
+	}
+	result := struct {
+		ServerCertificateId string `json:"ServerCertificateId"`
+	}{}
+	err = t.client.call("UploadServerCertificate", map[string]any{
+		"RegionId":                    t.region,
+		"AliCloudCertificateId":       cert.id,
+		"AliCloudCertificateName":     fmt.Sprint(domain, "_", cert.id),
+		"AliCloudCertificateRegionId": certRegion(cert.identifier),
+	}, &result)
+	if err != nil {
+		discardCert(t.casClient, cert)
+		return err
+	}
+	serverCertId := result.ServerCertificateId
+
+	//2、将监听或扩展域名切换到新证书
+	if err := t.bind(previous, serverCertId); err != nil {
+		t.discardServerCert(serverCertId, cert)
+		return err
+	}
+
+	//3、确认切换已生效，失败时回滚到旧证书
+	bound, err := t.binding()
+	if err == nil && bound.serverCertId != serverCertId {
+		err = fmt.Errorf("%s 的证书未切换到 %s", t.listener(), serverCertId)
+	}
+	if err != nil {
+		if rollbackErr := t.rollback(previous, bound); rollbackErr != nil {
+			return fmt.Errorf("%w；回滚到旧证书 %s 失败：%v", err, previous.serverCertId, rollbackErr)
+		}
+		t.discardServerCert(serverCertId, cert)
+		return fmt.Errorf("%w，已回滚到旧证书 %s", err, previous.serverCertId)
+	}
+	t.deployedCertId = serverCertId
+
+	//4、新证书生效后再删除不再被任何监听使用的旧服务器证书和 CAS 证书
+	if previous.serverCertId != "" && !t.deletePreviousServerCert(previous.serverCertId) {
+		return nil
+	}
+	deletePreviousCert(t.casClient, previousCasCertId, cert)
+	return nil
+}
+
+// 删除旧的服务器证书，仍被其他监听或扩展域名使用、无法确认或删除失败时保留并返回 false
+func (t *slbTarget) deletePreviousServerCert(serverCertId string) bool {
+	inUse, err := t.serverCertInUse(serverCertId)
+	if err == nil && inUse {
+		utils.Printf("旧的服务器证书 %s 仍被其他监听或扩展域名使用，暂不删除\n", serverCertId)
+		return false
+	}
+	if err == nil {
+		err = t.deleteServerCert(serverCertId)
+	}
+	if err != nil {
+		utils.ErrorLog("删除旧的服务器证书 ", serverCertId, " 失败，可稍后手动清理：", err)
+		return false
+	}
+	return true
+}
+
+// 绑定服务器证书，扩展域名不存在时创建
+func (t *slbTarget) bind(previous slbBinding, serverCertId string) error {
+	if !t.additional {
+		return t.client.call("SetLoadBalancerHTTPSListenerAttribute", map[string]any{
+			"RegionId":            t.region,
+			"LoadBalancerId":      t.loadBalancerId,
+			"ListenerPort":        t.listenerPort,
+			"ServerCertificateId": serverCertId,
+		}, nil)
+	}
+	if previous.domainExtensionId != "" {
+		return t.client.call("SetDomainExtensionAttribute", map[string]any{
+			"RegionId":            t.region,
+			"DomainExtensionId":   previous.domainExtensionId,
+			"ServerCertificateId": serverCertId,
+		}, nil)
+	}
+	return t.client.call("CreateDomainExtension", map[string]any{
+		"RegionId":            t.region,
+		"LoadBalancerId":      t.loadBalancerId,
+		"ListenerPort":        t.listenerPort,
+		"Domain":              t.domain,
+		"ServerCertificateId": serverCertId,
+	}, nil)
+}
+
+// 恢复为旧证书，扩展域名是本次创建的则删除
+func (t *slbTarget) rollback(previous slbBinding, bound slbBinding) error {
+	if previous.serverCertId != "" {
+		return t.bind(previous, previous.serverCertId)
+	}
+	if t.additional && bound.domainExtensionId != "" {
+		return t.client.call("DeleteDomainExtension", map[string]any{
+			"RegionId":          t.region,
+			"DomainExtensionId": bound.domainExtensionId,
+		}, nil)
+	}
+	return nil
+}
+
+func (t *slbTarget) deleteServerCert(serverCertId string) error {
+	return t.client.call("DeleteServerCertificate", map[string]any{
+		"RegionId":            t.region,
+		"ServerCertificateId": serverCertId,
+	}, nil)
+}
+
+// 删除未能生效的服务器证书和 CAS 证书
+func (t *slbTarget) discardServerCert(serverCertId string, cert casCert) {
+	if err := t.deleteServerCert(serverCertId); err != nil {
+		utils.ErrorLog("删除未生效的服务器证书 ", serverCertId, " 失败：", err)
+	}
+	discardCert(t.casClient, cert)
+}
+
+// 日志中使用的监听名称
+func (t *slbTarget) listener() string {
+	name := fmt.Sprintf("CLB %s 的 %d 监听", t.loadBalancerId, t.listenerPort)
+	if t.additional {
+		name += "扩展域名 " + t.domain
+	}
+	return name
+}
+
+func (t *slbTarget) Verify(ctx context.Context, local target.Cert) error {
+	current, err := t.Describe(ctx)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Id != t.deployedCertId {
+		return fmt.Errorf("%s 绑定的证书与上传的证书不一致", t.listener())
+	}
+	if current.Fingerprint != "" && current.Fingerprint != utils.CertFingerprint(local.Bundle.Leaf) {
+		return fmt.Errorf("%s 绑定的证书指纹与本地证书不一致", t.listener())
+	}
+	utils.Printf("%s 的证书更新成功，有效期至：%s\n", t.listener(),
+		current.NotAfter.Local().Format("2006-01-02 15:04:05"))
+	return nil
+}
@@ -0,0 +1,259 @@
+package aliyun
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"whoyang.cn/update_cert/config"
+)
+
+// 模拟 CLB 实例、HTTPS 监听和服务器证书
+type clbFake struct {
+	serverCerts map[string]slbServerCert
+	// 实例 ID -> 监听端口 -> 默认服务器证书 ID
+	listeners  map[string]map[int]string
+	extensions map[string]map[int][]slbDomainExtension
+	nextId     int
+	// 为 true 时切换证书的请求返回成功但不生效，用于触发回滚
+	stuck   bool
+	deleted []string
+}
+
+func newClbFake(server *rpcServer) *clbFake {
+	fake := &clbFake{
+		serverCerts: make(map[string]slbServerCert),
+		listeners:   make(map[string]map[int]string),
+		extensions:  make(map[string]map[int][]slbDomainExtension),
+	}
+	listener := func(form url.Values) (string, int) {
+		port, _ := strconv.Atoi(form.Get("ListenerPort"))
+		return form.Get("LoadBalancerId"), port
+	}
+	server.handle("DescribeLoadBalancers", func(form url.Values) (any, string) {
+		var loadBalancers []map[string]string
+		for _, id := range fake.loadBalancerIds() {
+			loadBalancers = append(loadBalancers, map[string]string{"LoadBalancerId": id})
+		}
+		return map[string]any{"TotalCount": len(loadBalancers), "LoadBalancers": map[string]any{"LoadBalancer": loadBalancers}}, ""
+	})
+	server.handle("DescribeLoadBalancerAttribute", func(form url.Values) (any, string) {
+		var listeners []map[string]any
+		for port := range fake.listeners[form.Get("LoadBalancerId")] {
+			listeners = append(listeners, map[string]any{"ListenerPort": port, "ListenerProtocol": "https"})
+		}
+		listeners = append(listeners, map[string]any{"ListenerPort": 80, "ListenerProtocol": "http"})
+		return map[string]any{"ListenerPortsAndProtocol": map[string]any{"ListenerPortAndProtocol": listeners}}, ""
+	})
+	server.handle("DescribeLoadBalancerHTTPSListenerAttribute", func(form url.Values) (any, string) {
+		id, port := listener(form)
+		serverCertId, ok := fake.listeners[id][port]
+		if !ok {
+			return nil, "InvalidParameter.ListenerNotFound"
+		}
+		return map[string]string{"ServerCertificateId": serverCertId}, ""
+	})
+	server.handle("DescribeDomainExtensions", func(form url.Values) (any, string) {
+		id, port := listener(form)
+		return map[string]any{"DomainExtensions": map[string]any{"DomainExtension": fake.extensions[id][port]}}, ""
+	})
+	server.handle("DescribeServerCertificates", func(form url.Values) (any, string) {
+		var certs []slbServerCert
+		if serverCert, ok := fake.serverCerts[form.Get("ServerCertificateId")]; ok {
+			certs = append(certs, serverCert)
+		}
+		return map[string]any{"ServerCertificates": map[string]any{"ServerCertificate": certs}}, ""
+	})
+	server.handle("UploadServerCertificate", func(form url.Values) (any, string) {
+		serverCertId := fake.addServerCert(form.Get("AliCloudCertificateId"))
+		return map[string]string{"ServerCertificateId": serverCertId}, ""
+	})
+	server.handle("DeleteServerCertificate", func(form url.Values) (any, string) {
+		serverCertId := form.Get("ServerCertificateId")
+		if _, ok := fake.serverCerts[serverCertId]; !ok {
+			return nil, "ServerCertificateId.NotFound"
+		}
+		delete(fake.serverCerts, serverCertId)
+		fake.deleted = append(fake.deleted, serverCertId)
+		return nil, ""
+	})
+	server.handle("SetLoadBalancerHTTPSListenerAttribute", func(form url.Values) (any, string) {
+		id, port := listener(form)
+		if !fake.stuck {
+			fake.listeners[id][port] = form.Get("ServerCertificateId")
+		}
+		return nil, ""
+	})
+	server.handle("CreateDomainExtension", func(form url.Values) (any, string) {
+		id, port := listener(form)
+		fake.nextId++
+		extension := slbDomainExtension{DomainExtensionId: fmt.Sprint("de-", fake.nextId), Domain: form.Get("Domain"),
+			ServerCertificateId: form.Get("ServerCertificateId")}
+		fake.addExtension(id, port, extension)
+		return map[string]string{"DomainExtensionId": extension.DomainExtensionId}, ""
+	})
+	server.handle("SetDomainExtensionAttribute", func(form url.Values) (any, string) {
+		for _, ports := range fake.extensions {
+			for _, extensions := range ports {
+				for i := range extensions {
+					if extensions[i].DomainExtensionId == form.Get("DomainExtensionId") && !fake.stuck {
+						extensions[i].ServerCertificateId = form.Get("ServerCertificateId")
+					}
+				}
+			}
+		}
+		return nil, ""
+	})
+	return fake
+}
+
+func (fake *clbFake) loadBalancerIds() []string {
+	var ids []string
+	for id := range fake.listeners {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// 放入从 CAS 证书导入的服务器证书
+func (fake *clbFake) addServerCert(casCertId string) string {
+	fake.nextId++
+	serverCertId := fmt.Sprint("sc-", fake.nextId)
+	fake.serverCerts[serverCertId] = slbServerCert{ServerCertificateId: serverCertId, AliCloudCertificateId: casCertId}
+	return serverCertId
+}
+
+func (fake *clbFake) addListener(loadBalancerId string, port int, serverCertId string) {
+	if fake.listeners[loadBalancerId] == nil {
+		fake.listeners[loadBalancerId] = make(map[int]string)
+	}
+	fake.listeners[loadBalancerId][port] = serverCertId
+}
+
+func (fake *clbFake) addExtension(loadBalancerId string, port int, extension slbDomainExtension) {
+	if fake.extensions[loadBalancerId] == nil {
+		fake.extensions[loadBalancerId] = make(map[int][]slbDomainExtension)
+	}
+	fake.extensions[loadBalancerId][port] = append(fake.extensions[loadBalancerId][port], extension)
+}
+
+func newSlbTest(t *testing.T, certificate string) (*casStore, *clbFake, *slbTarget) {
+	t.Helper()
+	server := newRpcServer(t)
+	store := server.withCas()
+	fake := newClbFake(server)
+
+	aliyunConfig := server.aliyunConfig()
+	aliyunConfig.Region = "cn-shanghai"
+	aliyunConfig.LoadBalancerId = "lb-1"
+	aliyunConfig.ListenerPort = 443
+	aliyunConfig.Certificate = certificate
+	if certificate == certificateAdditional {
+		aliyunConfig.Domain = "api.example.com"
+	}
+	slb, err := NewSlbTarget(config.Target{Name: "clb", Aliyun: aliyunConfig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, fake, slb.(*slbTarget)
+}
+
+func TestSlbDeployDeletesUnusedServerCert(t *testing.T) {
+	tests := []struct {
+		name string
+		// 旧服务器证书的其他使用者
+		share func(fake *clbFake, serverCertId string)
+		want  bool
+	}{
+		{name: "没有其他使用者", share: func(*clbFake, string) {}, want: true},
+		{name: "同一实例的其他监听", share: func(fake *clbFake, serverCertId string) {
+			fake.addListener("lb-1", 8443, serverCertId)
+		}},
+		{name: "其他实例的扩展域名", share: func(fake *clbFake, serverCertId string) {
+			fake.addListener("lb-2", 443, "sc-other")
+			fake.addExtension("lb-2", 443, slbDomainExtension{DomainExtensionId: "de-x", Domain: "x.example.com", ServerCertificateId: serverCertId})
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, fake, slb := newSlbTest(t, certificateDefault)
+			oldCasId := store.add(newLocalCert(t, "www.example.com").Bundle.CrtPEM)
+			oldServerCertId := fake.addServerCert(strconv.FormatInt(oldCasId, 10))
+			fake.addListener("lb-1", 443, oldServerCertId)
+			test.share(fake, oldServerCertId)
+
+			local := newLocalCert(t, "www.example.com")
+			ctx := context.Background()
+			if err := slb.Deploy(ctx, local); err != nil {
+				t.Fatal(err)
+			}
+			if err := slb.Verify(ctx, local); err != nil {
+				t.Fatal(err)
+			}
+			if fake.listeners["lb-1"][443] == oldServerCertId {
+				t.Fatal("监听没有切换到新证书")
+			}
+			deleted := slices.Contains(fake.deleted, oldServerCertId)
+			casDeleted := slices.Contains(store.deleted, oldCasId)
+			if deleted != test.want || casDeleted != test.want {
+				t.Fatalf("删除旧服务器证书 %v、CAS 证书 %v，期望 %v", deleted, casDeleted, test.want)
+			}
+		})
+	}
+}
+
+func TestSlbAdditionalCert(t *testing.T) {
+	store, fake, slb := newSlbTest(t, certificateAdditional)
+	fake.addListener("lb-1", 443, "sc-default")
+	local := newLocalCert(t, "api.example.com")
+	ctx := context.Background()
+
+	steps, err := slb.Plan(ctx, nil, local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(steps[len(steps)-1].Detail, "创建") {
+		t.Fatalf("扩展域名不存在时应创建，计划为 %+v", steps)
+	}
+	if err := slb.Deploy(ctx, local); err != nil {
+		t.Fatal(err)
+	}
+	if err := slb.Verify(ctx, local); err != nil {
+		t.Fatal(err)
+	}
+	extensions := fake.extensions["lb-1"][443]
+	if len(extensions) != 1 || extensions[0].Domain != "api.example.com" {
+		t.Fatalf("扩展域名 = %+v", extensions)
+	}
+	if fake.listeners["lb-1"][443] != "sc-default" || len(store.deleted) != 0 {
+		t.Fatalf("替换扩展证书不应改动默认证书，删除的 CAS 证书 = %v", store.deleted)
+	}
+}
+
+func TestSlbRollback(t *testing.T) {
+	store, fake, slb := newSlbTest(t, certificateDefault)
+	oldCasId := store.add(newLocalCert(t, "www.example.com").Bundle.CrtPEM)
+	oldServerCertId := fake.addServerCert(strconv.FormatInt(oldCasId, 10))
+	fake.addListener("lb-1", 443, oldServerCertId)
+	fake.stuck = true
+
+	err := slb.Deploy(context.Background(), newLocalCert(t, "www.example.com"))
+	if err == nil || !strings.Contains(err.Error(), "已回滚到旧证书 "+oldServerCertId) {
+		t.Fatalf("err = %v", err)
+	}
+	if fake.listeners["lb-1"][443] != oldServerCertId {
+		t.Fatal("回滚后监听应使用旧证书")
+	}
+	if len(fake.deleted) != 1 || fake.deleted[0] == oldServerCertId {
+		t.Fatalf("删除的服务器证书 = %v，期望只删除新证书", fake.deleted)
+	}
+	if len(store.deleted) != 1 || store.deleted[0] == oldCasId {
+		t.Fatalf("删除的 CAS 证书 = %v，期望只删除新证书", store.deleted)
+	}
+}
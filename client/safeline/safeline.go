@@ -1,367 +1,271 @@
 package safeline
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
 	"time"
+	"whoyang.cn/update_cert/metrics"
+	"whoyang.cn/update_cert/utils"
 )
-import netUrl "net/url"
 
-// 是否启用 debug
-var debugSwitch = false
+// 错误信息中使用的服务名称
+const serviceName = "SafeLine"
 
-// 全局服务 URL
-var baseServerUrl string
-
-// API TOKEN
-var apiToken string
+// 证书类型
+const (
+	CertTypeAcme   = 1
+	CertTypeManual = 2
+)
 
-type ServerConfig struct {
-	Url      string
-	ApiToken string
-	CertId   string
+// 长亭雷池 OpenAPI 客户端
+type Client struct {
+	// 服务 URL，例如 https://127.0.0.1:9443
+	baseServerUrl string
+	// API TOKEN
+	apiToken   string
+	httpClient *http.Client
+	// 部署目标名称，用于按目标统计接口调用
+	target string
+	// 是否启用 debug
+	debugSwitch bool
 }
 
-// 获取 client 客户端
-func getClient() *http.Client {
-	//跳过不安全的验证
-	transport := http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-
-	client := http.Client{Transport: &transport}
-	return &client
-}
+// 客户端选项
+type Option func(*Client)
 
-// 获取请求体
-func getRequest(path string, method string, body any) (request http.Request, url *netUrl.URL) {
-	url, _ = netUrl.Parse(baseServerUrl + path)
-	request = http.Request{
-		// POST请求方法
-		Method: method,
-		URL:    url,
-		// http协议版本
-		Proto:      "HTTP/1.1",
-		ProtoMajor: 1,
-		ProtoMinor: 1,
-		// 请求头
-		Header: map[string][]string{
-			"Content-Type": {"application/json"}, // 使用json格式载荷体
-		},
+// 使用自定义的 HTTP 客户端
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
 	}
+}
 
-	//拼接api token
-	if apiToken != "" {
-		request.Header.Set("X-SLCE-API-TOKEN", apiToken)
+// 设置部署目标名称，接口调用按该名称统计
+func WithTarget(target string) Option {
+	return func(c *Client) {
+		c.target = target
 	}
+}
 
-	//拼接请求体
-	if body != nil {
-		request.Body = io.NopCloser(strings.NewReader(body.(string)))
+// 打印请求和返回体
+func WithDebug(debug bool) Option {
+	return func(c *Client) {
+		c.debugSwitch = debug
 	}
-
-	return request, url
 }
 
-// debug 日志
-func debugLog(v ...any) {
-	if debugSwitch {
-		log.Println(v)
+// 创建长亭雷池 OpenAPI 客户端
+func NewClient(baseServerUrl string, apiToken string, options ...Option) *Client {
+	c := &Client{
+		baseServerUrl: strings.TrimSuffix(baseServerUrl, "/"),
+		apiToken:      apiToken,
+		httpClient:    getClient(),
 	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
 }
 
-// 异常日志
-func errorLog(v ...any) {
-	log.Fatal(v)
+// 获取 client 客户端，默认使用系统根证书校验服务端证书
+func getClient() *http.Client {
+	client, _ := utils.NewHTTPClient(utils.TLSOptions{})
+	return client
 }
 
-// get 请求方法
-func get(path string) (body string, statusCode int) {
-	client := getClient()
-	request, url := getRequest(path, "GET", nil)
+// 接口返回体，err 和 msg 不为空时表示调用失败
+type Response[T any] struct {
+	Data T      `json:"data"`
+	Err  string `json:"err"`
+	Msg  string `json:"msg"`
+}
 
-	debugLog("url: ", url, " method: get")
-	debugLog("url: ", url, " head: ", request.Header)
-	resp, err := client.Do(&request)
-	if err != nil {
-		debugLog(err)
-		return "", 500
-	}
-	bodyByte, err := io.ReadAll(resp.Body)
-	defer resp.Body.Close()
+// 证书列表中的证书
+type CertNode struct {
+	Id            int      `json:"id"`
+	Domains       []string `json:"domains"`
+	Issuer        string   `json:"issuer"`
+	Type          int      `json:"type"`
+	SelfSignature bool     `json:"self_signature"`
+	Trusted       bool     `json:"trusted"`
+	Revoked       bool     `json:"revoked"`
+	Expired       bool     `json:"expired"`
+	// RFC3339 格式的过期时间
+	ValidBefore string `json:"valid_before"`
+}
 
-	body = string(bodyByte)
-	debugLog("url: ", url, " responseBody: ", body)
+// 过期时间，格式不正确时返回零值
+func (n CertNode) ValidBeforeTime() time.Time {
+	validBefore, _ := time.Parse(time.RFC3339, n.ValidBefore)
+	return validBefore
+}
 
-	if resp.StatusCode != http.StatusOK {
-		debugLog("Get请求失败，返回码为：", resp.StatusCode, "，异常返回体为：", body)
-		return "", resp.StatusCode
-	}
+// 证书列表
+type CertList struct {
+	Nodes []CertNode `json:"nodes"`
+	Total int        `json:"total"`
+}
 
-	if err != nil {
-		debugLog(err)
-		return "", 500
-	}
+// ACME 申请的证书信息
+type AcmeCert struct {
+	Domains []string `json:"domains"`
+	Email   string   `json:"email"`
+}
 
-	return body, resp.StatusCode
+// 手动上传的证书内容
+type ManualCert struct {
+	Crt string `json:"crt"`
+	Key string `json:"key"`
 }
 
-// post 请求方法
-func post(path string, body any) string {
-	client := getClient()
+// 证书详情
+type CertDetail struct {
+	Id     int        `json:"id"`
+	Type   int        `json:"type"`
+	Acme   AcmeCert   `json:"acme"`
+	Manual ManualCert `json:"manual"`
+}
 
-	request, url := getRequest(path, "POST", body)
+// 创建和更新证书的请求体，Id 为空时创建
+type certRequest struct {
+	Id     int        `json:"id,omitempty"`
+	Type   int        `json:"type"`
+	Manual ManualCert `json:"manual"`
+}
 
-	debugLog("url: ", url, " method: post", " request: ", body)
-	debugLog("url: ", url, " head: ", request.Header)
-	resp, err := client.Do(&request)
-	if err != nil {
-		debugLog(err)
-		return ""
-	}
-	responseBody, err := io.ReadAll(resp.Body)
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		debugLog("POST请求失败，返回码为：", resp.StatusCode, "，异常返回体为：", string(responseBody))
-		return ""
-	}
-	if err != nil {
-		debugLog(err)
+// debug 日志
+func (c *Client) debugLog(v ...any) {
+	if c.debugSwitch {
+		log.Println(v...)
 	}
-	debugLog("url: ", url, " responseBody: ", string(responseBody))
-	return string(responseBody)
 }
 
-// 解析请求的返回体，过滤 err 信息，只返回 data 信息
-func getResponseData(responseJson string) (data map[string]interface{}, dateErr any) {
-	var responseInfo interface{}
-
-	jsonErr := json.Unmarshal([]byte(responseJson), &responseInfo)
-	if jsonErr != nil {
-		debugLog(jsonErr)
-		return nil, jsonErr
-	}
+// 发送请求并解析返回体中的 data
+func (c *Client) do(ctx context.Context, method string, path string, body any, data any) (err error) {
+	operation := method + " " + path
+	defer func() {
+		metrics.ObserveAPICall(c.target, serviceName, err)
+	}()
 
-	responseMap := responseInfo.(map[string]interface{})
-	repDataMsg := responseMap["msg"]
-	if repDataMsg != nil {
-		if repDataMsg != "" {
-			debugLog(repDataMsg)
-			return nil, repDataMsg.(string)
+	var requestBody io.Reader
+	if body != nil {
+		requestJson, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("%s 请求体序列化异常：%w", operation, err)
 		}
+		requestBody = bytes.NewReader(requestJson)
 	}
 
-	repDataErr := responseMap["err"]
-	if repDataErr != nil {
-		debugLog(repDataErr)
-		return nil, repDataErr.(string)
+	request, err := http.NewRequestWithContext(ctx, method, c.baseServerUrl+path, requestBody)
+	if err != nil {
+		return fmt.Errorf("%s 创建请求异常：%w", operation, err)
 	}
-
-	repData := responseMap["data"]
-	if repData == nil {
-		return nil, nil
+	// 使用json格式载荷体
+	request.Header.Set("Content-Type", "application/json")
+	//拼接api token
+	if c.apiToken != "" {
+		request.Header.Set("X-SLCE-API-TOKEN", c.apiToken)
 	}
-	dataMap := repData.(map[string]interface{})
-	return dataMap, nil
-}
 
-// 读取文件
-func readFile(filePath string) any {
-	file, err := os.ReadFile(filePath)
+	c.debugLog("url: ", request.URL, " method: ", method)
+	resp, err := c.httpClient.Do(request)
 	if err != nil {
-		errorLog("读取文件异常：", err)
-		return nil
+		var verificationError *tls.CertificateVerificationError
+		if errors.As(err, &verificationError) {
+			return fmt.Errorf("%s 请求异常，雷池管理证书校验失败，可配置 tls.ca_file、tls.pin_sha256 或显式开启 tls.insecure：%w", operation, err)
+		}
+		return fmt.Errorf("%s 请求异常：%w", operation, err)
 	}
-	return string(file)
-}
-
-// 证书对象
-type CertInfo struct {
-	Id          int    `json:"id"`
-	Domain      string `json:"domain"`
-	Issuer      string `json:"issuer"`
-	ValidBefore string `json:"valid_before"`
-}
+	defer resp.Body.Close()
 
-// 3、获取证书列表，并返回 map 对象
-func certList() (certInfoMap map[int]CertInfo) {
-	certInfoMap = make(map[int]CertInfo)
-	responseJson, statusCode := get("/api/open/cert")
-	if statusCode != 200 {
-		errorLog("获取证书列表接口调用异常")
-		return nil
-	}
-	data, err := getResponseData(responseJson)
+	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		errorLog("获取证书详情接口调用异常：", err)
+		return fmt.Errorf("%s 读取返回体异常：%w", operation, err)
 	}
-	nodes := data["nodes"].([]interface{})
-	for i := range nodes {
-		node := nodes[i].(map[string]interface{})
-		id := int(node["id"].(float64))
-		var domain string
-		domains := node["domains"].([]interface{})
-		for j := range domains {
-			domain += domains[j].(string)
-		}
-		issuer := node["issuer"].(string)
+	c.debugLog("url: ", request.URL, " status: ", resp.StatusCode, " responseBody: ", string(responseBody))
 
-		validBeforeTime, _ := time.Parse(time.RFC3339, node["valid_before"].(string))
-		validBefore := validBeforeTime.Format("2006-01-02 15:04:05")
-		certInfo := CertInfo{
-			id, domain, issuer, validBefore,
+	response := Response[json.RawMessage]{}
+	jsonErr := json.Unmarshal(responseBody, &response)
+	if resp.StatusCode != http.StatusOK {
+		apiError := utils.NewAPIError(serviceName, operation, resp.StatusCode, response.Err, response.Msg)
+		if jsonErr != nil {
+			apiError.Message = string(responseBody)
 		}
-		certInfoMap[certInfo.Id] = certInfo
+		return apiError
 	}
-	return certInfoMap
-}
-
-// 4、更新证书
-func certUpdate(certId int, baseCertCrtPath string, baseCertKeyPath string) bool {
-	domain, _, validBefore, crt := getCertInfo(certId)
-	if domain == "" && crt == "" {
-		return false
+	if jsonErr != nil {
+		return fmt.Errorf("%s 返回体解析异常：%w", operation, jsonErr)
 	}
-
-	validBeforeTime, _ := time.Parse("2006-01-02 15:04:05", validBefore)
-	timeSub := validBeforeTime.Sub(time.Now())
-	if timeSub.Hours() > 72 {
-		errorLog("证书还在有效期，暂不更新")
+	if response.Err != "" || response.Msg != "" {
+		return utils.NewAPIError(serviceName, operation, resp.StatusCode, response.Err, response.Msg)
 	}
 
-	type manual struct {
-		Crt string `json:"crt"`
-		Key string `json:"key"`
+	if data == nil || len(response.Data) == 0 || string(response.Data) == "null" {
+		return nil
 	}
-
-	certCrt := readFile(baseCertCrtPath)
-	if certCrt == nil {
-		errorLog("读取证书信息异常")
-		return false
+	if err := json.Unmarshal(response.Data, data); err != nil {
+		return fmt.Errorf("%s 返回数据解析异常：%w", operation, err)
 	}
+	return nil
+}
 
-	certKey := readFile(baseCertKeyPath)
-	if certKey == nil {
-		errorLog("读取证书私钥异常")
-		return false
+// 获取证书列表
+func (c *Client) ListCerts(ctx context.Context) ([]CertNode, error) {
+	certList := CertList{}
+	if err := c.do(ctx, http.MethodGet, "/api/open/cert", nil, &certList); err != nil {
+		return nil, err
 	}
+	return certList.Nodes, nil
+}
 
-	manualInfo := manual{
-		certCrt.(string),
-		certKey.(string),
+// 获取证书详情
+func (c *Client) GetCert(ctx context.Context, certId int) (*CertDetail, error) {
+	certDetail := &CertDetail{}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprint("/api/open/cert/", certId), nil, certDetail); err != nil {
+		return nil, err
 	}
+	return certDetail, nil
+}
 
-	certInfo := struct {
-		Id     int    `json:"id"`
-		Type   int    `json:"type"`
-		Manual manual `json:"manual"`
-	}{
-		certId,
-		2,
-		manualInfo,
+// 在证书列表中查找证书，不存在时返回 ErrNotFound
+func (c *Client) FindCert(ctx context.Context, certId int) (*CertNode, error) {
+	nodes, err := c.ListCerts(ctx)
+	if err != nil {
+		return nil, err
 	}
-
-	certUpdateRequestJson, jsonErr := json.Marshal(&certInfo)
-	if jsonErr != nil {
-		errorLog("更新证书接口JSON解析异常：", jsonErr)
-		return false
+	for i := range nodes {
+		if nodes[i].Id == certId {
+			return &nodes[i], nil
+		}
 	}
-
-	responseJson := post("/api/open/cert", string(certUpdateRequestJson))
-	getResponseData(responseJson)
-	return true
+	return nil, fmt.Errorf("长亭雷池WAF证书 %d：%w", certId, utils.ErrNotFound)
 }
 
-// 5、获取证书详情
-func getCert(certId int) (domain string, crt string) {
-
-	getCertJson, statusCode := get(fmt.Sprint("/api/open/cert/", certId))
-	if statusCode != 200 {
-		errorLog("获取证书详情接口调用异常，可能是证书ID不存在")
-		return "", ""
-	}
-	data, dateErr := getResponseData(getCertJson)
-	if dateErr != nil {
-		errorLog("获取证书详情接口调用异常：", dateErr)
-	}
-	acme := data["acme"].(map[string]interface{})
-	domains := acme["domains"].([]interface{})
-	for i := range domains {
-		domain += domains[i].(string)
+// 上传新的手动证书，返回证书 ID
+func (c *Client) CreateCert(ctx context.Context, manual ManualCert) (int, error) {
+	var certId int
+	request := certRequest{Type: CertTypeManual, Manual: manual}
+	if err := c.do(ctx, http.MethodPost, "/api/open/cert", request, &certId); err != nil {
+		return 0, err
 	}
-	manual := data["manual"].(map[string]interface{})
-	crt = manual["crt"].(string)
-	return domain, crt
+	return certId, nil
 }
 
-// 获取证书详情，通过列表和详情接口拼接而成
-func getCertInfo(certId int) (domain string, issuer string, validBefore string, crt string) {
-	domain, crt = getCert(certId)
-	certInfoMap := certList()
-	certInfo := certInfoMap[certId]
-	issuer = certInfo.Issuer
-	validBefore = certInfo.ValidBefore
-	return domain, issuer, validBefore, crt
+// 使用手动证书替换已有证书
+func (c *Client) UpdateCert(ctx context.Context, certId int, manual ManualCert) error {
+	request := certRequest{Id: certId, Type: CertTypeManual, Manual: manual}
+	return c.do(ctx, http.MethodPost, "/api/open/cert", request, nil)
 }
 
-func Run(serverConfig ServerConfig, debug bool, version string) {
-	fmt.Println("====================================")
-	fmt.Println("长亭雷池WAF站点证书同步工具 ", version)
-	fmt.Println("====================================")
-	fmt.Println("")
-
-	//加载环境变量的参数
-	debugSwitch = debug
-
-	baseServerUrl = serverConfig.Url
-	apiToken = serverConfig.ApiToken
-	certIdSrt := serverConfig.CertId
-
-	if baseServerUrl == "" {
-		fmt.Println("长亭雷池WAF 服务URL 地址为填充，默认填充：https://127.0.0.1:9443")
-		baseServerUrl = "https://127.0.0.1:9443"
-	}
-
-	if apiToken == "" {
-		fmt.Println("长亭雷池WAF API TOKEN不能为空")
-		return
-	}
-
-	if certIdSrt == "" {
-		certIdSrt = "1"
-	}
-
-	certId, _ := strconv.Atoi(certIdSrt)
-	certCrtPath := os.Getenv("CERT_CRT_PATH")
-	if certCrtPath == "" {
-		fmt.Println("长亭雷池WAF站点新证书地址不能为空")
-		return
-	}
-	certKeyPath := os.Getenv("CERT_KEY_PATH")
-	if certKeyPath == "" {
-		fmt.Println("长亭雷池WAF站点新证书私钥地址不能为空")
-		return
-	}
-
-	updateResult := certUpdate(certId, certCrtPath, certKeyPath)
-	if updateResult == true {
-		domain, issuer, validBefore, crt := getCertInfo(certId)
-		//展示最终结果
-		fmt.Println("长亭雷池WAF站点证书同步成功，同步内容如下：\n",
-			"域名：", domain, "\r\n",
-			"颁发机构：", issuer, "\r\n",
-			"有效期至：", validBefore, "\r\n",
-			"证书信息：", crt)
-	} else {
-		fmt.Print("长亭雷池WAF站点证书同步失败，请核查日志")
-	}
+// 删除证书
+func (c *Client) DeleteCert(ctx context.Context, certId int) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprint("/api/open/cert/", certId), nil, nil)
 }
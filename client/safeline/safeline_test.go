@@ -0,0 +1,252 @@
+package safeline
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"whoyang.cn/update_cert/utils"
+)
+
+const testToken = "test-token"
+
+// 模拟雷池 OpenAPI 的证书接口，按上传的证书内容生成证书列表
+type fakeServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	nextId int
+	certs  map[int]ManualCert
+	// 按顺序记录的请求，例如 POST /api/open/cert
+	requests []string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	server := &fakeServer{nextId: 1, certs: make(map[int]ManualCert)}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (s *fakeServer) reply(w http.ResponseWriter, status int, response any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func (s *fakeServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	if r.Header.Get("X-SLCE-API-TOKEN") != testToken {
+		s.reply(w, http.StatusUnauthorized, Response[any]{Err: "login-required", Msg: "Login required"})
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/open/cert")
+	switch {
+	case r.Method == http.MethodGet && path == "":
+		s.reply(w, http.StatusOK, Response[CertList]{Data: s.list()})
+	case r.Method == http.MethodGet:
+		id, _ := strconv.Atoi(strings.TrimPrefix(path, "/"))
+		manual, ok := s.certs[id]
+		if !ok {
+			s.reply(w, http.StatusNotFound, Response[any]{Err: "not-found", Msg: "cert not found"})
+			return
+		}
+		s.reply(w, http.StatusOK, Response[CertDetail]{Data: CertDetail{Id: id, Type: CertTypeManual, Manual: manual}})
+	case r.Method == http.MethodPost && path == "":
+		data, _ := io.ReadAll(r.Body)
+		var request certRequest
+		if err := json.Unmarshal(data, &request); err != nil || request.Type != CertTypeManual {
+			s.reply(w, http.StatusOK, Response[any]{Err: "invalid-param", Msg: "bad request"})
+			return
+		}
+		if request.Id == 0 {
+			request.Id = s.nextId
+			s.nextId++
+		} else if _, ok := s.certs[request.Id]; !ok {
+			s.reply(w, http.StatusOK, Response[any]{Err: "not-found", Msg: "cert not found"})
+			return
+		}
+		s.certs[request.Id] = request.Manual
+		s.reply(w, http.StatusOK, Response[int]{Data: request.Id})
+	case r.Method == http.MethodDelete:
+		id, _ := strconv.Atoi(strings.TrimPrefix(path, "/"))
+		delete(s.certs, id)
+		s.reply(w, http.StatusOK, Response[any]{})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// 由上传的证书内容生成证书列表
+func (s *fakeServer) list() CertList {
+	list := CertList{Nodes: []CertNode{}}
+	for id, manual := range s.certs {
+		node := CertNode{Id: id, Type: CertTypeManual}
+		if leaf, err := utils.ParseLeafPEM(manual.Crt); err == nil {
+			node.Domains, node.Issuer = leaf.DNSNames, leaf.Issuer.CommonName
+			node.ValidBefore = leaf.NotAfter.Format(time.RFC3339)
+		}
+		list.Nodes = append(list.Nodes, node)
+	}
+	list.Total = len(list.Nodes)
+	return list
+}
+
+func (s *fakeServer) add(manual ManualCert) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.nextId
+	s.nextId++
+	s.certs[id] = manual
+	return id
+}
+
+// 生成覆盖 domains 的自签名证书和私钥
+func newTestCert(t *testing.T, domains ...string) ManualCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: domains[0]},
+		Issuer:       pkix.Name{CommonName: domains[0]},
+		DNSNames:     domains,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(0, 3, 0).Truncate(time.Second),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, _ := x509.MarshalPKCS8PrivateKey(key)
+	return ManualCert{
+		Crt: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		Key: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})),
+	}
+}
+
+func TestClientCerts(t *testing.T) {
+	server := newFakeServer(t)
+	client := NewClient(server.URL+"/", testToken)
+	ctx := context.Background()
+
+	manual := newTestCert(t, "example.com")
+	certId, err := client.CreateCert(ctx, manual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := client.ListCerts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].Id != certId || nodes[0].Domains[0] != "example.com" {
+		t.Fatalf("证书列表 = %+v", nodes)
+	}
+	if nodes[0].ValidBeforeTime().IsZero() {
+		t.Errorf("valid_before = %q 无法解析", nodes[0].ValidBefore)
+	}
+
+	updated := newTestCert(t, "example.com", "www.example.com")
+	if err := client.UpdateCert(ctx, certId, updated); err != nil {
+		t.Fatal(err)
+	}
+	detail, err := client.GetCert(ctx, certId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.Id != certId || detail.Manual.Crt != updated.Crt {
+		t.Fatalf("证书详情 = %+v，期望为更新后的证书", detail)
+	}
+	node, err := client.FindCert(ctx, certId)
+	if err != nil || len(node.Domains) != 2 {
+		t.Fatalf("FindCert = %+v，%v", node, err)
+	}
+
+	if err := client.DeleteCert(ctx, certId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.FindCert(ctx, certId); !errors.Is(err, utils.ErrNotFound) {
+		t.Fatalf("删除后 FindCert 错误 = %v，期望 ErrNotFound", err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	server := newFakeServer(t)
+	ctx := context.Background()
+
+	// 状态码不为 200 时返回体中的 err、msg 作为错误码和错误信息
+	_, err := NewClient(server.URL, "wrong").ListCerts(ctx)
+	var apiError *utils.APIError
+	if !errors.As(err, &apiError) || !errors.Is(err, utils.ErrAuth) {
+		t.Fatalf("错误 = %v，期望认证失败的 APIError", err)
+	}
+	if apiError.StatusCode != http.StatusUnauthorized || apiError.Code != "login-required" || apiError.Message != "Login required" {
+		t.Errorf("APIError = %+v", apiError)
+	}
+
+	client := NewClient(server.URL, testToken)
+	if _, err := client.GetCert(ctx, 42); !errors.Is(err, utils.ErrNotFound) {
+		t.Errorf("证书不存在时错误 = %v，期望 ErrNotFound", err)
+	}
+
+	// 状态码为 200 但 err 不为空时同样视为失败
+	err = client.UpdateCert(ctx, 42, newTestCert(t, "example.com"))
+	if !errors.As(err, &apiError) || apiError.Code != "not-found" || apiError.StatusCode != http.StatusOK {
+		t.Errorf("错误 = %v，期望返回体中的错误码", err)
+	}
+}
+
+func TestResponseDecoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{"data 为 null", http.StatusOK, `{"data":null,"err":null,"msg":null}`, ""},
+		{"没有 data", http.StatusOK, `{}`, ""},
+		{"返回体不是 JSON", http.StatusOK, `<html>`, "返回体解析异常"},
+		{"data 类型不符", http.StatusOK, `{"data":"abc"}`, "返回数据解析异常"},
+		{"错误状态码且不是 JSON", http.StatusBadGateway, `bad gateway`, "错误信息：bad gateway"},
+		{"只有 msg", http.StatusOK, `{"msg":"参数错误"}`, "错误信息：参数错误"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.status)
+				_, _ = io.WriteString(w, test.body)
+			}))
+			defer server.Close()
+			_, err := NewClient(server.URL, testToken).CreateCert(context.Background(), ManualCert{})
+			if test.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("错误 = %v，期望包含 %q", err, test.want)
+			}
+		})
+	}
+}
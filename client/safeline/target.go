@@ -0,0 +1,212 @@
+package safeline
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/target"
+	"whoyang.cn/update_cert/utils"
+)
+
+func init() {
+	target.Register(config.TypeSafeline, NewTarget)
+}
+
+// 长亭雷池WAF部署目标
+type safelineTarget struct {
+	name   string
+	client *Client
+	// 为 0 时按本地证书域名匹配，匹配不到则新建
+	certId int
+	policy target.Policy
+}
+
+// 根据配置创建长亭雷池WAF部署目标
+func NewTarget(targetConfig config.Target) (target.Target, error) {
+	serverConfig := targetConfig.Safeline
+
+	baseServerUrl := serverConfig.Url
+	if baseServerUrl == "" {
+		utils.Println("长亭雷池WAF 服务URL 地址为填充，默认填充：https://127.0.0.1:9443")
+		baseServerUrl = "https://127.0.0.1:9443"
+	}
+
+	if serverConfig.ApiToken == "" {
+		return nil, fmt.Errorf("长亭雷池WAF API TOKEN不能为空")
+	}
+
+	certId := 0
+	if serverConfig.CertId != "" {
+		var err error
+		certId, err = strconv.Atoi(serverConfig.CertId)
+		if err != nil || certId <= 0 {
+			return nil, fmt.Errorf("长亭雷池WAF证书ID %s 不合法", serverConfig.CertId)
+		}
+	}
+
+	policy, err := target.NewPolicy(targetConfig.Renew)
+	if err != nil {
+		return nil, err
+	}
+
+	httpClient, err := utils.NewHTTPClient(utils.TLSOptions{
+		CAFile:    serverConfig.TLS.CaFile,
+		PinSHA256: serverConfig.TLS.PinSHA256,
+		Insecure:  config.BoolValue(serverConfig.TLS.Insecure),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("长亭雷池WAF TLS 配置异常：%w", err)
+	}
+
+	return &safelineTarget{
+		name:   targetConfig.Name,
+		policy: policy,
+		client: NewClient(baseServerUrl, serverConfig.ApiToken, WithHTTPClient(httpClient), WithTarget(targetConfig.Name)),
+		certId: certId,
+	}, nil
+}
+
+func (t *safelineTarget) Name() string {
+	return t.name
+}
+
+// 雷池证书覆盖的域名只能从远端证书获得
+func (t *safelineTarget) Domains() []string {
+	return nil
+}
+
+// 未配置证书 ID 时，按本地证书的域名在雷池证书列表中查找
+func (t *safelineTarget) Resolve(ctx context.Context, local target.Cert) error {
+	if t.certId != 0 {
+		return nil
+	}
+	nodes, err := t.client.ListCerts(ctx)
+	if err != nil {
+		return err
+	}
+	node := matchCert(nodes, local.Bundle.Leaf)
+	if node == nil {
+		utils.Println("长亭雷池WAF中没有与本地证书域名匹配的证书，将新建证书：", strings.Join(local.Bundle.Leaf.DNSNames, ","))
+		return nil
+	}
+	t.certId = node.Id
+	utils.Println("长亭雷池WAF中匹配到证书 ID：", t.certId, "，域名：", strings.Join(node.Domains, ","))
+	return nil
+}
+
+// 找出域名全部被本地证书覆盖的雷池证书，优先选择域名集合完全一致、其次域名最多的证书
+func matchCert(nodes []CertNode, leaf *x509.Certificate) *CertNode {
+	var matched *CertNode
+	for i := range nodes {
+		node := &nodes[i]
+		if len(node.Domains) == 0 {
+			continue
+		}
+		covered := true
+		for _, domain := range node.Domains {
+			if !utils.CertCoversDomain(leaf, domain) {
+				covered = false
+				break
+			}
+		}
+		if !covered {
+			continue
+		}
+		if len(node.Domains) == len(leaf.DNSNames) {
+			return node
+		}
+		if matched == nil || len(node.Domains) > len(matched.Domains) {
+			matched = node
+		}
+	}
+	return matched
+}
+
+func (t *safelineTarget) Describe(ctx context.Context) (*target.RemoteCert, error) {
+	if t.certId == 0 {
+		return nil, nil
+	}
+
+	//证书详情中只有证书内容，颁发机构和过期时间从列表中获取
+	certDetail, err := t.client.GetCert(ctx, t.certId)
+	if err != nil {
+		return nil, fmt.Errorf("获取证书详情接口调用异常，可能是证书ID不存在：%w", err)
+	}
+	certNode, err := t.client.FindCert(ctx, t.certId)
+	if err != nil {
+		return nil, err
+	}
+
+	remote := &target.RemoteCert{
+		Id:       strconv.Itoa(t.certId),
+		Domains:  certNode.Domains,
+		Issuer:   certNode.Issuer,
+		NotAfter: certNode.ValidBeforeTime(),
+	}
+	if len(remote.Domains) == 0 {
+		remote.Domains = certDetail.Acme.Domains
+	}
+	//解析证书内容获取指纹，失败时只按有效期判断
+	if crt := certDetail.Manual.Crt; crt != "" {
+		if err := remote.FillFromPEM(crt); err != nil {
+			utils.Println("长亭雷池WAF站点证书内容无法解析，按有效期判断是否更新：", err)
+		}
+	}
+	return remote, nil
+}
+
+func (t *safelineTarget) NeedRenew(ctx context.Context, current *target.RemoteCert, local target.Cert) (bool, string, error) {
+	renew, reason := t.policy.Decide(current, local, time.Now())
+	return renew, reason, nil
+}
+
+func (t *safelineTarget) Plan(ctx context.Context, current *target.RemoteCert, local target.Cert) ([]target.Step, error) {
+	if t.certId == 0 {
+		return []target.Step{{Action: target.StepCreate,
+			Detail: "在长亭雷池WAF中新建证书，域名：" + strings.Join(local.Bundle.Leaf.DNSNames, ",")}}, nil
+	}
+	return []target.Step{{Action: target.StepUpdate,
+		Detail: fmt.Sprintf("更新长亭雷池WAF证书 ID %d 的内容，引用该证书的站点随之生效", t.certId)}}, nil
+}
+
+func (t *safelineTarget) Deploy(ctx context.Context, local target.Cert) error {
+	manual := ManualCert{Crt: local.Bundle.CrtPEM, Key: local.Bundle.KeyPEM}
+	if t.certId == 0 {
+		certId, err := t.client.CreateCert(ctx, manual)
+		if err != nil {
+			return fmt.Errorf("长亭雷池WAF新建证书失败：%w", err)
+		}
+		t.certId = certId
+		utils.Println("长亭雷池WAF新建证书成功，证书 ID：", certId)
+		return nil
+	}
+	if err := t.client.UpdateCert(ctx, t.certId, manual); err != nil {
+		return fmt.Errorf("长亭雷池WAF站点证书同步失败：%w", err)
+	}
+	return nil
+}
+
+func (t *safelineTarget) Verify(ctx context.Context, local target.Cert) error {
+	current, err := t.Describe(ctx)
+	if err != nil {
+		return err
+	}
+	if current == nil || current.Fingerprint != utils.CertFingerprint(local.Bundle.Leaf) {
+		return fmt.Errorf("长亭雷池WAF站点证书与本地证书不一致")
+	}
+
+	//展示最终结果
+	utils.Println("长亭雷池WAF站点证书同步成功，同步内容如下：\n",
+		"证书 ID：", t.certId, "\r\n",
+		"域名：", strings.Join(current.Domains, ","), "\r\n",
+		"颁发机构：", current.Issuer, "\r\n",
+		"有效期至：", current.NotAfter.Local().Format("2006-01-02 15:04:05"), "\r\n",
+		"序列号：", current.Serial, "\r\n",
+		"指纹：", current.Fingerprint)
+	return nil
+}
@@ -0,0 +1,166 @@
+package safeline
+
+import (
+	"context"
+	"crypto/x509"
+	"strconv"
+	"strings"
+	"testing"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/target"
+	"whoyang.cn/update_cert/utils"
+)
+
+func TestMatchCert(t *testing.T) {
+	wildcard := &x509.Certificate{DNSNames: []string{"example.com", "*.example.com"}}
+	tests := []struct {
+		name  string
+		nodes []CertNode
+		leaf  *x509.Certificate
+		want  int
+	}{
+		{"没有证书", nil, wildcard, 0},
+		{"域名完全一致", []CertNode{{Id: 1, Domains: []string{"example.com", "*.example.com"}}}, wildcard, 1},
+		{"通配符覆盖子域名", []CertNode{{Id: 1, Domains: []string{"www.example.com"}}}, wildcard, 1},
+		{"多级子域名不被通配符覆盖", []CertNode{{Id: 1, Domains: []string{"a.b.example.com"}}}, wildcard, 0},
+		{"精确域名不覆盖通配符", []CertNode{{Id: 1, Domains: []string{"*.example.com"}}},
+			&x509.Certificate{DNSNames: []string{"www.example.com"}}, 0},
+		{"部分域名未覆盖", []CertNode{{Id: 1, Domains: []string{"example.com", "example.org"}}}, wildcard, 0},
+		{"跳过没有域名的证书", []CertNode{{Id: 1}, {Id: 2, Domains: []string{"example.com"}}}, wildcard, 2},
+		{"优先完全一致", []CertNode{
+			{Id: 1, Domains: []string{"www.example.com"}},
+			{Id: 2, Domains: []string{"*.example.com", "example.com"}},
+			{Id: 3, Domains: []string{"api.example.com"}},
+		}, wildcard, 2},
+		{"其次域名最多", []CertNode{
+			{Id: 1, Domains: []string{"www.example.com"}},
+			{Id: 2, Domains: []string{"www.example.com", "api.example.com"}},
+			{Id: 3, Domains: []string{"example.com"}},
+		}, &x509.Certificate{DNSNames: []string{"*.example.com", "example.com", "example.org"}}, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := 0
+			if node := matchCert(test.nodes, test.leaf); node != nil {
+				got = node.Id
+			}
+			if got != test.want {
+				t.Fatalf("匹配到证书 %d，期望 %d", got, test.want)
+			}
+		})
+	}
+}
+
+// 创建指向模拟服务的部署目标
+func newTestTarget(t *testing.T, server *fakeServer, certId string) *safelineTarget {
+	t.Helper()
+	created, err := NewTarget(config.Target{Name: "waf", Type: config.TypeSafeline, Safeline: config.Safeline{
+		Url: server.URL, ApiToken: testToken, CertId: certId,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created.(*safelineTarget)
+}
+
+func loadLocal(t *testing.T, manual ManualCert) target.Cert {
+	t.Helper()
+	bundle, err := utils.ParseCertBundle([]byte(manual.Crt), []byte(manual.Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return target.Cert{Name: "example", Bundle: bundle}
+}
+
+// 按域名查找、部署并校验，返回部署后的证书 ID
+func deployLocal(t *testing.T, waf *safelineTarget, local target.Cert, wantAction string) int {
+	t.Helper()
+	ctx := context.Background()
+	if err := waf.Resolve(ctx, local); err != nil {
+		t.Fatal(err)
+	}
+	steps, err := waf.Plan(ctx, nil, local)
+	if err != nil || len(steps) != 1 || steps[0].Action != wantAction {
+		t.Fatalf("部署计划 = %+v，%v，期望 %s", steps, err, wantAction)
+	}
+	if err := waf.Deploy(ctx, local); err != nil {
+		t.Fatal(err)
+	}
+	if err := waf.Verify(ctx, local); err != nil {
+		t.Fatal(err)
+	}
+	return waf.certId
+}
+
+func TestTargetCreateThenUpdate(t *testing.T) {
+	server := newFakeServer(t)
+	other := server.add(newTestCert(t, "example.org"))
+
+	// 没有匹配域名的证书时新建
+	local := loadLocal(t, newTestCert(t, "example.com", "*.example.com"))
+	certId := deployLocal(t, newTestTarget(t, server, ""), local, target.StepCreate)
+	if certId == other {
+		t.Fatalf("应新建证书，不能使用其他域名的证书 %d", other)
+	}
+
+	// 再次执行时按域名找到刚才新建的证书并更新
+	renewed := loadLocal(t, newTestCert(t, "example.com", "*.example.com"))
+	waf := newTestTarget(t, server, "")
+	if got := deployLocal(t, waf, renewed, target.StepUpdate); got != certId {
+		t.Fatalf("更新的证书 ID = %d，期望 %d", got, certId)
+	}
+	current, err := waf.Describe(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Id != strconv.Itoa(certId) || current.Fingerprint != utils.CertFingerprint(renewed.Bundle.Leaf) ||
+		!current.NotAfter.Equal(renewed.Bundle.Leaf.NotAfter) || len(current.Domains) != 2 {
+		t.Fatalf("远端证书 = %+v", current)
+	}
+	if len(server.certs) != 2 {
+		t.Fatalf("证书数量 = %d，期望 2", len(server.certs))
+	}
+}
+
+func TestTargetConfiguredCertId(t *testing.T) {
+	server := newFakeServer(t)
+	certId := server.add(newTestCert(t, "example.org"))
+
+	// 配置了证书 ID 时不按域名查找，直接替换该证书
+	waf := newTestTarget(t, server, strconv.Itoa(certId))
+	local := loadLocal(t, newTestCert(t, "example.com"))
+	if got := deployLocal(t, waf, local, target.StepUpdate); got != certId {
+		t.Fatalf("证书 ID = %d，期望 %d", got, certId)
+	}
+	if server.requests[0] != "POST /api/open/cert" {
+		t.Errorf("配置证书 ID 时部署前不应查询证书列表：%v", server.requests)
+	}
+
+	// 证书 ID 不存在时 Describe 返回错误
+	missing := newTestTarget(t, server, "99")
+	if _, err := missing.Describe(context.Background()); err == nil {
+		t.Fatal("证书 ID 不存在时应返回错误")
+	}
+}
+
+func TestNewTargetErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		safeline config.Safeline
+		want     string
+	}{
+		{"缺少 token", config.Safeline{Url: "https://127.0.0.1:9443"}, "API TOKEN"},
+		{"证书 ID 不合法", config.Safeline{ApiToken: testToken, CertId: "abc"}, "证书ID abc 不合法"},
+		{"证书 ID 为 0", config.Safeline{ApiToken: testToken, CertId: "0"}, "不合法"},
+		{"TLS 配置异常", config.Safeline{ApiToken: testToken, TLS: config.TLS{CaFile: "/nonexistent/ca.pem"}}, "TLS 配置异常"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewTarget(config.Target{Name: "waf", Type: config.TypeSafeline, Safeline: test.safeline})
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("错误 = %v，期望包含 %q", err, test.want)
+			}
+		})
+	}
+}
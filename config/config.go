@@ -0,0 +1,574 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 目标类型
+const (
+	TypeSafeline   = "safeline"
+	TypeAliyunOss  = "aliyun-oss"
+	TypeAliyunCdn  = "aliyun-cdn"
+	TypeAliyunDcdn = "aliyun-dcdn"
+	TypeAliyunSlb  = "aliyun-slb"
+	TypeAliyunAlb  = "aliyun-alb"
+	TypeAliyunNlb  = "aliyun-nlb"
+)
+
+// 目标类型族，命令行参数为类型族时选中该族的全部类型
+const FamilyAliyun = "aliyun"
+
+// 目标类型所属的类型族，例如 aliyun-oss 属于 aliyun，没有族的类型返回自身
+func TypeFamily(targetType string) string {
+	family, _, _ := strings.Cut(targetType, "-")
+	return family
+}
+
+// 默认证书名称，.env 兼容模式下只有这一张证书
+const DefaultCertName = "default"
+
+// 配置文件结构
+type Config struct {
+	Acme     Acme     `yaml:"acme"`
+	Defaults Defaults `yaml:"defaults"`
+	Certs    []Cert   `yaml:"certs"`
+	Targets  []Target `yaml:"targets"`
+	Notify   Notify   `yaml:"notify"`
+
+	// 是否由旧版 .env 变量生成
+	Legacy bool `yaml:"-"`
+}
+
+// 各类目标的公共默认值，目标内未填写的字段使用这里的值
+type Defaults struct {
+	Renew    Renew    `yaml:"renew"`
+	Schedule Schedule `yaml:"schedule"`
+	Probe    Probe    `yaml:"probe"`
+	Safeline Safeline `yaml:"safeline"`
+	Aliyun   Aliyun   `yaml:"aliyun"`
+}
+
+// 证书更新策略
+type Renew struct {
+	// before_expiry（默认）、lifetime_fraction、always、newer_only
+	Policy string `yaml:"policy"`
+	// 过期前多久更新，例如 72h、7d
+	BeforeExpiry string `yaml:"before_expiry"`
+	// 剩余有效期不足总有效期的比例时更新，例如 0.33
+	Fraction float64 `yaml:"fraction"`
+}
+
+// 常驻模式下的检查计划，cron 和 interval 二选一，都未填写时使用 interval 的默认值
+type Schedule struct {
+	// 标准 cron 表达式，例如 0 3 * * *，也支持 @daily、@every 6h
+	Cron string `yaml:"cron"`
+	// 固定间隔，例如 12h、1d
+	Interval string `yaml:"interval"`
+	// 每次执行前随机延迟的上限，避免多台主机同时调用接口，例如 30m
+	Jitter string `yaml:"jitter"`
+}
+
+// 探测对外提供的证书：与各地址 TLS 握手，检查实际返回的证书与本地证书是否一致
+type Probe struct {
+	// 探测地址：域名、域名:端口，或 域名@地址:端口（以域名作为 SNI 连接指定地址），
+	// 未填写时探测目标证书覆盖的域名（不含通配符）的 443 端口
+	Endpoints []string `yaml:"endpoints"`
+	// 部署后探测，返回的证书与本地证书不一致时视为部署失败；目标填写 false 时不继承 defaults 中的 true
+	AfterDeploy *bool `yaml:"after_deploy"`
+	// 部署后等待新证书生效的最长时间，期间每 10s 探测一次，例如 CDN 可以填写 5m；默认只探测一次
+	Wait string `yaml:"wait"`
+	// 单个地址的握手超时，默认 10s
+	Timeout string `yaml:"timeout"`
+}
+
+// 证书来源
+type Cert struct {
+	Name    string `yaml:"name"`
+	CrtPath string `yaml:"crt_path"`
+	KeyPath string `yaml:"key_path"`
+	// 通过 ACME 签发时填写，签发的证书写入 crt_path 和 key_path
+	Acme *Issue `yaml:"acme"`
+}
+
+// ACME 账户配置
+type Acme struct {
+	// 目录地址，默认 Let's Encrypt，测试时可以指向 Pebble，例如 https://localhost:14000/dir
+	DirectoryUrl string `yaml:"directory_url"`
+	Email        string `yaml:"email"`
+	// 账户私钥路径，不存在时自动生成
+	AccountKey string `yaml:"account_key"`
+	// 访问目录地址时信任的 CA 证书，Pebble 等使用私有 CA 时填写
+	CaFile string `yaml:"ca_file"`
+}
+
+// 证书签发配置
+type Issue struct {
+	Domains []string `yaml:"domains"`
+	// 证书私钥类型：ec256（默认）、ec384、rsa2048、rsa4096
+	KeyType string `yaml:"key_type"`
+	// 验证方式：http-01（默认）、dns-01，通配符域名只能使用 dns-01
+	Challenge string        `yaml:"challenge"`
+	HTTP      HTTPChallenge `yaml:"http"`
+	DNS       DNSChallenge  `yaml:"dns"`
+	// 本地证书剩余有效期不足时重新签发，默认 30d
+	RenewBefore string `yaml:"renew_before"`
+}
+
+// HTTP-01 验证配置
+type HTTPChallenge struct {
+	// 独立监听的地址，默认 :80
+	Listen string `yaml:"listen"`
+	// 已有 Web 服务的站点根目录，填写后写入验证文件，不再独立监听
+	Webroot string `yaml:"webroot"`
+}
+
+// DNS-01 验证配置
+type DNSChallenge struct {
+	// DNS 服务商：alidns、challtestsrv
+	Provider string `yaml:"provider"`
+	// 服务商接口地址，alidns 默认 alidns.aliyuncs.com，测试时可以指向本地模拟服务；
+	// challtestsrv 为管理接口，例如 http://localhost:8055
+	Url string `yaml:"url"`
+	// alidns 使用的 AccessKey，未填写时使用 defaults.aliyun
+	Aliyun Aliyun `yaml:"aliyun"`
+	// 等待 TXT 记录生效的最长时间，默认 2m，填写 0 时不检查
+	PropagationTimeout string `yaml:"propagation_timeout"`
+	// 检查 TXT 记录使用的 DNS 服务器，默认查询域名的权威 DNS 服务器
+	Nameservers []string `yaml:"nameservers"`
+}
+
+// 通知配置
+type Notify struct {
+	// 同一通知渠道、同一目标、同一事件的最短通知间隔，默认 1h，填写 0 时不限制
+	RateLimit string `yaml:"rate_limit"`
+	// 远端证书剩余有效期不足该时长且未能更新时发送即将过期通知，默认 7d
+	ExpiryWarning string `yaml:"expiry_warning"`
+	// 记录通知发送时间的文件，单次执行时也能限流，未填写时只在进程内限流
+	StateFile string     `yaml:"state_file"`
+	Notifiers []Notifier `yaml:"notifiers"`
+}
+
+// 通知渠道
+type Notifier struct {
+	Name string `yaml:"name"`
+	// dingtalk、wecom、feishu、email、webhook
+	Type string `yaml:"type"`
+	// 机器人或 webhook 地址
+	Url string `yaml:"url"`
+	// 钉钉、飞书机器人的加签密钥
+	Secret string `yaml:"secret"`
+	// webhook 附加的请求头
+	Headers map[string]string `yaml:"headers"`
+	// 触发通知的事件：success、skip、failure、expiry，默认 failure、expiry
+	Events []string `yaml:"events"`
+	// Go text/template 格式的消息模板，未填写时使用默认模板
+	Template string `yaml:"template"`
+	Email    Email  `yaml:"email"`
+}
+
+// 邮件通知的 SMTP 配置
+type Email struct {
+	Host string `yaml:"host"`
+	// 默认 25，465 端口使用 TLS 连接，其他端口服务端支持时使用 STARTTLS
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+// 长亭雷池WAF配置
+type Safeline struct {
+	Url      string `yaml:"url"`
+	ApiToken string `yaml:"api_token"`
+	CertId   string `yaml:"cert_id"`
+	TLS      TLS    `yaml:"tls"`
+}
+
+// 访问管理接口时的 TLS 校验配置
+type TLS struct {
+	// 自定义 CA 证书文件
+	CaFile string `yaml:"ca_file"`
+	// 管理证书公钥的 SHA-256，sha256/Base64 或十六进制
+	PinSHA256 []string `yaml:"pin_sha256"`
+	// 跳过证书校验，需要显式开启；目标填写 false 时不继承 defaults 中的 true
+	Insecure *bool `yaml:"insecure"`
+}
+
+// 阿里云配置
+type Aliyun struct {
+	AccessKeyId     string `yaml:"access_key_id"`
+	AccessKeySecret string `yaml:"access_key_secret"`
+	// 不使用长期 AccessKey 时的凭据配置
+	Credential Credential `yaml:"credential"`
+	// CAS 所在地域，国际站账号为 ap-southeast-1，默认 cn-hangzhou
+	CasRegion string `yaml:"cas_region"`
+	// CAS 接口地址，默认按 cas_region 生成，也可以指向测试用的模拟服务
+	CasEndpoint string `yaml:"cas_endpoint"`
+	// OSS 服务地址，未填写时按 Bucket 所在地域生成
+	OssEndpoint string `yaml:"oss_endpoint"`
+	BucketName  string `yaml:"bucket_name"`
+	// 多个 OSS Bucket，与 bucket_name 合并；都未填写时更新账号下全部 Bucket
+	Buckets []string `yaml:"buckets"`
+	// OSS 绑定的域名，未填写时更新本地证书覆盖的全部绑定域名；
+	// 或者 CDN / DCDN 的加速域名；负载均衡替换扩展证书时为扩展证书对应的域名
+	Domain string `yaml:"domain"`
+
+	// 负载均衡所在地域，例如 cn-hangzhou
+	Region string `yaml:"region"`
+	// 负载均衡接口地址，默认按地域生成，例如 alb.cn-hangzhou.aliyuncs.com；
+	// CDN / DCDN 的接口地址，默认为 cdn.aliyuncs.com、dcdn.aliyuncs.com
+	Endpoint string `yaml:"endpoint"`
+	// CLB 实例 ID 和 HTTPS 监听端口
+	LoadBalancerId string `yaml:"load_balancer_id"`
+	ListenerPort   int    `yaml:"listener_port"`
+	// ALB 的 HTTPS 监听或 NLB 的 TCPSSL 监听 ID
+	ListenerId string `yaml:"listener_id"`
+	// 替换监听的默认证书 default（默认）或扩展证书 additional
+	Certificate string `yaml:"certificate"`
+}
+
+// 阿里云凭据，type 未填写时：填写了 AccessKey 使用 access_key，否则使用默认凭据链
+// （环境变量、OIDC、~/.aliyun/config.json、~/.alibabacloud/credentials、ECS 实例 RAM 角色、ALIBABA_CLOUD_CREDENTIALS_URI）
+type Credential struct {
+	// access_key、sts、ram_role_arn、oidc_role_arn、ecs_ram_role、credentials_uri、profile、default
+	Type string `yaml:"type"`
+	// sts 使用的临时 Token，AccessKey 填写在 access_key_id、access_key_secret
+	SecurityToken string `yaml:"security_token"`
+	// ram_role_arn、oidc_role_arn 扮演的角色，ram_role_arn 使用 access_key_id、access_key_secret 扮演
+	RoleArn         string `yaml:"role_arn"`
+	RoleSessionName string `yaml:"role_session_name"`
+	ExternalId      string `yaml:"external_id"`
+	StsEndpoint     string `yaml:"sts_endpoint"`
+	// oidc_role_arn 的身份提供商和 OIDC Token 文件，未填写时读取 ALIBABA_CLOUD_OIDC_PROVIDER_ARN 等环境变量
+	OIDCProviderArn string `yaml:"oidc_provider_arn"`
+	OIDCTokenFile   string `yaml:"oidc_token_file"`
+	// ecs_ram_role 的角色名称，未填写时从实例元数据获取
+	RoleName string `yaml:"role_name"`
+	// ecs_ram_role 访问实例元数据（100.100.100.200）时经过的 HTTP 代理地址，
+	// 测试时可以指向本地的元数据替身服务，例如 http://127.0.0.1:8080
+	MetadataEndpoint string `yaml:"metadata_endpoint"`
+	// credentials_uri 的凭据地址，返回 AccessKeyId、AccessKeySecret、SecurityToken、Expiration，
+	// 可以指向本地的元数据替身服务用于测试
+	Url string `yaml:"url"`
+	// profile 使用的 ~/.alibabacloud/credentials 中的配置名称，默认 default
+	Profile string `yaml:"profile"`
+}
+
+// 部署目标
+type Target struct {
+	Name     string   `yaml:"name"`
+	Type     string   `yaml:"type"`
+	Cert     string   `yaml:"cert"`
+	Renew    Renew    `yaml:"renew"`
+	Schedule Schedule `yaml:"schedule"`
+	Probe    Probe    `yaml:"probe"`
+	Safeline Safeline `yaml:"safeline"`
+	Aliyun   Aliyun   `yaml:"aliyun"`
+}
+
+// 读取配置文件，文件内容支持 ${ENV} 形式引用环境变量，其他的 $ 原样保留
+func Load(path string) (*Config, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件异常：%w", err)
+	}
+
+	config := &Config{}
+	if err := yaml.Unmarshal([]byte(expandEnv(string(file))), config); err != nil {
+		return nil, fmt.Errorf("解析配置文件 %s 异常：%w", path, err)
+	}
+
+	config.applyDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// 只替换 ${ENV} 形式的环境变量，密码等值中的 $ 和 $name 原样保留
+func expandEnv(content string) string {
+	var builder strings.Builder
+	for {
+		start := strings.Index(content, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(content[start+2:], '}')
+		if end < 0 {
+			break
+		}
+		builder.WriteString(content[:start])
+		builder.WriteString(os.Getenv(content[start+2 : start+2+end]))
+		content = content[start+2+end+1:]
+	}
+	builder.WriteString(content)
+	return builder.String()
+}
+
+// 强制部署全部目标，忽略配置的更新策略
+func (c *Config) Force() {
+	for i := range c.Targets {
+		c.Targets[i].Renew.Policy = "always"
+	}
+}
+
+// 由旧版 .env 变量生成配置，保持向下兼容
+func FromEnv() *Config {
+	config := &Config{
+		Legacy: true,
+		Certs: []Cert{{
+			Name:    DefaultCertName,
+			CrtPath: os.Getenv("CERT_CRT_PATH"),
+			KeyPath: os.Getenv("CERT_KEY_PATH"),
+		}},
+		Defaults: Defaults{
+			Schedule: Schedule{
+				Cron:     os.Getenv("SCHEDULE_CRON"),
+				Interval: os.Getenv("SCHEDULE_INTERVAL"),
+				Jitter:   os.Getenv("SCHEDULE_JITTER"),
+			},
+		},
+		Targets: []Target{{
+			Name: TypeSafeline,
+			Type: TypeSafeline,
+			Cert: DefaultCertName,
+			Safeline: Safeline{
+				Url:      os.Getenv("BASE_SERVER_URL"),
+				ApiToken: os.Getenv("API_TOKEN"),
+				CertId:   os.Getenv("CERT_ID"),
+				TLS: TLS{
+					CaFile:    os.Getenv("SAFELINE_CA_FILE"),
+					PinSHA256: splitList(os.Getenv("SAFELINE_PIN_SHA256")),
+					Insecure:  Bool(os.Getenv("SAFELINE_INSECURE") == "true"),
+				},
+			},
+		}, {
+			Name: "aliyun",
+			Type: TypeAliyunOss,
+			Cert: DefaultCertName,
+			Aliyun: Aliyun{
+				AccessKeyId:     os.Getenv("ALIYUN_ACCESS_KEY_ID"),
+				AccessKeySecret: os.Getenv("ALIYUN_ACCESS_SECRET"),
+				Credential: Credential{
+					Type:    os.Getenv("ALIYUN_CREDENTIAL_TYPE"),
+					RoleArn: os.Getenv("ALIYUN_ROLE_ARN"),
+				},
+				CasRegion:   os.Getenv("ALIYUN_CAS_REGION"),
+				CasEndpoint: os.Getenv("ALIYUN_CAS_ENDPOINT"),
+				OssEndpoint: os.Getenv("ALIYUN_OSS_Endpoint"),
+				BucketName:  os.Getenv("ALIYUN_OSS_BUCKET_NAME"),
+				Domain:      os.Getenv("ALIYUN_OSS_DOMAIN"),
+			},
+		}},
+	}
+	config.applyDefaults()
+	return config
+}
+
+// 校验配置：名称唯一、证书引用存在，目标类型在创建目标时校验
+func (c *Config) Validate() error {
+	if len(c.Targets) == 0 {
+		return fmt.Errorf("配置文件中未声明任何部署目标")
+	}
+
+	certNames := make(map[string]bool)
+	for _, cert := range c.Certs {
+		if cert.Name == "" {
+			return fmt.Errorf("证书名称不能为空")
+		}
+		if certNames[cert.Name] {
+			return fmt.Errorf("证书名称 %s 重复", cert.Name)
+		}
+		certNames[cert.Name] = true
+
+		if cert.Acme != nil {
+			if len(cert.Acme.Domains) == 0 {
+				return fmt.Errorf("证书 %s 的 acme.domains 不能为空", cert.Name)
+			}
+			if cert.CrtPath == "" || cert.KeyPath == "" {
+				return fmt.Errorf("证书 %s 需要填写 crt_path 和 key_path 用于保存签发的证书", cert.Name)
+			}
+		}
+	}
+
+	targetNames := make(map[string]bool)
+	for _, target := range c.Targets {
+		if target.Name == "" {
+			return fmt.Errorf("部署目标名称不能为空")
+		}
+		if targetNames[target.Name] {
+			return fmt.Errorf("部署目标名称 %s 重复", target.Name)
+		}
+		targetNames[target.Name] = true
+
+		if target.Type == "" {
+			return fmt.Errorf("部署目标 %s 的类型不能为空", target.Name)
+		}
+		if !certNames[target.Cert] {
+			return fmt.Errorf("部署目标 %s 引用的证书 %s 不存在", target.Name, target.Cert)
+		}
+		if target.Schedule.Cron != "" && target.Schedule.Interval != "" {
+			return fmt.Errorf("部署目标 %s 的 schedule.cron 和 schedule.interval 只能配置一个", target.Name)
+		}
+	}
+
+	notifierNames := make(map[string]bool)
+	for _, notifier := range c.Notify.Notifiers {
+		if notifier.Name == "" {
+			return fmt.Errorf("通知渠道名称不能为空")
+		}
+		if notifierNames[notifier.Name] {
+			return fmt.Errorf("通知渠道名称 %s 重复", notifier.Name)
+		}
+		notifierNames[notifier.Name] = true
+	}
+	return nil
+}
+
+// 查找证书
+func (c *Config) FindCert(name string) (Cert, bool) {
+	for _, cert := range c.Certs {
+		if cert.Name == name {
+			return cert, true
+		}
+	}
+	return Cert{}, false
+}
+
+// 按命令行参数筛选目标：all 或空表示全部；有同名目标时只选中该目标，
+// 否则匹配完整的目标类型或类型族（aliyun 表示全部阿里云目标）
+func (c *Config) SelectTargets(selector string) []Target {
+	all := selector == "" || selector == "all"
+	if !all {
+		for _, target := range c.Targets {
+			if target.Name == selector {
+				return []Target{target}
+			}
+		}
+	}
+	var targets []Target
+	for _, target := range c.Targets {
+		if all || target.Type == selector || TypeFamily(target.Type) == selector {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// 将 defaults 中的值填充到目标未配置的字段
+func (c *Config) applyDefaults() {
+	for i := range c.Certs {
+		if issue := c.Certs[i].Acme; issue != nil {
+			c.Defaults.Aliyun.fillCredential(&issue.DNS.Aliyun)
+		}
+	}
+
+	// 只声明了一张证书时，目标可以省略 cert
+	for i := range c.Targets {
+		target := &c.Targets[i]
+		if target.Cert == "" && len(c.Certs) == 1 {
+			target.Cert = c.Certs[0].Name
+		}
+
+		fillString(&target.Renew.Policy, c.Defaults.Renew.Policy)
+		fillString(&target.Renew.BeforeExpiry, c.Defaults.Renew.BeforeExpiry)
+		if target.Renew.Fraction == 0 {
+			target.Renew.Fraction = c.Defaults.Renew.Fraction
+		}
+
+		// cron 和 interval 互斥，目标配置了其中一个时不再继承另一个
+		if target.Schedule.Cron == "" && target.Schedule.Interval == "" {
+			target.Schedule.Cron = c.Defaults.Schedule.Cron
+			target.Schedule.Interval = c.Defaults.Schedule.Interval
+		}
+		fillString(&target.Schedule.Jitter, c.Defaults.Schedule.Jitter)
+
+		// 探测地址与目标相关，不从 defaults 继承
+		fillBool(&target.Probe.AfterDeploy, c.Defaults.Probe.AfterDeploy)
+		fillString(&target.Probe.Wait, c.Defaults.Probe.Wait)
+		fillString(&target.Probe.Timeout, c.Defaults.Probe.Timeout)
+
+		fillString(&target.Safeline.Url, c.Defaults.Safeline.Url)
+		fillString(&target.Safeline.ApiToken, c.Defaults.Safeline.ApiToken)
+		fillString(&target.Safeline.CertId, c.Defaults.Safeline.CertId)
+		fillString(&target.Safeline.TLS.CaFile, c.Defaults.Safeline.TLS.CaFile)
+		if len(target.Safeline.TLS.PinSHA256) == 0 {
+			target.Safeline.TLS.PinSHA256 = c.Defaults.Safeline.TLS.PinSHA256
+		}
+		fillBool(&target.Safeline.TLS.Insecure, c.Defaults.Safeline.TLS.Insecure)
+
+		c.Defaults.Aliyun.fillCredential(&target.Aliyun)
+		fillString(&target.Aliyun.CasRegion, c.Defaults.Aliyun.CasRegion)
+		fillString(&target.Aliyun.CasEndpoint, c.Defaults.Aliyun.CasEndpoint)
+		fillString(&target.Aliyun.OssEndpoint, c.Defaults.Aliyun.OssEndpoint)
+		// bucket_name 和 buckets 一起继承，目标配置了其中一个时不再合并默认的 Bucket
+		if target.Aliyun.BucketName == "" && len(target.Aliyun.Buckets) == 0 {
+			target.Aliyun.BucketName = c.Defaults.Aliyun.BucketName
+			target.Aliyun.Buckets = c.Defaults.Aliyun.Buckets
+		}
+		fillString(&target.Aliyun.Domain, c.Defaults.Aliyun.Domain)
+		fillString(&target.Aliyun.Region, c.Defaults.Aliyun.Region)
+	}
+}
+
+// 填充 AccessKey 和凭据，目标配置了自己的 AccessKey 或凭据类型时不继承默认的凭据类型
+func (d Aliyun) fillCredential(aliyun *Aliyun) {
+	if aliyun.AccessKeyId == "" && aliyun.Credential.Type == "" {
+		aliyun.Credential = d.Credential
+	}
+	fillString(&aliyun.AccessKeyId, d.AccessKeyId)
+	fillString(&aliyun.AccessKeySecret, d.AccessKeySecret)
+}
+
+// 拆分逗号分隔的列表，忽略空白项
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func fillString(value *string, defaultValue string) {
+	if *value == "" {
+		*value = defaultValue
+	}
+}
+
+// 目标未填写时使用默认值，填写了 false 时保留
+func fillBool(value **bool, defaultValue *bool) {
+	if *value == nil {
+		*value = defaultValue
+	}
+}
+
+// 返回指向 value 的指针
+func Bool(value bool) *bool {
+	return &value
+}
+
+// 未填写时为 false
+func BoolValue(value *bool) bool {
+	return value != nil && *value
+}
+
+// 解析时长，在 time.ParseDuration 的基础上支持以 d 结尾的天数，例如 7d
+func ParseDuration(value string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		count, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("时长 %s 不合法", value)
+		}
+		return time.Duration(count * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(value)
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("UPDATE_CERT_TOKEN", "abc")
+	t.Setenv("HOME_DIR", "/home/test")
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"token: ${UPDATE_CERT_TOKEN}", "token: abc"},
+		{"${HOME_DIR}/cert-${UPDATE_CERT_TOKEN}.pem", "/home/test/cert-abc.pem"},
+		{"password: pa$$word", "password: pa$$word"},
+		{"password: $HOME_DIR", "password: $HOME_DIR"},
+		{"secret: a$b${UPDATE_CERT_TOKEN}$", "secret: a$babc$"},
+		{"missing: ${UPDATE_CERT_MISSING}", "missing: "},
+		{"unclosed: ${UPDATE_CERT_TOKEN", "unclosed: ${UPDATE_CERT_TOKEN"},
+	}
+	for _, test := range tests {
+		if got := expandEnv(test.input); got != test.want {
+			t.Errorf("expandEnv(%q) = %q，期望 %q", test.input, got, test.want)
+		}
+	}
+}
+
+// 写入配置文件并加载
+func loadTestConfig(t *testing.T, content string) *Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestLoadKeepsDollarInSecret(t *testing.T) {
+	t.Setenv("UPDATE_CERT_TOKEN", "token-$1")
+	config := loadTestConfig(t, `certs:
+  - name: example
+    crt_path: cert.pem
+    key_path: key.pem
+targets:
+  - name: waf
+    type: safeline
+    cert: example
+    safeline:
+      api_token: ${UPDATE_CERT_TOKEN}
+  - name: oss
+    type: aliyun-oss
+    cert: example
+    aliyun:
+      access_key_id: LTAI
+      access_key_secret: "s3cr$t$HOME"
+`)
+	if token := config.Targets[0].Safeline.ApiToken; token != "token-$1" {
+		t.Errorf("api_token = %q", token)
+	}
+	if secret := config.Targets[1].Aliyun.AccessKeySecret; secret != "s3cr$t$HOME" {
+		t.Errorf("access_key_secret = %q，$ 不应被替换", secret)
+	}
+}
+
+func TestApplyDefaultsBoolOverride(t *testing.T) {
+	config := loadTestConfig(t, `defaults:
+  probe:
+    after_deploy: true
+    wait: 5m
+  safeline:
+    url: https://127.0.0.1:9443
+    tls:
+      insecure: true
+certs:
+  - name: example
+    crt_path: cert.pem
+    key_path: key.pem
+targets:
+  - name: inherit
+    type: safeline
+  - name: override
+    type: safeline
+    probe:
+      after_deploy: false
+    safeline:
+      tls:
+        insecure: false
+  - name: explicit
+    type: safeline
+    probe:
+      after_deploy: true
+`)
+	tests := []struct {
+		target      string
+		afterDeploy bool
+		insecure    bool
+	}{
+		{"inherit", true, true},
+		{"override", false, false},
+		{"explicit", true, true},
+	}
+	for i, test := range tests {
+		target := config.Targets[i]
+		if target.Name != test.target {
+			t.Fatalf("目标 = %s，期望 %s", target.Name, test.target)
+		}
+		if got := BoolValue(target.Probe.AfterDeploy); got != test.afterDeploy {
+			t.Errorf("%s probe.after_deploy = %v，期望 %v", target.Name, got, test.afterDeploy)
+		}
+		if got := BoolValue(target.Safeline.TLS.Insecure); got != test.insecure {
+			t.Errorf("%s safeline.tls.insecure = %v，期望 %v", target.Name, got, test.insecure)
+		}
+		if target.Cert != "example" || target.Probe.Wait != "5m" || target.Safeline.Url != "https://127.0.0.1:9443" {
+			t.Errorf("%s 未继承默认值：%+v", target.Name, target)
+		}
+	}
+}
+
+func TestApplyDefaultsWithoutDefaults(t *testing.T) {
+	config := loadTestConfig(t, `certs:
+  - name: example
+    crt_path: cert.pem
+    key_path: key.pem
+targets:
+  - name: waf
+    type: safeline
+    probe:
+      after_deploy: true
+`)
+	target := config.Targets[0]
+	if !BoolValue(target.Probe.AfterDeploy) {
+		t.Error("目标开启的 probe.after_deploy 不应被默认值覆盖")
+	}
+	if target.Safeline.TLS.Insecure != nil || BoolValue(target.Safeline.TLS.Insecure) {
+		t.Error("未填写 insecure 时应为 false")
+	}
+}
+
+func TestSelectTargets(t *testing.T) {
+	config := &Config{Targets: []Target{
+		{Name: "waf", Type: TypeSafeline},
+		{Name: "oss", Type: TypeAliyunOss},
+		{Name: "cdn", Type: TypeAliyunCdn},
+		{Name: "dcdn", Type: TypeAliyunDcdn},
+		// 名称与类型相同的目标
+		{Name: "aliyun-cdn", Type: TypeAliyunSlb},
+	}}
+	tests := []struct {
+		selector string
+		want     []string
+	}{
+		{"all", []string{"waf", "oss", "cdn", "dcdn", "aliyun-cdn"}},
+		{"", []string{"waf", "oss", "cdn", "dcdn", "aliyun-cdn"}},
+		{"waf", []string{"waf"}},
+		{"safeline", []string{"waf"}},
+		{"aliyun", []string{"oss", "cdn", "dcdn", "aliyun-cdn"}},
+		{"aliyun-oss", []string{"oss"}},
+		{"aliyun-dcdn", []string{"dcdn"}},
+		// 同名目标优先于类型
+		{"aliyun-cdn", []string{"aliyun-cdn"}},
+		// 不再按类型前缀匹配
+		{"ali", nil},
+		{"aliyun-c", nil},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, target := range config.SelectTargets(test.selector) {
+			got = append(got, target.Name)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SelectTargets(%s) = %v，期望 %v", test.selector, got, test.want)
+		}
+	}
+}
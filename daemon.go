@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/metrics"
+	"whoyang.cn/update_cert/notify"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 未配置检查计划时的默认间隔
+const defaultInterval = 12 * time.Hour
+
+// 支持标准五段式表达式和 @daily、@every 等描述符
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// 常驻进程：按各目标的计划检查并部署证书
+//
+// 收到 SIGHUP 时重新加载配置，收到 SIGINT/SIGTERM 时不再调度新的任务，
+// 等待正在执行的部署完成后退出，不会中断进行中的上传。
+// listen 不为空时在该地址提供 /metrics、/healthz 和 /readyz。
+func runDaemon(configPath string, selector string, force bool, listen string) int {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	if listen != "" {
+		server, err := serveMetrics(listen)
+		if err != nil {
+			utils.Println(err)
+			return exitConfigError
+		}
+		defer server.Close()
+	}
+
+	scheduler, settings, err := newScheduler(configPath, selector, force)
+	if err != nil {
+		utils.Println(err)
+		return exitConfigError
+	}
+	notifier.Apply(settings)
+	scheduler.Start()
+	metrics.SetReady(true)
+	utils.Println("常驻模式已启动，SIGHUP 重新加载配置，SIGTERM 退出")
+
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			utils.Println("收到", sig, "信号，等待进行中的部署完成后退出")
+			metrics.SetReady(false)
+			<-scheduler.Stop().Done()
+			utils.Println("常驻模式已退出")
+			return 0
+		}
+
+		utils.Println("收到 SIGHUP 信号，重新加载配置")
+		//先校验新配置，失败时继续使用旧的计划和通知渠道
+		next, settings, err := newScheduler(configPath, selector, force)
+		if err != nil {
+			utils.Println("重新加载配置失败，继续使用原配置：", err)
+			continue
+		}
+		//等待旧计划中进行中的部署完成，避免同一目标被并发部署
+		<-scheduler.Stop().Done()
+		notifier.Apply(settings)
+		scheduler = next
+		scheduler.Start()
+		utils.Println("配置重新加载完成")
+	}
+	return 0
+}
+
+// 监听指定地址提供指标和健康检查，监听失败时返回错误
+func serveMetrics(listen string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, fmt.Errorf("监听指标地址 %s 失败：%w", listen, err)
+	}
+	server := &http.Server{Handler: metrics.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			utils.Println("指标服务异常退出：", err)
+		}
+	}()
+	utils.Println("指标和健康检查地址：", listener.Addr(), "（/metrics、/healthz、/readyz）")
+	return server, nil
+}
+
+// 读取并校验配置，为每个目标创建一个定时任务；返回的通知配置由调用方与计划一起生效
+func newScheduler(configPath string, selector string, force bool) (*cron.Cron, *notify.Settings, error) {
+	cfg, err := readConfig(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	settings, err := notify.NewSettings(cfg.Notify)
+	if err != nil {
+		return nil, nil, err
+	}
+	if force {
+		cfg.Force()
+	}
+
+	targets := cfg.SelectTargets(selector)
+	if len(targets) == 0 {
+		return nil, nil, fmt.Errorf("没有匹配的部署目标：%s", selector)
+	}
+
+	logger := cron.PrintfLogger(log.New(utils.Output(), "cron: ", log.LstdFlags))
+	scheduler := cron.New(cron.WithParser(cronParser), cron.WithLogger(logger),
+		cron.WithChain(cron.Recover(logger), cron.SkipIfStillRunning(logger)))
+
+	for _, targetConfig := range targets {
+		schedule, err := newSchedule(targetConfig.Schedule)
+		if err != nil {
+			return nil, nil, fmt.Errorf("部署目标 %s 的检查计划不合法：%w", targetConfig.Name, err)
+		}
+		cert, _ := cfg.FindCert(targetConfig.Cert)
+		targetConfig := targetConfig
+		scheduler.Schedule(schedule, cron.FuncJob(func() {
+			//部署不随进程退出取消，收到退出信号时等待其完成
+			reportResult(runTarget(context.Background(), targetConfig, cert))
+			utils.Println("")
+		}))
+		utils.Println("部署目标", targetConfig.Name, "的检查计划：", describeSchedule(targetConfig.Schedule))
+	}
+	return scheduler, settings, nil
+}
+
+// 根据配置创建检查计划
+func newSchedule(scheduleConfig config.Schedule) (cron.Schedule, error) {
+	var jitter time.Duration
+	if scheduleConfig.Jitter != "" {
+		var err error
+		jitter, err = config.ParseDuration(scheduleConfig.Jitter)
+		if err != nil || jitter < 0 {
+			return nil, fmt.Errorf("jitter %s 不合法", scheduleConfig.Jitter)
+		}
+	}
+
+	if scheduleConfig.Cron != "" {
+		schedule, err := cronParser.Parse(scheduleConfig.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("cron 表达式 %s 不合法：%w", scheduleConfig.Cron, err)
+		}
+		return &jitterSchedule{schedule: schedule, jitter: jitter}, nil
+	}
+
+	interval := defaultInterval
+	if scheduleConfig.Interval != "" {
+		var err error
+		interval, err = config.ParseDuration(scheduleConfig.Interval)
+		if err != nil || interval < time.Minute {
+			return nil, fmt.Errorf("interval %s 不合法，不能小于 1m", scheduleConfig.Interval)
+		}
+	}
+	return &intervalSchedule{interval: interval, jitter: jitter}, nil
+}
+
+// 展示检查计划
+func describeSchedule(scheduleConfig config.Schedule) string {
+	description := "每 " + defaultInterval.String()
+	if scheduleConfig.Cron != "" {
+		description = "cron " + scheduleConfig.Cron
+	} else if scheduleConfig.Interval != "" {
+		description = "每 " + scheduleConfig.Interval
+	}
+	if scheduleConfig.Jitter != "" {
+		description += "，随机延迟不超过 " + scheduleConfig.Jitter
+	}
+	return description
+}
+
+// cron 表达式计划，每次执行时间加上随机延迟
+type jitterSchedule struct {
+	schedule cron.Schedule
+	jitter   time.Duration
+}
+
+func (s *jitterSchedule) Next(t time.Time) time.Time {
+	return s.schedule.Next(t).Add(randomJitter(s.jitter))
+}
+
+// 固定间隔计划，启动后先执行一次，之后每隔 interval 加随机延迟执行
+type intervalSchedule struct {
+	interval time.Duration
+	jitter   time.Duration
+	started  bool
+}
+
+func (s *intervalSchedule) Next(t time.Time) time.Time {
+	if !s.started {
+		s.started = true
+		return t.Add(randomJitter(s.jitter))
+	}
+	return t.Add(s.interval + randomJitter(s.jitter))
+}
+
+func randomJitter(jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return 0
+	}
+	return rand.N(jitter)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"whoyang.cn/update_cert/notify"
+)
+
+// 写入带通知配置和检查计划的配置文件
+func writeDaemonConfig(t *testing.T, expiryWarning string, interval string) string {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := `notify:
+  expiry_warning: ` + expiryWarning + `
+certs:
+  - name: example
+    crt_path: /nonexistent/cert.pem
+    key_path: /nonexistent/key.pem
+targets:
+  - name: waf
+    type: safeline
+    cert: example
+    schedule:
+      interval: ` + interval + `
+`
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return configPath
+}
+
+func TestNewSchedulerDefersNotifier(t *testing.T) {
+	defer func(manager *notify.Manager) { notifier = manager }(notifier)
+	notifier = notify.NewManager()
+
+	// 检查计划不合法时不影响正在使用的通知渠道
+	if _, _, err := newScheduler(writeDaemonConfig(t, "48h", "1s"), "", false); err == nil {
+		t.Fatal("检查计划不合法时应返回错误")
+	}
+	if got := notifier.ExpiryWarning(); got != notify.DefaultExpiryWarning {
+		t.Fatalf("加载失败后 expiry_warning = %s，期望保持 %s", got, notify.DefaultExpiryWarning)
+	}
+
+	if _, _, err := newScheduler(writeDaemonConfig(t, "abc", "12h"), "", false); err == nil {
+		t.Fatal("通知配置不合法时应返回错误")
+	}
+
+	// 加载成功后由调用方与新计划一起生效
+	scheduler, settings, err := newScheduler(writeDaemonConfig(t, "48h", "12h"), "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scheduler.Entries()) != 1 {
+		t.Fatalf("定时任务数量 = %d，期望 1", len(scheduler.Entries()))
+	}
+	if got := notifier.ExpiryWarning(); got != notify.DefaultExpiryWarning {
+		t.Fatalf("生效前 expiry_warning = %s，期望保持 %s", got, notify.DefaultExpiryWarning)
+	}
+	notifier.Apply(settings)
+	if got := notifier.ExpiryWarning(); got != 48*time.Hour {
+		t.Fatalf("生效后 expiry_warning = %s，期望 48h", got)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"whoyang.cn/update_cert/client/aliyun"
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/target"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 清理阿里云 CAS 中过期的上传证书，unbound 时同时清理未部署的证书，同一个凭据和 CAS 地域只清理一次
+func runGC(configPath string, selector string, dryRun bool, unbound bool) int {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		utils.Println(err)
+		return exitConfigError
+	}
+
+	accounts := gcAccounts(cfg, selector)
+	if len(accounts) == 0 {
+		utils.Println("没有匹配的阿里云部署目标，使用 defaults.aliyun 的凭据：", selector)
+		accounts = append(accounts, cfg.Defaults.Aliyun)
+	}
+
+	ctx := context.Background()
+	failed := 0
+	for _, account := range accounts {
+		utils.Println("====================================")
+		utils.Printf("清理 CAS 证书，凭据：%s，CAS：%s\n", describeAccount(account), aliyun.CasEndpoint(account))
+		utils.Println("====================================")
+		options := aliyun.GCOptions{DryRun: dryRun, Unbound: unbound}
+		if unbound {
+			options.InUse = certsInUseByTargets(ctx, cfg, account)
+		}
+		count, err := aliyun.CollectGarbage(account, options)
+		if err != nil {
+			utils.Println("清理 CAS 证书失败：", err)
+			failed++
+			continue
+		}
+		failed += count
+		utils.Println("")
+	}
+	if failed > 0 {
+		return exitFailure
+	}
+	return 0
+}
+
+// 选中的阿里云目标使用的凭据和 CAS 地域，相同的只保留一个
+func gcAccounts(cfg *config.Config, selector string) []config.Aliyun {
+	var accounts []config.Aliyun
+	seen := make(map[string]bool)
+	for _, targetConfig := range cfg.SelectTargets(selector) {
+		account := targetConfig.Aliyun
+		key := accountKey(account)
+		if config.TypeFamily(targetConfig.Type) != config.FamilyAliyun || seen[key] {
+			continue
+		}
+		seen[key] = true
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
+// 同一凭据和 CAS 地域下全部阿里云目标当前使用的证书，任一目标查询失败时返回 nil，不清理未部署的证书
+func certsInUseByTargets(ctx context.Context, cfg *config.Config, account config.Aliyun) []*target.RemoteCert {
+	inUse := make([]*target.RemoteCert, 0)
+	for _, targetConfig := range cfg.Targets {
+		if config.TypeFamily(targetConfig.Type) != config.FamilyAliyun || accountKey(targetConfig.Aliyun) != accountKey(account) {
+			continue
+		}
+		certs, err := boundCerts(ctx, cfg, targetConfig)
+		if err != nil {
+			utils.Println("查询", targetConfig.Name, "当前使用的证书失败：", err)
+			return nil
+		}
+		inUse = append(inUse, certs...)
+	}
+	return inUse
+}
+
+// 获取目标当前使用的证书，需要按本地证书确定远端资源的目标先读取本地证书
+func boundCerts(ctx context.Context, cfg *config.Config, targetConfig config.Target) ([]*target.RemoteCert, error) {
+	t, err := target.New(targetConfig)
+	if err != nil {
+		return nil, err
+	}
+	if resolver, ok := t.(target.Resolver); ok {
+		cert, _ := cfg.FindCert(targetConfig.Cert)
+		local, err := target.LoadCert(cert.Name, cert.CrtPath, cert.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := resolver.Resolve(ctx, local); err != nil {
+			return nil, err
+		}
+	}
+	return aliyun.BoundCerts(ctx, t)
+}
+
+// 区分凭据和 CAS 地域的标识，不同地域的 CAS 证书相互独立
+func accountKey(account config.Aliyun) string {
+	return fmt.Sprint(account.AccessKeyId, account.Credential, aliyun.CasEndpoint(account))
+}
+
+// 日志中展示的凭据，不输出密钥
+func describeAccount(account config.Aliyun) string {
+	if account.AccessKeyId != "" {
+		return "AccessKeyID " + account.AccessKeyId
+	}
+	if account.Credential.Type != "" {
+		return account.Credential.Type
+	}
+	return "默认凭据链"
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"whoyang.cn/update_cert/client/aliyun"
+	"whoyang.cn/update_cert/config"
+)
+
+func TestGCAccounts(t *testing.T) {
+	hangzhou := config.Aliyun{AccessKeyId: "id", AccessKeySecret: "secret"}
+	singapore := config.Aliyun{AccessKeyId: "id", AccessKeySecret: "secret", CasRegion: "ap-southeast-1"}
+	other := config.Aliyun{AccessKeyId: "other", AccessKeySecret: "secret"}
+	withBucket := hangzhou
+	withBucket.BucketName = "static"
+	cfg := &config.Config{Targets: []config.Target{
+		{Name: "oss", Type: config.TypeAliyunOss, Aliyun: withBucket},
+		{Name: "cdn", Type: config.TypeAliyunCdn, Aliyun: hangzhou},
+		{Name: "cdn-intl", Type: config.TypeAliyunCdn, Aliyun: singapore},
+		{Name: "clb", Type: config.TypeAliyunSlb, Aliyun: other},
+		{Name: "waf", Type: config.TypeSafeline},
+	}}
+
+	var endpoints []string
+	for _, account := range gcAccounts(cfg, "all") {
+		endpoints = append(endpoints, account.AccessKeyId+"@"+aliyun.CasEndpoint(account))
+	}
+	want := []string{"id@cas.aliyuncs.com", "id@cas.ap-southeast-1.aliyuncs.com", "other@cas.aliyuncs.com"}
+	if !reflect.DeepEqual(endpoints, want) {
+		t.Fatalf("清理的凭据 = %v，期望 %v", endpoints, want)
+	}
+	if accountKey(hangzhou) == accountKey(singapore) {
+		t.Fatal("不同 CAS 地域的凭据标识不能相同")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/issuer"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 通过 ACME 签发配置了 acme 的证书，签发成功后部署引用该证书的目标
+//
+// always 为 true 时（issue）总是签发，否则（renew）只在本地证书不可用或即将过期时签发。
+func runIssue(configPath string, certName string, always bool) int {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		utils.Println(err)
+		return exitConfigError
+	}
+
+	var certs []config.Cert
+	for _, cert := range cfg.Certs {
+		if cert.Acme != nil && (certName == "" || certName == "all" || cert.Name == certName) {
+			certs = append(certs, cert)
+		}
+	}
+	if len(certs) == 0 {
+		utils.Println("没有配置了 acme 的证书：", certName)
+		return exitConfigError
+	}
+
+	ctx := context.Background()
+	acmeIssuer, err := issuer.New(ctx, cfg.Acme)
+	if err != nil {
+		utils.Println("ACME 账户初始化失败：", err)
+		return exitFailure
+	}
+
+	failed := 0
+	for _, cert := range certs {
+		utils.Println("====================================")
+		utils.Println("签发证书：", cert.Name, cert.Acme.Domains)
+		utils.Println("====================================")
+
+		if !always {
+			need, reason, err := issuer.NeedIssue(cert, time.Now())
+			if err != nil {
+				failed++
+				utils.Println(err)
+				continue
+			}
+			utils.Println(reason)
+			if !need {
+				utils.Println("")
+				continue
+			}
+		}
+
+		if err := acmeIssuer.Obtain(ctx, cert); err != nil {
+			failed++
+			utils.Println(cert.Name, "证书签发失败：", err)
+			utils.Println("")
+			continue
+		}
+		utils.Println(cert.Name, "证书签发成功，已写入：", cert.CrtPath, cert.KeyPath)
+		utils.Println("")
+
+		//签发的证书直接部署到引用它的目标
+		for _, targetConfig := range cfg.Targets {
+			if targetConfig.Cert != cert.Name {
+				continue
+			}
+			if !reportResult(runTarget(ctx, targetConfig, cert)) {
+				failed++
+			}
+			utils.Println("")
+		}
+	}
+
+	if failed > 0 {
+		return exitFailure
+	}
+	return 0
+}
@@ -0,0 +1,65 @@
+package issuer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/utils"
+)
+
+func init() {
+	RegisterDNSProvider("challtestsrv", newChallTestSrv)
+}
+
+// Pebble 配套的 pebble-challtestsrv，用于在本地完整测试 DNS-01 签发流程
+type challTestSrv struct {
+	url        string
+	httpClient *http.Client
+}
+
+func newChallTestSrv(dnsConfig config.DNSChallenge) (DNSProvider, error) {
+	url := dnsConfig.Url
+	if url == "" {
+		url = "http://localhost:8055"
+	}
+	httpClient, err := utils.NewHTTPClient(utils.TLSOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &challTestSrv{url: strings.TrimSuffix(url, "/"), httpClient: httpClient}, nil
+}
+
+func (c *challTestSrv) Present(ctx context.Context, fqdn string, value string) error {
+	return c.post(ctx, "/set-txt", map[string]string{"host": fqdn, "value": value})
+}
+
+func (c *challTestSrv) CleanUp(ctx context.Context, fqdn string, value string) error {
+	return c.post(ctx, "/clear-txt", map[string]string{"host": fqdn})
+}
+
+func (c *challTestSrv) post(ctx context.Context, path string, body any) error {
+	requestJson, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+path, bytes.NewReader(requestJson))
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("challtestsrv %s 请求异常：%w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(resp.Body)
+		return utils.NewAPIError("challtestsrv", path, resp.StatusCode, "", string(responseBody))
+	}
+	return nil
+}
@@ -0,0 +1,401 @@
+package issuer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 错误信息中使用的服务名称
+const serviceName = "ACME"
+
+// 默认配置
+const (
+	DefaultDirectoryUrl = acme.LetsEncryptURL
+	DefaultAccountKey   = "acme-account.key"
+	DefaultRenewBefore  = 30 * 24 * time.Hour
+)
+
+// 验证方式
+const (
+	ChallengeHTTP01 = "http-01"
+	ChallengeDNS01  = "dns-01"
+)
+
+// 单张证书签发的超时时间
+const issueTimeout = 10 * time.Minute
+
+// ACME 证书签发客户端
+type Issuer struct {
+	client *acme.Client
+}
+
+// 加载账户私钥并注册账户，账户已存在时直接使用
+func New(ctx context.Context, acmeConfig config.Acme) (*Issuer, error) {
+	directoryUrl := acmeConfig.DirectoryUrl
+	if directoryUrl == "" {
+		directoryUrl = DefaultDirectoryUrl
+	}
+	accountKeyPath := acmeConfig.AccountKey
+	if accountKeyPath == "" {
+		accountKeyPath = DefaultAccountKey
+	}
+
+	accountKey, err := loadAccountKey(accountKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	httpClient, err := utils.NewHTTPClient(utils.TLSOptions{CAFile: acmeConfig.CaFile})
+	if err != nil {
+		return nil, fmt.Errorf("ACME 目录地址 TLS 配置异常：%w", err)
+	}
+
+	client := &acme.Client{
+		Key:          accountKey,
+		DirectoryURL: directoryUrl,
+		HTTPClient:   httpClient,
+		UserAgent:    "update_cert",
+	}
+
+	account := &acme.Account{}
+	if acmeConfig.Email != "" {
+		account.Contact = []string{"mailto:" + acmeConfig.Email}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, acmeError("注册账户", err)
+	}
+	return &Issuer{client: client}, nil
+}
+
+// 读取账户私钥，不存在时生成并保存
+func loadAccountKey(path string) (crypto.Signer, error) {
+	keyPEM, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("生成 ACME 账户私钥异常：%w", err)
+		}
+		if err := writePrivateKey(path, key); err != nil {
+			return nil, err
+		}
+		utils.Println("已生成 ACME 账户私钥：", path)
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取 ACME 账户私钥异常：%w", err)
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("ACME 账户私钥 %s 不是 PEM 格式", path)
+	}
+	var key any
+	if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		}
+	}
+	signer, ok := key.(crypto.Signer)
+	if err != nil || !ok {
+		return nil, fmt.Errorf("无法解析 ACME 账户私钥 %s", path)
+	}
+	return signer, nil
+}
+
+// 判断是否需要签发：本地证书不可用、域名变化或剩余有效期不足时签发
+func NeedIssue(cert config.Cert, now time.Time) (bool, string, error) {
+	renewBefore := DefaultRenewBefore
+	if cert.Acme.RenewBefore != "" {
+		var err error
+		renewBefore, err = config.ParseDuration(cert.Acme.RenewBefore)
+		if err != nil {
+			return false, "", fmt.Errorf("证书 %s 的 acme.renew_before %s 不合法", cert.Name, cert.Acme.RenewBefore)
+		}
+	}
+
+	bundle, err := utils.LoadCertBundle(cert.CrtPath, cert.KeyPath)
+	if err != nil {
+		return true, fmt.Sprint("本地证书不可用：", err), nil
+	}
+	if err := bundle.Validate(cert.Acme.Domains, now); err != nil {
+		return true, err.Error(), nil
+	}
+	left := bundle.Leaf.NotAfter.Sub(now)
+	if left < renewBefore {
+		return true, fmt.Sprintf("证书剩余有效期 %s 不足 %s", left.Round(time.Hour), renewBefore), nil
+	}
+	return false, fmt.Sprintf("证书剩余有效期 %s，无需签发", left.Round(time.Hour)), nil
+}
+
+// 签发证书并写入证书配置中的 crt_path 和 key_path
+func (i *Issuer) Obtain(ctx context.Context, cert config.Cert) error {
+	ctx, cancel := context.WithTimeout(ctx, issueTimeout)
+	defer cancel()
+
+	issue := cert.Acme
+	solver, err := newSolver(*issue)
+	if err != nil {
+		return fmt.Errorf("证书 %s：%w", cert.Name, err)
+	}
+	if err := solver.start(); err != nil {
+		return err
+	}
+	defer solver.stop()
+
+	order, err := i.client.AuthorizeOrder(ctx, acme.DomainIDs(issue.Domains...))
+	if err != nil {
+		return acmeError("创建订单", err)
+	}
+	//订单地址只在创建时返回，后续查询订单的返回中没有
+	orderURI := order.URI
+	for _, authzURL := range order.AuthzURLs {
+		if err := i.authorize(ctx, solver, authzURL); err != nil {
+			return err
+		}
+	}
+	order, err = i.client.WaitOrder(ctx, orderURI)
+	if err != nil {
+		return acmeError("等待订单就绪", err)
+	}
+
+	key, err := generateKey(issue.KeyType)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: strings.TrimPrefix(issue.Domains[0], "*.")},
+		DNSNames: issue.Domains,
+	}, key)
+	if err != nil {
+		return fmt.Errorf("生成证书签名请求异常：%w", err)
+	}
+	chain, _, err := i.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		//部分 CA（例如 Pebble）异步签发且不返回订单地址，按原订单地址等待签发完成后下载
+		issued, waitErr := i.client.WaitOrder(ctx, orderURI)
+		if waitErr != nil || issued.Status != acme.StatusValid {
+			return acmeError("签发证书", err)
+		}
+		if chain, err = i.client.FetchCert(ctx, issued.CertURL, true); err != nil {
+			return acmeError("下载证书", err)
+		}
+	}
+
+	var crtPEM []byte
+	for _, der := range chain {
+		crtPEM = append(crtPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		return err
+	}
+	//私钥和证书都写入临时文件后再一起替换，任何一步失败都不会留下不匹配的证书和私钥
+	return writeFiles(
+		pendingFile{path: cert.KeyPath, data: keyPEM, perm: 0600},
+		pendingFile{path: cert.CrtPath, data: crtPEM, perm: 0644},
+	)
+}
+
+// 完成单个域名的验证
+func (i *Issuer) authorize(ctx context.Context, solver solver, authzURL string) error {
+	authz, err := i.client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return acmeError("获取域名授权", err)
+	}
+	if authz.Status == acme.StatusValid {
+		return nil
+	}
+	domain := authz.Identifier.Value
+	if authz.Wildcard {
+		domain = "*." + domain
+	}
+
+	var challenge *acme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == solver.challengeType() {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("%s 不支持 %s 验证", domain, solver.challengeType())
+	}
+
+	utils.Println("开始验证域名：", domain, "（", challenge.Type, "）")
+	if err := solver.present(ctx, i.client, authz.Identifier.Value, challenge); err != nil {
+		return fmt.Errorf("%s 准备 %s 验证异常：%w", domain, challenge.Type, err)
+	}
+	defer func() {
+		if err := solver.cleanUp(context.WithoutCancel(ctx), i.client, authz.Identifier.Value, challenge); err != nil {
+			utils.ErrorLog("清理 ", domain, " 的验证记录失败：", err)
+		}
+	}()
+
+	if _, err := i.client.Accept(ctx, challenge); err != nil {
+		return acmeError("提交验证", err)
+	}
+	if _, err := i.client.WaitAuthorization(ctx, authz.URI); err != nil {
+		return fmt.Errorf("%s 域名验证失败：%w", domain, acmeError("等待验证结果", err))
+	}
+	utils.Println("域名验证通过：", domain)
+	return nil
+}
+
+// 按配置生成证书私钥
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch keyType {
+	case "", "ec256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ec384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "rsa2048":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "rsa4096":
+		return rsa.GenerateKey(rand.Reader, 4096)
+	}
+	return nil, fmt.Errorf("私钥类型 %s 不支持，可选：ec256、ec384、rsa2048、rsa4096", keyType)
+}
+
+// 以 PKCS#8 格式保存私钥，仅当前用户可读
+func writePrivateKey(path string, key crypto.Signer) error {
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, keyPEM, 0600)
+}
+
+// 将私钥编码为 PKCS#8 格式的 PEM
+func encodePrivateKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("编码私钥异常：%w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// 先写入临时文件再改名，避免读取到写了一半的文件
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	return writeFiles(pendingFile{path: path, data: data, perm: perm})
+}
+
+// 待写入的文件
+type pendingFile struct {
+	path string
+	data []byte
+	perm os.FileMode
+}
+
+// 被替换的原文件，用于回滚
+type replacedFile struct {
+	path    string
+	data    []byte
+	perm    os.FileMode
+	existed bool
+}
+
+// 写入一组相互关联的文件：先全部写入同目录的临时文件，都成功后再依次改名替换，
+// 改名失败时恢复已经替换的文件
+func writeFiles(files ...pendingFile) error {
+	var staged []string
+	defer func() {
+		for _, name := range staged {
+			_ = os.Remove(name)
+		}
+	}()
+	for _, file := range files {
+		name, err := stageFile(file)
+		if err != nil {
+			return err
+		}
+		staged = append(staged, name)
+	}
+
+	var replaced []replacedFile
+	for i, file := range files {
+		original := replacedFile{path: file.path}
+		if info, err := os.Stat(file.path); err == nil && info.Mode().IsRegular() {
+			if original.data, err = os.ReadFile(file.path); err != nil {
+				restoreFiles(replaced)
+				return fmt.Errorf("读取 %s 异常：%w", file.path, err)
+			}
+			original.perm, original.existed = info.Mode().Perm(), true
+		}
+		if err := os.Rename(staged[i], file.path); err != nil {
+			restoreFiles(replaced)
+			return fmt.Errorf("写入 %s 异常：%w", file.path, err)
+		}
+		replaced = append(replaced, original)
+	}
+	return nil
+}
+
+// 将文件内容写入同目录的临时文件，返回临时文件路径
+func stageFile(file pendingFile) (string, error) {
+	dir := filepath.Dir(file.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("创建目录异常：%w", err)
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(file.path)+".*")
+	if err != nil {
+		return "", fmt.Errorf("写入 %s 异常：%w", file.path, err)
+	}
+	if _, err := tmp.Write(file.data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("写入 %s 异常：%w", file.path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("写入 %s 异常：%w", file.path, err)
+	}
+	if err := os.Chmod(tmp.Name(), file.perm); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("写入 %s 异常：%w", file.path, err)
+	}
+	return tmp.Name(), nil
+}
+
+// 恢复已经替换的文件，原来不存在的文件直接删除
+func restoreFiles(replaced []replacedFile) {
+	for _, original := range replaced {
+		var err error
+		if original.existed {
+			err = writeFile(original.path, original.data, original.perm)
+		} else {
+			err = os.Remove(original.path)
+		}
+		if err != nil {
+			utils.ErrorLog("恢复 ", original.path, " 失败：", err)
+		}
+	}
+}
+
+// 将 ACME 协议错误转换为通用的接口错误
+func acmeError(operation string, err error) error {
+	var acmeErr *acme.Error
+	if errors.As(err, &acmeErr) {
+		apiError := utils.NewAPIError(serviceName, operation, acmeErr.StatusCode, acmeErr.ProblemType, acmeErr.Detail)
+		for _, subproblem := range acmeErr.Subproblems {
+			apiError.Message += "；" + subproblem.Detail
+		}
+		return apiError
+	}
+	return fmt.Errorf("%s %s 异常：%w", serviceName, operation, err)
+}
@@ -0,0 +1,123 @@
+package issuer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readString(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// 目录中只剩下指定的文件，没有残留的临时文件
+func assertFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(names) {
+		var found []string
+		for _, entry := range entries {
+			found = append(found, entry.Name())
+		}
+		t.Fatalf("目录中的文件 = %v，期望 %v", found, names)
+	}
+}
+
+func TestWriteFiles(t *testing.T) {
+	dir := t.TempDir()
+	keyPath, crtPath := filepath.Join(dir, "key.pem"), filepath.Join(dir, "cert.pem")
+	if err := writeFiles(
+		pendingFile{path: keyPath, data: []byte("new key"), perm: 0600},
+		pendingFile{path: crtPath, data: []byte("new cert"), perm: 0644},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if readString(t, keyPath) != "new key" || readString(t, crtPath) != "new cert" {
+		t.Fatal("文件内容不正确")
+	}
+	info, _ := os.Stat(keyPath)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("私钥权限 = %v，期望 0600", info.Mode().Perm())
+	}
+	assertFiles(t, dir, "cert.pem", "key.pem")
+}
+
+// 证书无法写入临时文件时私钥不变
+func TestWriteFilesStageFailure(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(keyPath, []byte("old key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// 证书所在目录的位置是一个普通文件，无法创建目录
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := writeFiles(
+		pendingFile{path: keyPath, data: []byte("new key"), perm: 0600},
+		pendingFile{path: filepath.Join(blocker, "cert.pem"), data: []byte("new cert"), perm: 0644},
+	)
+	if err == nil {
+		t.Fatal("证书无法写入时应返回错误")
+	}
+	if got := readString(t, keyPath); got != "old key" {
+		t.Fatalf("私钥 = %q，证书写入失败时不应替换", got)
+	}
+	assertFiles(t, dir, "blocker", "key.pem")
+}
+
+// 证书改名失败时恢复已替换的私钥
+func TestWriteFilesRenameRollback(t *testing.T) {
+	dir := t.TempDir()
+	keyPath, crtPath := filepath.Join(dir, "key.pem"), filepath.Join(dir, "cert.pem")
+	if err := os.WriteFile(keyPath, []byte("old key"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	// 证书路径是非空目录，临时文件可以写入但无法改名
+	if err := os.MkdirAll(filepath.Join(crtPath, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	err := writeFiles(
+		pendingFile{path: keyPath, data: []byte("new key"), perm: 0600},
+		pendingFile{path: crtPath, data: []byte("new cert"), perm: 0644},
+	)
+	if err == nil {
+		t.Fatal("证书无法替换时应返回错误")
+	}
+	if got := readString(t, keyPath); got != "old key" {
+		t.Fatalf("私钥 = %q，应恢复为原来的内容", got)
+	}
+	if info, _ := os.Stat(keyPath); info.Mode().Perm() != 0640 {
+		t.Errorf("私钥权限 = %v，应恢复为 0640", info.Mode().Perm())
+	}
+	assertFiles(t, dir, "cert.pem", "key.pem")
+}
+
+// 原来不存在的文件在回滚时删除
+func TestWriteFilesRollbackRemovesNewFiles(t *testing.T) {
+	dir := t.TempDir()
+	keyPath, crtPath := filepath.Join(dir, "key.pem"), filepath.Join(dir, "cert.pem")
+	if err := os.MkdirAll(filepath.Join(crtPath, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFiles(
+		pendingFile{path: keyPath, data: []byte("new key"), perm: 0600},
+		pendingFile{path: crtPath, data: []byte("new cert"), perm: 0644},
+	); err == nil {
+		t.Fatal("证书无法替换时应返回错误")
+	}
+	if _, err := os.Stat(keyPath); !os.IsNotExist(err) {
+		t.Fatalf("回滚后私钥不应存在：%v", err)
+	}
+}
@@ -0,0 +1,112 @@
+//go:build pebble
+
+package issuer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 使用本地 Pebble 完整测试签发流程，需要先启动 pebble 和 pebble-challtestsrv：
+//
+//	pebble-challtestsrv -defaultIPv4 127.0.0.1 -defaultIPv6 "" -http01 "" -https01 "" -tlsalpn01 "" -doh "" &
+//	PEBBLE_VA_NOSLEEP=1 pebble -config test/config/pebble-config.json -dnsserver 127.0.0.1:8053 &
+//	PEBBLE_CA_FILE=test/certs/pebble.minica.pem go test -tags pebble ./issuer/
+//
+// PEBBLE_DIRECTORY 默认 https://localhost:14000/dir，CHALLTESTSRV_URL 默认 http://localhost:8055，
+// CHALLTESTSRV_DNS 默认 127.0.0.1:8053。
+func pebbleAcme(t *testing.T) config.Acme {
+	t.Helper()
+	caFile := os.Getenv("PEBBLE_CA_FILE")
+	if caFile == "" {
+		t.Skip("未设置 PEBBLE_CA_FILE，跳过 Pebble 签发测试")
+	}
+	return config.Acme{
+		DirectoryUrl: envOrDefault("PEBBLE_DIRECTORY", "https://localhost:14000/dir"),
+		Email:        "ops@example.com",
+		AccountKey:   filepath.Join(t.TempDir(), "account.key"),
+		CaFile:       caFile,
+	}
+}
+
+func envOrDefault(name string, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// 签发证书后检查证书与私钥匹配、覆盖全部域名，再次判断时无需签发
+func obtainAndCheck(t *testing.T, cert config.Cert) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	issuer, err := New(ctx, pebbleAcme(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if need, reason, err := NeedIssue(cert, time.Now()); err != nil || !need {
+		t.Fatalf("签发前应需要签发：%v %s", err, reason)
+	}
+	if err := issuer.Obtain(ctx, cert); err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := utils.LoadCertBundle(cert.CrtPath, cert.KeyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bundle.Validate(cert.Acme.Domains, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if need, reason, err := NeedIssue(cert, time.Now()); err != nil || need {
+		t.Fatalf("签发后不应再签发：%v %s", err, reason)
+	}
+	if info, _ := os.Stat(cert.KeyPath); info.Mode().Perm() != 0600 {
+		t.Errorf("私钥权限 = %v，期望 0600", info.Mode().Perm())
+	}
+}
+
+func TestPebbleHTTP01(t *testing.T) {
+	dir := t.TempDir()
+	obtainAndCheck(t, config.Cert{
+		Name:    "web",
+		CrtPath: filepath.Join(dir, "web", "cert.pem"),
+		KeyPath: filepath.Join(dir, "web", "key.pem"),
+		Acme: &config.Issue{
+			Domains: []string{"web.example.com"},
+			// Pebble 签发的证书有效期只有几天
+			RenewBefore: "24h",
+			KeyType:     "rsa2048",
+			// Pebble 默认访问 5002 端口验证 HTTP-01
+			HTTP: config.HTTPChallenge{Listen: "127.0.0.1:5002"},
+		},
+	})
+}
+
+func TestPebbleDNS01(t *testing.T) {
+	dir := t.TempDir()
+	obtainAndCheck(t, config.Cert{
+		Name:    "wildcard",
+		CrtPath: filepath.Join(dir, "wildcard", "cert.pem"),
+		KeyPath: filepath.Join(dir, "wildcard", "key.pem"),
+		Acme: &config.Issue{
+			Domains:     []string{"*.example.org", "example.org"},
+			RenewBefore: "24h",
+			Challenge:   ChallengeDNS01,
+			DNS: config.DNSChallenge{
+				Provider:           "challtestsrv",
+				Url:                envOrDefault("CHALLTESTSRV_URL", "http://localhost:8055"),
+				PropagationTimeout: "30s",
+				Nameservers:        []string{envOrDefault("CHALLTESTSRV_DNS", "127.0.0.1:8053")},
+			},
+		},
+	})
+}
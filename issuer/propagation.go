@@ -0,0 +1,98 @@
+package issuer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"whoyang.cn/update_cert/utils"
+)
+
+// 默认等待 TXT 记录生效的时间和检查间隔
+const (
+	DefaultPropagationTimeout = 2 * time.Minute
+	propagationInterval       = 5 * time.Second
+)
+
+// 等待全部 DNS 服务器都能查到 TXT 记录，nameservers 为空时查询域名的权威 DNS 服务器
+func waitPropagation(ctx context.Context, fqdn string, value string, nameservers []string, timeout time.Duration) error {
+	if timeout <= 0 {
+		return nil
+	}
+	if len(nameservers) == 0 {
+		nameservers = authoritativeNameservers(ctx, fqdn)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(propagationInterval)
+	defer ticker.Stop()
+	for {
+		pending := pendingNameservers(ctx, fqdn, value, nameservers)
+		if len(pending) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("等待 %s 生效超时，以下 DNS 服务器仍未查到记录：%v", fqdn, pending)
+		case <-ticker.C:
+			utils.Println("等待 TXT 记录生效：", fqdn, pending)
+		}
+	}
+}
+
+// 返回还查不到 TXT 记录的 DNS 服务器，nameservers 为空时使用系统解析
+func pendingNameservers(ctx context.Context, fqdn string, value string, nameservers []string) []string {
+	if len(nameservers) == 0 {
+		if !hasTXT(ctx, net.DefaultResolver, fqdn, value) {
+			return []string{"系统 DNS"}
+		}
+		return nil
+	}
+	var pending []string
+	for _, nameserver := range nameservers {
+		if !hasTXT(ctx, resolverFor(nameserver), fqdn, value) {
+			pending = append(pending, nameserver)
+		}
+	}
+	return pending
+}
+
+func hasTXT(ctx context.Context, resolver *net.Resolver, fqdn string, value string) bool {
+	records, err := resolver.LookupTXT(ctx, fqdn)
+	return err == nil && slices.Contains(records, value)
+}
+
+// 直接向指定 DNS 服务器查询，避免递归解析器的缓存
+func resolverFor(nameserver string) *net.Resolver {
+	if _, _, err := net.SplitHostPort(nameserver); err != nil {
+		nameserver = net.JoinHostPort(nameserver, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialer := net.Dialer{Timeout: 5 * time.Second}
+			return dialer.DialContext(ctx, network, nameserver)
+		},
+	}
+}
+
+// 从记录所在域名逐级向上查找 NS 记录，找不到时返回空，使用系统解析
+func authoritativeNameservers(ctx context.Context, fqdn string) []string {
+	labels := strings.Split(strings.TrimSuffix(fqdn, "."), ".")
+	for i := 1; i < len(labels)-1; i++ {
+		records, err := net.DefaultResolver.LookupNS(ctx, strings.Join(labels[i:], "."))
+		if err != nil || len(records) == 0 {
+			continue
+		}
+		var nameservers []string
+		for _, record := range records {
+			nameservers = append(nameservers, strings.TrimSuffix(record.Host, "."))
+		}
+		return nameservers
+	}
+	return nil
+}
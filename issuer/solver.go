@@ -0,0 +1,218 @@
+package issuer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 完成一种 ACME 验证方式
+type solver interface {
+	challengeType() string
+	// 签发开始前和结束后调用，例如启动和关闭 HTTP 服务
+	start() error
+	stop()
+	present(ctx context.Context, client *acme.Client, domain string, challenge *acme.Challenge) error
+	cleanUp(ctx context.Context, client *acme.Client, domain string, challenge *acme.Challenge) error
+}
+
+// 根据配置创建验证方式
+func newSolver(issue config.Issue) (solver, error) {
+	switch issue.Challenge {
+	case "", ChallengeHTTP01:
+		for _, domain := range issue.Domains {
+			if strings.HasPrefix(domain, "*.") {
+				return nil, fmt.Errorf("通配符域名 %s 只能使用 dns-01 验证", domain)
+			}
+		}
+		listen := issue.HTTP.Listen
+		if listen == "" {
+			listen = ":80"
+		}
+		return &httpSolver{listen: listen, webroot: issue.HTTP.Webroot}, nil
+	case ChallengeDNS01:
+		provider, err := NewDNSProvider(issue.DNS)
+		if err != nil {
+			return nil, err
+		}
+		timeout := DefaultPropagationTimeout
+		if issue.DNS.PropagationTimeout != "" {
+			timeout, err = config.ParseDuration(issue.DNS.PropagationTimeout)
+			if err != nil {
+				return nil, fmt.Errorf("dns.propagation_timeout %s 不合法", issue.DNS.PropagationTimeout)
+			}
+		}
+		return &dnsSolver{provider: provider, timeout: timeout, nameservers: issue.DNS.Nameservers}, nil
+	}
+	return nil, fmt.Errorf("验证方式 %s 不支持，可选：%s、%s", issue.Challenge, ChallengeHTTP01, ChallengeDNS01)
+}
+
+// HTTP-01 验证：独立监听端口，或者向已有 Web 服务的站点根目录写入验证文件
+type httpSolver struct {
+	listen  string
+	webroot string
+
+	server *http.Server
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func (s *httpSolver) challengeType() string {
+	return ChallengeHTTP01
+}
+
+func (s *httpSolver) start() error {
+	if s.webroot != "" {
+		return nil
+	}
+	listener, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return fmt.Errorf("HTTP-01 验证监听 %s 异常：%w", s.listen, err)
+	}
+	s.tokens = make(map[string]string)
+	s.server = &http.Server{Handler: http.HandlerFunc(s.serveHTTP), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			utils.Println("HTTP-01 验证服务异常：", err)
+		}
+	}()
+	return nil
+}
+
+func (s *httpSolver) stop() {
+	if s.server != nil {
+		_ = s.server.Close()
+	}
+}
+
+func (s *httpSolver) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	keyAuth, ok := s.tokens[r.URL.Path]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(keyAuth))
+}
+
+func (s *httpSolver) present(ctx context.Context, client *acme.Client, domain string, challenge *acme.Challenge) error {
+	keyAuth, err := client.HTTP01ChallengeResponse(challenge.Token)
+	if err != nil {
+		return err
+	}
+	path := client.HTTP01ChallengePath(challenge.Token)
+	if s.webroot != "" {
+		return writeFile(filepath.Join(s.webroot, path), []byte(keyAuth), 0644)
+	}
+	s.mu.Lock()
+	s.tokens[path] = keyAuth
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *httpSolver) cleanUp(ctx context.Context, client *acme.Client, domain string, challenge *acme.Challenge) error {
+	path := client.HTTP01ChallengePath(challenge.Token)
+	if s.webroot != "" {
+		return os.Remove(filepath.Join(s.webroot, path))
+	}
+	s.mu.Lock()
+	delete(s.tokens, path)
+	s.mu.Unlock()
+	return nil
+}
+
+// DNS 服务商，新的服务商实现该接口并通过 RegisterDNSProvider 注册即可
+type DNSProvider interface {
+	// 添加 TXT 记录，fqdn 形如 _acme-challenge.example.com.
+	Present(ctx context.Context, fqdn string, value string) error
+	// 删除添加的 TXT 记录
+	CleanUp(ctx context.Context, fqdn string, value string) error
+}
+
+// 根据配置创建 DNS 服务商
+type DNSProviderFactory func(config config.DNSChallenge) (DNSProvider, error)
+
+var dnsProviders = make(map[string]DNSProviderFactory)
+
+// 注册 DNS 服务商，一般在客户端包的 init 中调用
+func RegisterDNSProvider(name string, factory DNSProviderFactory) {
+	if _, ok := dnsProviders[name]; ok {
+		panic("DNS 服务商重复注册：" + name)
+	}
+	dnsProviders[name] = factory
+}
+
+// 创建 DNS 服务商
+func NewDNSProvider(config config.DNSChallenge) (DNSProvider, error) {
+	factory, ok := dnsProviders[config.Provider]
+	if !ok {
+		return nil, fmt.Errorf("DNS 服务商 %s 不支持，可选：%v", config.Provider, DNSProviders())
+	}
+	return factory(config)
+}
+
+// 已注册的 DNS 服务商
+func DNSProviders() []string {
+	var names []string
+	for name := range dnsProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DNS-01 验证：通过 DNS 服务商添加 _acme-challenge TXT 记录，等待记录生效后再提交验证
+type dnsSolver struct {
+	provider    DNSProvider
+	timeout     time.Duration
+	nameservers []string
+}
+
+func (s *dnsSolver) challengeType() string {
+	return ChallengeDNS01
+}
+
+func (s *dnsSolver) start() error {
+	return nil
+}
+
+func (s *dnsSolver) stop() {}
+
+func (s *dnsSolver) present(ctx context.Context, client *acme.Client, domain string, challenge *acme.Challenge) error {
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return err
+	}
+	fqdn := ChallengeFqdn(domain)
+	if err := s.provider.Present(ctx, fqdn, value); err != nil {
+		return err
+	}
+	return waitPropagation(ctx, fqdn, value, s.nameservers, s.timeout)
+}
+
+func (s *dnsSolver) cleanUp(ctx context.Context, client *acme.Client, domain string, challenge *acme.Challenge) error {
+	value, err := client.DNS01ChallengeRecord(challenge.Token)
+	if err != nil {
+		return err
+	}
+	return s.provider.CleanUp(ctx, ChallengeFqdn(domain), value)
+}
+
+// 域名对应的验证记录，通配符域名与主域名使用同一条记录
+func ChallengeFqdn(domain string) string {
+	return "_acme-challenge." + strings.TrimSuffix(strings.TrimPrefix(domain, "*."), ".") + "."
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	remoteNotAfter = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "update_cert_remote_cert_not_after_seconds",
+		Help: "远端当前证书的过期时间（Unix 秒）",
+	}, []string{"target"})
+	localNotAfter = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "update_cert_local_cert_not_after_seconds",
+		Help: "本地证书的过期时间（Unix 秒）",
+	}, []string{"target"})
+	servedNotAfter = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "update_cert_served_cert_not_after_seconds",
+		Help: "探测到的对外提供的证书过期时间（Unix 秒）",
+	}, []string{"target", "endpoint"})
+	lastSync = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "update_cert_last_sync_timestamp_seconds",
+		Help: "最近一次检查同步的时间（Unix 秒）",
+	}, []string{"target"})
+	lastSyncSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "update_cert_last_sync_success",
+		Help: "最近一次检查同步是否成功，无需更新也视为成功",
+	}, []string{"target"})
+	apiCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "update_cert_api_calls_total",
+		Help: "按部署目标和服务统计的接口调用次数",
+	}, []string{"target", "service"})
+	apiErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "update_cert_api_errors_total",
+		Help: "按部署目标和服务统计的接口调用失败次数",
+	}, []string{"target", "service"})
+)
+
+// 记录远端当前证书的过期时间
+func SetRemoteNotAfter(target string, notAfter time.Time) {
+	remoteNotAfter.WithLabelValues(target).Set(float64(notAfter.Unix()))
+}
+
+// 记录本地证书的过期时间
+func SetLocalNotAfter(target string, notAfter time.Time) {
+	localNotAfter.WithLabelValues(target).Set(float64(notAfter.Unix()))
+}
+
+// 记录探测到的对外提供的证书过期时间
+func SetServedNotAfter(target string, endpoint string, notAfter time.Time) {
+	servedNotAfter.WithLabelValues(target, endpoint).Set(float64(notAfter.Unix()))
+}
+
+// 记录最近一次检查同步的时间和结果
+func SetLastSync(target string, at time.Time, success bool) {
+	lastSync.WithLabelValues(target).Set(float64(at.Unix()))
+	value := 0.0
+	if success {
+		value = 1
+	}
+	lastSyncSuccess.WithLabelValues(target).Set(value)
+}
+
+// 记录一次接口调用，err 不为空时同时记为失败
+func ObserveAPICall(target string, service string, err error) {
+	apiCalls.WithLabelValues(target, service).Inc()
+	if err != nil {
+		apiErrors.WithLabelValues(target, service).Inc()
+	}
+}
+
+// 统计接口调用的 RoundTripper，请求异常或返回 4xx、5xx 时记为失败
+type transport struct {
+	target  string
+	service string
+	next    http.RoundTripper
+}
+
+// 包装 next 统计经过的每个请求，next 为空时使用 http.DefaultTransport
+func Transport(target string, service string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{target: target, service: service, next: next}
+}
+
+func (t *transport) RoundTrip(request *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(request)
+	apiCalls.WithLabelValues(t.target, t.service).Inc()
+	if err != nil || resp.StatusCode >= http.StatusBadRequest {
+		apiErrors.WithLabelValues(t.target, t.service).Inc()
+	}
+	return resp, err
+}
+
+// 就绪状态，常驻模式调度启动后为就绪，退出时取消
+var ready atomic.Bool
+
+func SetReady(value bool) {
+	ready.Store(value)
+}
+
+// 提供 /metrics、/healthz 和 /readyz
+//
+// /healthz 在进程存活时返回 200，/readyz 在配置加载完成、调度已启动时返回 200，否则返回 503。
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	return mux
+}
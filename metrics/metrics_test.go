@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, handler http.Handler, path string) (int, string) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestHealthz(t *testing.T) {
+	if code, body := get(t, Handler(), "/healthz"); code != http.StatusOK || body != "ok\n" {
+		t.Fatalf("/healthz = %d %q", code, body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	defer SetReady(false)
+	handler := Handler()
+
+	SetReady(false)
+	if code, _ := get(t, handler, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("未就绪时 /readyz = %d，期望 503", code)
+	}
+	SetReady(true)
+	if code, body := get(t, handler, "/readyz"); code != http.StatusOK || body != "ok\n" {
+		t.Fatalf("就绪后 /readyz = %d %q", code, body)
+	}
+	SetReady(false)
+	if code, _ := get(t, handler, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("退出时 /readyz = %d，期望 503", code)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	notAfter := time.Unix(1893456000, 0)
+	SetRemoteNotAfter("metrics-waf", notAfter)
+	SetLocalNotAfter("metrics-waf", notAfter.Add(time.Hour))
+	SetServedNotAfter("metrics-waf", "example.com:443", notAfter)
+	SetLastSync("metrics-waf", time.Unix(1700000000, 0), false)
+	ObserveAPICall("metrics-waf", "SafeLine", nil)
+	ObserveAPICall("metrics-waf", "SafeLine", errors.New("连接超时"))
+
+	// 经过 Transport 的请求按状态码统计失败
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: Transport("metrics-oss", "OSS", nil)}
+	for _, path := range []string{"/", "/missing"} {
+		response, err := client.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+	}
+
+	code, body := get(t, Handler(), "/metrics")
+	if code != http.StatusOK {
+		t.Fatalf("/metrics = %d", code)
+	}
+	for _, want := range []string{
+		`update_cert_remote_cert_not_after_seconds{target="metrics-waf"} 1.893456e+09`,
+		`update_cert_local_cert_not_after_seconds{target="metrics-waf"} 1.8934596e+09`,
+		`update_cert_served_cert_not_after_seconds{endpoint="example.com:443",target="metrics-waf"} 1.893456e+09`,
+		`update_cert_last_sync_timestamp_seconds{target="metrics-waf"} 1.7e+09`,
+		`update_cert_last_sync_success{target="metrics-waf"} 0`,
+		`update_cert_api_calls_total{service="SafeLine",target="metrics-waf"} 2`,
+		`update_cert_api_errors_total{service="SafeLine",target="metrics-waf"} 1`,
+		`update_cert_api_calls_total{service="OSS",target="metrics-oss"} 2`,
+		`update_cert_api_errors_total{service="OSS",target="metrics-oss"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("/metrics 缺少 %s", want)
+		}
+	}
+
+	SetLastSync("metrics-waf", time.Unix(1700000000, 0), true)
+	if _, body := get(t, Handler(), "/metrics"); !strings.Contains(body, `update_cert_last_sync_success{target="metrics-waf"} 1`) {
+		t.Error("同步成功后 last_sync_success 应为 1")
+	}
+}
@@ -0,0 +1,138 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+)
+
+func init() {
+	Register("email", newEmail)
+}
+
+// 使用 TLS 连接的 SMTP 端口，其他端口在服务端支持时使用 STARTTLS
+const smtpsPort = 465
+
+// 邮件通知
+type email struct {
+	config config.Email
+	// 是否直接使用 TLS 连接，端口为 465 时开启
+	implicitTLS bool
+	// 校验服务端证书的配置，为空时按 host 使用系统根证书校验
+	tlsConfig *tls.Config
+}
+
+func newEmail(notifierConfig config.Notifier) (Notifier, error) {
+	emailConfig := notifierConfig.Email
+	if emailConfig.Host == "" {
+		return nil, fmt.Errorf("通知渠道 %s 的 email.host 不能为空", notifierConfig.Name)
+	}
+	if len(emailConfig.To) == 0 {
+		return nil, fmt.Errorf("通知渠道 %s 的 email.to 不能为空", notifierConfig.Name)
+	}
+	if emailConfig.Port == 0 {
+		emailConfig.Port = 25
+	}
+	if emailConfig.From == "" {
+		emailConfig.From = emailConfig.Username
+	}
+	if emailConfig.From == "" {
+		return nil, fmt.Errorf("通知渠道 %s 的 email.from 不能为空", notifierConfig.Name)
+	}
+	return &email{config: emailConfig, implicitTLS: emailConfig.Port == smtpsPort}, nil
+}
+
+func (e *email) Send(ctx context.Context, message Message) error {
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	address := net.JoinHostPort(e.config.Host, strconv.Itoa(e.config.Port))
+	tlsConfig := e.tlsConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: e.config.Host}
+	}
+	var conn net.Conn
+	var err error
+	if e.implicitTLS {
+		dialer := &tls.Dialer{Config: tlsConfig}
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	} else {
+		dialer := &net.Dialer{}
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return fmt.Errorf("连接 SMTP 服务器 %s 异常：%w", address, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, e.config.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("SMTP 握手异常：%w", err)
+	}
+	defer client.Close()
+
+	if !e.implicitTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("SMTP STARTTLS 异常：%w", err)
+			}
+		}
+	}
+	if e.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.config.Username, e.config.Password, e.config.Host)); err != nil {
+			return fmt.Errorf("SMTP 认证失败：%w", err)
+		}
+	}
+	if err := client.Mail(e.config.From); err != nil {
+		return fmt.Errorf("SMTP 发件人 %s 不可用：%w", e.config.From, err)
+	}
+	for _, to := range e.config.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("SMTP 收件人 %s 不可用：%w", to, err)
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP 发送邮件内容异常：%w", err)
+	}
+	if _, err := writer.Write(e.build(message)); err != nil {
+		return fmt.Errorf("SMTP 发送邮件内容异常：%w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("SMTP 发送邮件内容异常：%w", err)
+	}
+	return client.Quit()
+}
+
+// 生成邮件内容，标题和正文使用 UTF-8 编码
+func (e *email) build(message Message) []byte {
+	var mail bytes.Buffer
+	mail.WriteString("From: " + e.config.From + "\r\n")
+	mail.WriteString("To: " + strings.Join(e.config.To, ", ") + "\r\n")
+	mail.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", message.Title) + "\r\n")
+	mail.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	mail.WriteString("MIME-Version: 1.0\r\n")
+	mail.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	mail.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	body := base64.StdEncoding.EncodeToString([]byte(message.Text))
+	for len(body) > 76 {
+		mail.WriteString(body[:76] + "\r\n")
+		body = body[76:]
+	}
+	mail.WriteString(body + "\r\n")
+	return mail.Bytes()
+}
@@ -0,0 +1,280 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+)
+
+// 生成 127.0.0.1 的自签名证书
+func newLocalTLS(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+// 最小的 SMTP 服务，支持 STARTTLS、AUTH PLAIN 和 DATA，记录收到的邮件
+type smtpServer struct {
+	listener    net.Listener
+	tlsConfig   *tls.Config
+	implicitTLS bool
+
+	mu       sync.Mutex
+	auth     string
+	from     string
+	to       []string
+	data     string
+	usedTLS  bool
+	received chan struct{}
+}
+
+func newSMTPServer(t *testing.T, implicitTLS bool) (*smtpServer, *x509.CertPool) {
+	t.Helper()
+	cert, pool := newLocalTLS(t)
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+	var listener net.Listener
+	var err error
+	if implicitTLS {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &smtpServer{listener: listener, tlsConfig: tlsConfig, implicitTLS: implicitTLS, received: make(chan struct{}, 1)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server, pool
+}
+
+func (s *smtpServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	usedTLS := s.implicitTLS
+	reader, writer := bufio.NewReader(conn), bufio.NewWriter(conn)
+	reply := func(line string) {
+		_, _ = writer.WriteString(line + "\r\n")
+		_ = writer.Flush()
+	}
+
+	reply("220 127.0.0.1 ESMTP test")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			reply("250-127.0.0.1")
+			if !usedTLS {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN")
+		case "STARTTLS":
+			reply("220 ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, usedTLS = tlsConn, true
+			reader, writer = bufio.NewReader(conn), bufio.NewWriter(conn)
+		case "AUTH":
+			s.mu.Lock()
+			s.auth = strings.TrimPrefix(line, "AUTH PLAIN ")
+			s.mu.Unlock()
+			reply("235 authenticated")
+		case "MAIL":
+			s.mu.Lock()
+			s.from = line
+			s.mu.Unlock()
+			reply("250 ok")
+		case "RCPT":
+			s.mu.Lock()
+			s.to = append(s.to, line)
+			s.mu.Unlock()
+			reply("250 ok")
+		case "DATA":
+			reply("354 end with .")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			s.mu.Lock()
+			s.data, s.usedTLS = data.String(), usedTLS
+			s.mu.Unlock()
+			reply("250 queued")
+			s.received <- struct{}{}
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func testEmailDelivery(t *testing.T, implicitTLS bool) {
+	server, pool := newSMTPServer(t, implicitTLS)
+	emailConfig := config.Email{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		Username: "robot@example.com",
+		Password: "pa$$word",
+		To:       []string{"ops@example.com", "dev@example.com"},
+	}
+	notifier, err := New(config.Notifier{Name: "mail", Type: "email", Email: emailConfig})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mail := notifier.(*email)
+	if mail.implicitTLS {
+		t.Fatal("非 465 端口不应直接使用 TLS")
+	}
+	mail.implicitTLS = implicitTLS
+	mail.tlsConfig = &tls.Config{ServerName: "127.0.0.1", RootCAs: pool}
+
+	message := testMessage()
+	if err := mail.Send(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-server.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SMTP 服务没有收到邮件")
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !server.usedTLS {
+		t.Error("邮件应通过 TLS 发送")
+	}
+	if auth, _ := base64.StdEncoding.DecodeString(server.auth); string(auth) != "\x00robot@example.com\x00pa$$word" {
+		t.Errorf("AUTH PLAIN = %q", auth)
+	}
+	if !strings.HasPrefix(server.from, "MAIL FROM:<robot@example.com>") {
+		t.Errorf("发件人 = %q，未填写 from 时应使用 username", server.from)
+	}
+	if len(server.to) != 2 {
+		t.Errorf("收件人 = %v", server.to)
+	}
+	for _, want := range []string{"From: robot@example.com", "To: ops@example.com, dev@example.com",
+		"Subject: =?UTF-8?b?", "Content-Transfer-Encoding: base64"} {
+		if !strings.Contains(server.data, want) {
+			t.Errorf("邮件缺少 %q：\n%s", want, server.data)
+		}
+	}
+	_, body, _ := strings.Cut(server.data, "\r\n\r\n")
+	text, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(body, "\r\n", ""))
+	if err != nil || string(text) != message.Text {
+		t.Errorf("正文 = %q，%v", text, err)
+	}
+}
+
+func TestEmailImplicitTLS(t *testing.T) {
+	testEmailDelivery(t, true)
+}
+
+func TestEmailStartTLS(t *testing.T) {
+	testEmailDelivery(t, false)
+}
+
+func TestEmailUntrustedServer(t *testing.T) {
+	server, _ := newSMTPServer(t, true)
+	notifier, _ := New(config.Notifier{Name: "mail", Type: "email", Email: config.Email{
+		Host: "127.0.0.1", Port: server.port(), From: "robot@example.com", To: []string{"ops@example.com"},
+	}})
+	mail := notifier.(*email)
+	mail.implicitTLS = true
+	if err := mail.Send(context.Background(), testMessage()); err == nil {
+		t.Fatal("服务端证书不受信任时应返回错误")
+	}
+}
+
+func TestNewEmail(t *testing.T) {
+	tests := []struct {
+		name   string
+		config config.Email
+		want   string
+	}{
+		{"缺少 host", config.Email{To: []string{"a@example.com"}, From: "b@example.com"}, "email.host"},
+		{"缺少 to", config.Email{Host: "smtp.example.com", From: "b@example.com"}, "email.to"},
+		{"缺少 from", config.Email{Host: "smtp.example.com", To: []string{"a@example.com"}}, "email.from"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := New(config.Notifier{Name: "mail", Type: "email", Email: test.config})
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("错误 = %v，期望包含 %q", err, test.want)
+			}
+		})
+	}
+
+	notifier, err := New(config.Notifier{Name: "mail", Type: "email", Email: config.Email{
+		Host: "smtp.example.com", Port: smtpsPort, From: "b@example.com", To: []string{"a@example.com"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !notifier.(*email).implicitTLS {
+		t.Error("465 端口应直接使用 TLS")
+	}
+	if port := notifier.(*email).config.Port; port != smtpsPort {
+		t.Errorf("端口 = %d", port)
+	}
+}
@@ -0,0 +1,384 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 通知事件
+const (
+	EventSuccess = "success"
+	EventSkip    = "skip"
+	EventFailure = "failure"
+	EventExpiry  = "expiry"
+)
+
+var eventNames = map[string]string{
+	EventSuccess: "证书更新成功",
+	EventSkip:    "证书无需更新",
+	EventFailure: "证书更新失败",
+	EventExpiry:  "证书即将过期",
+}
+
+// 未配置 events 时只通知失败和即将过期
+var defaultEvents = []string{EventFailure, EventExpiry}
+
+// 默认通知间隔和即将过期的提醒时间
+const (
+	DefaultRateLimit     = time.Hour
+	DefaultExpiryWarning = 7 * 24 * time.Hour
+)
+
+// 默认消息模板，可以使用 Event 的全部字段
+const DefaultTemplate = `{{event .Kind}}
+部署目标：{{.Target}}（{{.Type}}）
+域名：{{join .Domains ","}}
+颁发机构：{{.Issuer}}
+有效期至：{{date .ValidBefore}}
+{{- if .Reason}}
+原因：{{.Reason}}{{end}}
+{{- if .Error}}
+错误：{{.Error}}{{end}}`
+
+// 模板中可以使用的函数
+var templateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"event": func(kind string) string { return eventNames[kind] },
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return "未知"
+		}
+		return t.Local().Format("2006-01-02 15:04:05")
+	},
+}
+
+// 通知事件的内容
+type Event struct {
+	// success、skip、failure、expiry
+	Kind   string `json:"kind"`
+	Target string `json:"target"`
+	Type   string `json:"type"`
+	// 证书的域名、颁发机构和过期时间：部署成功时为新证书，否则为远端当前证书
+	Domains     []string  `json:"domains"`
+	Issuer      string    `json:"issuer"`
+	ValidBefore time.Time `json:"valid_before"`
+	Serial      string    `json:"serial,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// 通知标题
+func (e Event) Title() string {
+	return eventNames[e.Kind] + "：" + e.Target
+}
+
+// 发送给通知渠道的消息
+type Message struct {
+	Title string
+	// 按模板生成的正文
+	Text  string
+	Event Event
+}
+
+// 通知渠道，新的渠道实现该接口并通过 Register 注册即可
+type Notifier interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// 根据配置创建通知渠道
+type Factory func(config config.Notifier) (Notifier, error)
+
+var factories = make(map[string]Factory)
+
+// 注册通知渠道类型，一般在 init 中调用
+func Register(notifierType string, factory Factory) {
+	if _, ok := factories[notifierType]; ok {
+		panic("通知渠道类型重复注册：" + notifierType)
+	}
+	factories[notifierType] = factory
+}
+
+// 创建通知渠道
+func New(config config.Notifier) (Notifier, error) {
+	factory, ok := factories[config.Type]
+	if !ok {
+		return nil, fmt.Errorf("通知渠道 %s 的类型 %s 不支持，可选：%v", config.Name, config.Type, Types())
+	}
+	return factory(config)
+}
+
+// 已注册的通知渠道类型
+func Types() []string {
+	var types []string
+	for notifierType := range factories {
+		types = append(types, notifierType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// 配置的通知渠道
+type channel struct {
+	name     string
+	notifier Notifier
+	events   map[string]bool
+	template *template.Template
+}
+
+// 按配置向各渠道发送通知，同一渠道、目标、事件在限流间隔内只通知一次
+type Manager struct {
+	mu            sync.Mutex
+	channels      []channel
+	rateLimit     time.Duration
+	expiryWarning time.Duration
+	stateFile     string
+	// 各渠道、目标、事件最近一次发送通知的时间，重新加载配置时保留
+	sent map[string]time.Time
+}
+
+func NewManager() *Manager {
+	return &Manager{
+		rateLimit:     DefaultRateLimit,
+		expiryWarning: DefaultExpiryWarning,
+		sent:          make(map[string]time.Time),
+	}
+}
+
+// 按配置创建通知渠道，配置有误时保留原来的渠道
+func (m *Manager) Configure(notifyConfig config.Notify) error {
+	settings, err := NewSettings(notifyConfig)
+	if err != nil {
+		return err
+	}
+	m.Apply(settings)
+	return nil
+}
+
+// 校验后的通知配置，由 Manager.Apply 生效
+type Settings struct {
+	channels      []channel
+	rateLimit     time.Duration
+	expiryWarning time.Duration
+	stateFile     string
+}
+
+// 校验通知配置并创建各渠道，不影响正在使用的 Manager
+func NewSettings(notifyConfig config.Notify) (*Settings, error) {
+	rateLimit, expiryWarning := DefaultRateLimit, DefaultExpiryWarning
+	var err error
+	if notifyConfig.RateLimit != "" {
+		if rateLimit, err = config.ParseDuration(notifyConfig.RateLimit); err != nil {
+			return nil, fmt.Errorf("通知配置 rate_limit 不合法：%w", err)
+		}
+	}
+	if notifyConfig.ExpiryWarning != "" {
+		if expiryWarning, err = config.ParseDuration(notifyConfig.ExpiryWarning); err != nil {
+			return nil, fmt.Errorf("通知配置 expiry_warning 不合法：%w", err)
+		}
+	}
+
+	var channels []channel
+	for _, notifierConfig := range notifyConfig.Notifiers {
+		notifier, err := New(notifierConfig)
+		if err != nil {
+			return nil, err
+		}
+		events := notifierConfig.Events
+		if len(events) == 0 {
+			events = defaultEvents
+		}
+		channel := channel{name: notifierConfig.Name, notifier: notifier, events: make(map[string]bool)}
+		for _, event := range events {
+			if _, ok := eventNames[event]; !ok {
+				return nil, fmt.Errorf("通知渠道 %s 的事件 %s 不支持，可选：%s、%s、%s、%s", notifierConfig.Name, event,
+					EventSuccess, EventSkip, EventFailure, EventExpiry)
+			}
+			channel.events[event] = true
+		}
+		text := notifierConfig.Template
+		if text == "" {
+			text = DefaultTemplate
+		}
+		if channel.template, err = template.New(notifierConfig.Name).Funcs(templateFuncs).Parse(text); err != nil {
+			return nil, fmt.Errorf("通知渠道 %s 的消息模板不合法：%w", notifierConfig.Name, err)
+		}
+		channels = append(channels, channel)
+	}
+	return &Settings{channels: channels, rateLimit: rateLimit, expiryWarning: expiryWarning, stateFile: notifyConfig.StateFile}, nil
+}
+
+// 使用新的通知配置，保留已发送通知的记录
+func (m *Manager) Apply(settings *Settings) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.channels = settings.channels
+	m.rateLimit = settings.rateLimit
+	m.expiryWarning = settings.expiryWarning
+	if settings.stateFile != m.stateFile {
+		m.stateFile = settings.stateFile
+		m.loadState()
+	}
+}
+
+// 远端证书剩余有效期不足该时长时发送即将过期通知
+func (m *Manager) ExpiryWarning() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.expiryWarning
+}
+
+// 向订阅了该事件的渠道发送通知，限流间隔内已通知过的渠道跳过
+//
+// 只在检查和更新限流记录时持有锁，发送时不持有，避免一个渠道发送缓慢阻塞其他目标的通知。
+// 发送前先占用限流记录，并发的相同通知不会重复发送，发送失败时恢复。
+func (m *Manager) Notify(ctx context.Context, event Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	type pending struct {
+		channel  channel
+		key      string
+		previous time.Time
+		existed  bool
+	}
+	var sends []pending
+	m.mu.Lock()
+	for _, channel := range m.channels {
+		if !channel.events[event.Kind] {
+			continue
+		}
+		key := channel.name + "|" + event.Target + "|" + event.Kind
+		last, ok := m.sent[key]
+		if ok && m.rateLimit > 0 && event.Time.Sub(last) < m.rateLimit {
+			utils.Println("通知渠道", channel.name, "在", m.rateLimit, "内已发送过", event.Title(), "，跳过")
+			continue
+		}
+		m.sent[key] = event.Time
+		sends = append(sends, pending{channel: channel, key: key, previous: last, existed: ok})
+	}
+	m.mu.Unlock()
+	if len(sends) == 0 {
+		return nil
+	}
+
+	errs := make([]error, len(sends))
+	var wg sync.WaitGroup
+	for i, send := range sends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = m.send(ctx, send.channel, event)
+		}()
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sent := false
+	for i, send := range sends {
+		if errs[i] == nil {
+			sent = true
+			continue
+		}
+		// 没有更晚的通知覆盖时恢复原来的记录，下次仍然会发送
+		if m.sent[send.key].Equal(event.Time) {
+			if send.existed {
+				m.sent[send.key] = send.previous
+			} else {
+				delete(m.sent, send.key)
+			}
+		}
+	}
+	if sent {
+		m.saveState()
+	}
+	return errors.Join(errs...)
+}
+
+// 向指定渠道发送测试通知，不检查事件订阅和限流，name 为空时发送到全部渠道
+func (m *Manager) Test(ctx context.Context, name string, event Event) error {
+	m.mu.Lock()
+	channels := m.channels
+	m.mu.Unlock()
+
+	var errs []error
+	found := false
+	for _, channel := range channels {
+		if name != "" && channel.name != name {
+			continue
+		}
+		found = true
+		if err := m.send(ctx, channel, event); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		utils.Println("通知渠道", channel.name, "发送成功")
+	}
+	if !found {
+		return fmt.Errorf("没有匹配的通知渠道：%s", name)
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Manager) send(ctx context.Context, channel channel, event Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	var text bytes.Buffer
+	if err := channel.template.Execute(&text, event); err != nil {
+		return fmt.Errorf("通知渠道 %s 生成消息异常：%w", channel.name, err)
+	}
+	message := Message{Title: event.Title(), Text: text.String(), Event: event}
+	if err := channel.notifier.Send(ctx, message); err != nil {
+		return fmt.Errorf("通知渠道 %s 发送失败：%w", channel.name, err)
+	}
+	return nil
+}
+
+// 读取通知发送时间，文件不存在时忽略
+func (m *Manager) loadState() {
+	if m.stateFile == "" {
+		return
+	}
+	data, err := os.ReadFile(m.stateFile)
+	if err != nil {
+		return
+	}
+	sent := make(map[string]time.Time)
+	if err := json.Unmarshal(data, &sent); err != nil {
+		utils.Println("通知记录文件", m.stateFile, "格式不正确，忽略：", err)
+		return
+	}
+	for key, last := range sent {
+		if last.After(m.sent[key]) {
+			m.sent[key] = last
+		}
+	}
+}
+
+func (m *Manager) saveState() {
+	if m.stateFile == "" {
+		return
+	}
+	data, err := json.Marshal(m.sent)
+	if err == nil {
+		err = os.WriteFile(m.stateFile, data, 0o600)
+	}
+	if err != nil {
+		utils.Println("保存通知记录文件", m.stateFile, "异常：", err)
+	}
+}
@@ -0,0 +1,260 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+)
+
+// 记录收到的消息的通知渠道，按渠道名称保存，fail 为 true 时发送失败
+type recorder struct {
+	mu       sync.Mutex
+	fail     bool
+	messages []Message
+}
+
+func (r *recorder) Send(ctx context.Context, message Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail {
+		return errors.New("发送失败")
+	}
+	r.messages = append(r.messages, message)
+	return nil
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.messages)
+}
+
+var recorders sync.Map
+
+func init() {
+	Register("recorder", func(notifierConfig config.Notifier) (Notifier, error) {
+		r, _ := recorders.LoadOrStore(notifierConfig.Name, &recorder{})
+		return r.(*recorder), nil
+	})
+}
+
+// 创建使用 recorder 渠道的 Manager
+func newTestManager(t *testing.T, notifyConfig config.Notify) (*Manager, *recorder) {
+	t.Helper()
+	name := t.Name()
+	r := &recorder{}
+	recorders.Store(name, r)
+	t.Cleanup(func() { recorders.Delete(name) })
+
+	if len(notifyConfig.Notifiers) == 0 {
+		notifyConfig.Notifiers = []config.Notifier{{Name: name, Type: "recorder"}}
+	}
+	for i := range notifyConfig.Notifiers {
+		notifyConfig.Notifiers[i].Name = name
+		notifyConfig.Notifiers[i].Type = "recorder"
+	}
+	manager := NewManager()
+	if err := manager.Configure(notifyConfig); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	return manager, r
+}
+
+func testEvent(kind string) Event {
+	return Event{
+		Kind:        kind,
+		Target:      "waf",
+		Type:        "safeline",
+		Domains:     []string{"example.com", "*.example.com"},
+		Issuer:      "R3",
+		ValidBefore: time.Date(2030, 1, 2, 3, 4, 5, 0, time.Local),
+		Error:       "连接超时",
+		Time:        time.Now(),
+	}
+}
+
+func TestDefaultTemplate(t *testing.T) {
+	manager, r := newTestManager(t, config.Notify{})
+	if err := manager.Notify(context.Background(), testEvent(EventFailure)); err != nil {
+		t.Fatal(err)
+	}
+	if r.count() != 1 {
+		t.Fatalf("发送次数 = %d，期望 1", r.count())
+	}
+	message := r.messages[0]
+	if message.Title != "证书更新失败：waf" {
+		t.Errorf("标题 = %q", message.Title)
+	}
+	for _, want := range []string{"证书更新失败", "部署目标：waf（safeline）", "域名：example.com,*.example.com",
+		"颁发机构：R3", "有效期至：2030-01-02 03:04:05", "错误：连接超时"} {
+		if !strings.Contains(message.Text, want) {
+			t.Errorf("正文缺少 %q：\n%s", want, message.Text)
+		}
+	}
+	if strings.Contains(message.Text, "原因") {
+		t.Errorf("原因为空时不应输出：\n%s", message.Text)
+	}
+}
+
+func TestCustomTemplate(t *testing.T) {
+	manager, r := newTestManager(t, config.Notify{Notifiers: []config.Notifier{{
+		Events:   []string{EventSuccess},
+		Template: `{{.Target}} {{event .Kind}} {{join .Domains "|"}} {{date .ValidBefore}}`,
+	}}})
+	if err := manager.Notify(context.Background(), testEvent(EventSuccess)); err != nil {
+		t.Fatal(err)
+	}
+	want := "waf 证书更新成功 example.com|*.example.com 2030-01-02 03:04:05"
+	if r.count() != 1 || r.messages[0].Text != want {
+		t.Fatalf("正文 = %v，期望 %q", r.messages, want)
+	}
+}
+
+func TestConfigureErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config config.Notify
+		want   string
+	}{
+		{"rate_limit", config.Notify{RateLimit: "abc"}, "rate_limit"},
+		{"expiry_warning", config.Notify{ExpiryWarning: "abc"}, "expiry_warning"},
+		{"类型", config.Notify{Notifiers: []config.Notifier{{Name: "x", Type: "sms"}}}, "不支持"},
+		{"事件", config.Notify{Notifiers: []config.Notifier{{Name: "x", Type: "recorder", Events: []string{"deploy"}}}}, "事件 deploy"},
+		{"模板", config.Notify{Notifiers: []config.Notifier{{Name: "x", Type: "recorder", Template: "{{.Target"}}}, "消息模板"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := NewManager().Configure(test.config)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("错误 = %v，期望包含 %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	manager, r := newTestManager(t, config.Notify{})
+	// 默认只通知失败和即将过期
+	for _, kind := range []string{EventSuccess, EventSkip, EventFailure, EventExpiry} {
+		if err := manager.Notify(context.Background(), testEvent(kind)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.count() != 2 {
+		t.Fatalf("发送次数 = %d，期望 2", r.count())
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	manager, r := newTestManager(t, config.Notify{RateLimit: "1h"})
+	ctx := context.Background()
+	event := testEvent(EventFailure)
+
+	_ = manager.Notify(ctx, event)
+	event.Time = event.Time.Add(30 * time.Minute)
+	_ = manager.Notify(ctx, event)
+	if r.count() != 1 {
+		t.Fatalf("限流间隔内发送次数 = %d，期望 1", r.count())
+	}
+
+	// 不同目标、不同事件分别限流
+	other := event
+	other.Target = "oss"
+	_ = manager.Notify(ctx, other)
+	other.Kind = EventExpiry
+	_ = manager.Notify(ctx, other)
+	if r.count() != 3 {
+		t.Fatalf("不同目标和事件发送次数 = %d，期望 3", r.count())
+	}
+
+	event.Time = event.Time.Add(31 * time.Minute)
+	_ = manager.Notify(ctx, event)
+	if r.count() != 4 {
+		t.Fatalf("超过限流间隔后发送次数 = %d，期望 4", r.count())
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	manager, r := newTestManager(t, config.Notify{RateLimit: "0"})
+	event := testEvent(EventFailure)
+	for i := 0; i < 3; i++ {
+		_ = manager.Notify(context.Background(), event)
+	}
+	if r.count() != 3 {
+		t.Fatalf("发送次数 = %d，期望 3", r.count())
+	}
+}
+
+func TestFailedSendNotRateLimited(t *testing.T) {
+	manager, r := newTestManager(t, config.Notify{})
+	r.fail = true
+	event := testEvent(EventFailure)
+	if err := manager.Notify(context.Background(), event); err == nil {
+		t.Fatal("发送失败时应返回错误")
+	}
+	r.fail = false
+	if err := manager.Notify(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if r.count() != 1 {
+		t.Fatalf("失败后重试发送次数 = %d，期望 1", r.count())
+	}
+}
+
+func TestStateFile(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "notify.json")
+	event := testEvent(EventFailure)
+
+	manager, r := newTestManager(t, config.Notify{StateFile: stateFile})
+	_ = manager.Notify(context.Background(), event)
+	if r.count() != 1 {
+		t.Fatalf("发送次数 = %d，期望 1", r.count())
+	}
+
+	// 新进程读取记录文件，限流间隔内不再发送
+	next, r2 := newTestManager(t, config.Notify{StateFile: stateFile})
+	event.Time = event.Time.Add(time.Minute)
+	_ = next.Notify(context.Background(), event)
+	if r2.count() != 0 {
+		t.Fatalf("读取记录文件后发送次数 = %d，期望 0", r2.count())
+	}
+}
+
+func TestConcurrentNotifyNotDuplicated(t *testing.T) {
+	manager, r := newTestManager(t, config.Notify{})
+	event := testEvent(EventFailure)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = manager.Notify(context.Background(), event)
+		}()
+	}
+	wg.Wait()
+	if r.count() != 1 {
+		t.Fatalf("并发发送次数 = %d，期望 1", r.count())
+	}
+}
+
+func TestTestIgnoresRateLimit(t *testing.T) {
+	manager, r := newTestManager(t, config.Notify{})
+	event := testEvent(EventSkip)
+	for i := 0; i < 2; i++ {
+		if err := manager.Test(context.Background(), "", event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.count() != 2 {
+		t.Fatalf("发送次数 = %d，期望 2", r.count())
+	}
+	if err := manager.Test(context.Background(), "missing", event); err == nil {
+		t.Fatal("渠道不存在时应返回错误")
+	}
+}
@@ -0,0 +1,240 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/utils"
+)
+
+func init() {
+	Register("dingtalk", newDingTalk)
+	Register("wecom", newWeCom)
+	Register("feishu", newFeishu)
+	Register("webhook", newWebhook)
+}
+
+// 发送通知的超时时间
+const sendTimeout = 10 * time.Second
+
+func newHTTPClient() (*http.Client, error) {
+	httpClient, err := utils.NewHTTPClient(utils.TLSOptions{})
+	if err != nil {
+		return nil, err
+	}
+	httpClient.Timeout = sendTimeout
+	return httpClient, nil
+}
+
+// 以 JSON 格式提交，返回状态码不是 200 时返回错误，result 不为空时解析返回体
+func postJSON(ctx context.Context, httpClient *http.Client, service string, requestUrl string, headers map[string]string, body any, result any) error {
+	requestJson, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("%s 请求体序列化异常：%w", service, err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, requestUrl, bytes.NewReader(requestJson))
+	if err != nil {
+		return fmt.Errorf("%s 创建请求异常：%w", service, err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+
+	resp, err := httpClient.Do(request)
+	if err != nil {
+		return fmt.Errorf("%s 请求异常：%w", service, err)
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s 读取返回体异常：%w", service, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return utils.NewAPIError(service, "POST", resp.StatusCode, "", string(responseBody))
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(responseBody, result); err != nil {
+		return fmt.Errorf("%s 返回体解析异常：%w", service, err)
+	}
+	return nil
+}
+
+// 机器人地址不能为空
+func requireUrl(notifierConfig config.Notifier) error {
+	if notifierConfig.Url == "" {
+		return fmt.Errorf("通知渠道 %s 的 url 不能为空", notifierConfig.Name)
+	}
+	return nil
+}
+
+// HMAC-SHA256 签名后 Base64 编码
+func hmacSign(key string, message string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(message))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// 钉钉群机器人，开启加签时填写 secret
+type dingTalk struct {
+	url        string
+	secret     string
+	httpClient *http.Client
+}
+
+func newDingTalk(notifierConfig config.Notifier) (Notifier, error) {
+	if err := requireUrl(notifierConfig); err != nil {
+		return nil, err
+	}
+	httpClient, err := newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	return &dingTalk{url: notifierConfig.Url, secret: notifierConfig.Secret, httpClient: httpClient}, nil
+}
+
+func (d *dingTalk) Send(ctx context.Context, message Message) error {
+	requestUrl := d.url
+	if d.secret != "" {
+		//签名为 timestamp + "\n" + secret 的 HMAC-SHA256，timestamp 为毫秒时间戳
+		timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		sign := hmacSign(d.secret, timestamp+"\n"+d.secret)
+		separator := "?"
+		if strings.Contains(requestUrl, "?") {
+			separator = "&"
+		}
+		requestUrl += separator + "timestamp=" + timestamp + "&sign=" + url.QueryEscape(sign)
+	}
+	result := struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}{}
+	err := postJSON(ctx, d.httpClient, "钉钉", requestUrl, nil, map[string]any{
+		"msgtype": "text",
+		"text":    map[string]string{"content": message.Text},
+	}, &result)
+	if err != nil {
+		return err
+	}
+	if result.ErrCode != 0 {
+		return utils.NewAPIError("钉钉", "POST", http.StatusOK, strconv.Itoa(result.ErrCode), result.ErrMsg)
+	}
+	return nil
+}
+
+// 企业微信群机器人
+type weCom struct {
+	url        string
+	httpClient *http.Client
+}
+
+func newWeCom(notifierConfig config.Notifier) (Notifier, error) {
+	if err := requireUrl(notifierConfig); err != nil {
+		return nil, err
+	}
+	httpClient, err := newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	return &weCom{url: notifierConfig.Url, httpClient: httpClient}, nil
+}
+
+func (w *weCom) Send(ctx context.Context, message Message) error {
+	result := struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}{}
+	err := postJSON(ctx, w.httpClient, "企业微信", w.url, nil, map[string]any{
+		"msgtype": "text",
+		"text":    map[string]string{"content": message.Text},
+	}, &result)
+	if err != nil {
+		return err
+	}
+	if result.ErrCode != 0 {
+		return utils.NewAPIError("企业微信", "POST", http.StatusOK, strconv.Itoa(result.ErrCode), result.ErrMsg)
+	}
+	return nil
+}
+
+// 飞书群机器人，开启签名校验时填写 secret
+type feishu struct {
+	url        string
+	secret     string
+	httpClient *http.Client
+}
+
+func newFeishu(notifierConfig config.Notifier) (Notifier, error) {
+	if err := requireUrl(notifierConfig); err != nil {
+		return nil, err
+	}
+	httpClient, err := newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	return &feishu{url: notifierConfig.Url, secret: notifierConfig.Secret, httpClient: httpClient}, nil
+}
+
+func (f *feishu) Send(ctx context.Context, message Message) error {
+	body := map[string]any{
+		"msg_type": "text",
+		"content":  map[string]string{"text": message.Text},
+	}
+	if f.secret != "" {
+		//以 timestamp + "\n" + secret 为密钥对空字符串签名，timestamp 为秒级时间戳
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		body["timestamp"] = timestamp
+		body["sign"] = hmacSign(timestamp+"\n"+f.secret, "")
+	}
+	result := struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}{}
+	if err := postJSON(ctx, f.httpClient, "飞书", f.url, nil, body, &result); err != nil {
+		return err
+	}
+	if result.Code != 0 {
+		return utils.NewAPIError("飞书", "POST", http.StatusOK, strconv.Itoa(result.Code), result.Msg)
+	}
+	return nil
+}
+
+// 通用 webhook，提交标题、正文和事件的全部字段
+type webhook struct {
+	url        string
+	headers    map[string]string
+	httpClient *http.Client
+}
+
+func newWebhook(notifierConfig config.Notifier) (Notifier, error) {
+	if err := requireUrl(notifierConfig); err != nil {
+		return nil, err
+	}
+	httpClient, err := newHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	return &webhook{url: notifierConfig.Url, headers: notifierConfig.Headers, httpClient: httpClient}, nil
+}
+
+func (w *webhook) Send(ctx context.Context, message Message) error {
+	return postJSON(ctx, w.httpClient, "webhook", w.url, w.headers, struct {
+		Title string `json:"title"`
+		Text  string `json:"text"`
+		Event
+	}{message.Title, message.Text, message.Event}, nil)
+}
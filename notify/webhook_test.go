@@ -0,0 +1,211 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+)
+
+// 收到的请求
+type capturedRequest struct {
+	query  map[string]string
+	header http.Header
+	body   map[string]any
+}
+
+// 模拟机器人接口，记录请求并返回 response
+func newBotServer(t *testing.T, response string) (*httptest.Server, <-chan capturedRequest) {
+	t.Helper()
+	requests := make(chan capturedRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		request := capturedRequest{query: map[string]string{}, header: r.Header.Clone()}
+		for key := range r.URL.Query() {
+			request.query[key] = r.URL.Query().Get(key)
+		}
+		if err := json.Unmarshal(data, &request.body); err != nil {
+			t.Errorf("请求体不是 JSON：%s", data)
+		}
+		requests <- request
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, response)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func testMessage() Message {
+	event := testEvent(EventFailure)
+	return Message{Title: event.Title(), Text: "证书更新失败\n部署目标：waf", Event: event}
+}
+
+// 签名时间戳与当前时间相差不超过一分钟
+func checkTimestamp(t *testing.T, timestamp string, unit time.Duration) {
+	t.Helper()
+	value, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		t.Fatalf("timestamp %q 不合法", timestamp)
+	}
+	if diff := time.Since(time.Unix(0, value*int64(unit))); diff < -time.Minute || diff > time.Minute {
+		t.Fatalf("timestamp %s 与当前时间相差 %s", timestamp, diff)
+	}
+}
+
+func TestDingTalk(t *testing.T) {
+	server, requests := newBotServer(t, `{"errcode":0,"errmsg":"ok"}`)
+	notifier, err := New(config.Notifier{Name: "ding", Type: "dingtalk", Url: server.URL + "/robot/send?access_token=abc", Secret: "SEC123"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Send(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	request := <-requests
+	if request.query["access_token"] != "abc" {
+		t.Errorf("access_token = %q，原有参数应保留", request.query["access_token"])
+	}
+	timestamp := request.query["timestamp"]
+	checkTimestamp(t, timestamp, time.Millisecond)
+	if want := hmacSign("SEC123", timestamp+"\nSEC123"); request.query["sign"] != want {
+		t.Errorf("sign = %q，期望 %q", request.query["sign"], want)
+	}
+	if request.body["msgtype"] != "text" {
+		t.Errorf("msgtype = %v", request.body["msgtype"])
+	}
+	if text := request.body["text"].(map[string]any)["content"]; text != testMessage().Text {
+		t.Errorf("content = %v", text)
+	}
+}
+
+func TestDingTalkWithoutSecret(t *testing.T) {
+	server, requests := newBotServer(t, `{"errcode":0}`)
+	notifier, _ := New(config.Notifier{Name: "ding", Type: "dingtalk", Url: server.URL})
+	if err := notifier.Send(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	if request := <-requests; request.query["sign"] != "" || request.query["timestamp"] != "" {
+		t.Errorf("未配置 secret 时不应签名：%v", request.query)
+	}
+}
+
+func TestDingTalkError(t *testing.T) {
+	server, _ := newBotServer(t, `{"errcode":310000,"errmsg":"sign not match"}`)
+	notifier, _ := New(config.Notifier{Name: "ding", Type: "dingtalk", Url: server.URL, Secret: "x"})
+	err := notifier.Send(context.Background(), testMessage())
+	if err == nil || !strings.Contains(err.Error(), "sign not match") {
+		t.Fatalf("错误 = %v，期望包含返回的 errmsg", err)
+	}
+}
+
+func TestWeCom(t *testing.T) {
+	server, requests := newBotServer(t, `{"errcode":0,"errmsg":"ok"}`)
+	notifier, _ := New(config.Notifier{Name: "wecom", Type: "wecom", Url: server.URL})
+	if err := notifier.Send(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	request := <-requests
+	if request.body["msgtype"] != "text" {
+		t.Errorf("msgtype = %v", request.body["msgtype"])
+	}
+	if text := request.body["text"].(map[string]any)["content"]; text != testMessage().Text {
+		t.Errorf("content = %v", text)
+	}
+}
+
+func TestWeComError(t *testing.T) {
+	server, _ := newBotServer(t, `{"errcode":93000,"errmsg":"invalid webhook url"}`)
+	notifier, _ := New(config.Notifier{Name: "wecom", Type: "wecom", Url: server.URL})
+	if err := notifier.Send(context.Background(), testMessage()); err == nil {
+		t.Fatal("errcode 不为 0 时应返回错误")
+	}
+}
+
+func TestFeishu(t *testing.T) {
+	server, requests := newBotServer(t, `{"code":0,"msg":"success"}`)
+	notifier, _ := New(config.Notifier{Name: "feishu", Type: "feishu", Url: server.URL, Secret: "FS"})
+	if err := notifier.Send(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	request := <-requests
+	timestamp, _ := request.body["timestamp"].(string)
+	checkTimestamp(t, timestamp, time.Second)
+	if want := hmacSign(timestamp+"\nFS", ""); request.body["sign"] != want {
+		t.Errorf("sign = %v，期望 %q", request.body["sign"], want)
+	}
+	if request.body["msg_type"] != "text" {
+		t.Errorf("msg_type = %v", request.body["msg_type"])
+	}
+	if text := request.body["content"].(map[string]any)["text"]; text != testMessage().Text {
+		t.Errorf("text = %v", text)
+	}
+}
+
+func TestFeishuError(t *testing.T) {
+	server, _ := newBotServer(t, `{"code":19021,"msg":"sign match fail"}`)
+	notifier, _ := New(config.Notifier{Name: "feishu", Type: "feishu", Url: server.URL, Secret: "FS"})
+	if err := notifier.Send(context.Background(), testMessage()); err == nil {
+		t.Fatal("code 不为 0 时应返回错误")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	server, requests := newBotServer(t, `{}`)
+	notifier, _ := New(config.Notifier{Name: "hook", Type: "webhook", Url: server.URL,
+		Headers: map[string]string{"Authorization": "Bearer token"}})
+	if err := notifier.Send(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+	request := <-requests
+	if got := request.header.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if got := request.header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	want := map[string]any{
+		"title":  "证书更新失败：waf",
+		"text":   testMessage().Text,
+		"kind":   EventFailure,
+		"target": "waf",
+		"type":   "safeline",
+		"issuer": "R3",
+		"error":  "连接超时",
+	}
+	for key, value := range want {
+		if request.body[key] != value {
+			t.Errorf("%s = %v，期望 %v", key, request.body[key], value)
+		}
+	}
+	if domains, _ := request.body["domains"].([]any); len(domains) != 2 {
+		t.Errorf("domains = %v", request.body["domains"])
+	}
+}
+
+func TestWebhookStatusError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusBadGateway)
+	}))
+	defer server.Close()
+	notifier, _ := New(config.Notifier{Name: "hook", Type: "webhook", Url: server.URL})
+	err := notifier.Send(context.Background(), testMessage())
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Fatalf("错误 = %v，期望包含状态码", err)
+	}
+}
+
+func TestRequireUrl(t *testing.T) {
+	for _, notifierType := range []string{"dingtalk", "wecom", "feishu", "webhook"} {
+		if _, err := New(config.Notifier{Name: "x", Type: notifierType}); err == nil {
+			t.Errorf("%s 未填写 url 时应返回错误", notifierType)
+		}
+	}
+}
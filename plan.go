@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/target"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 默认的部署计划文件
+const defaultPlanPath = "plan.json"
+
+// plan 保存、apply 读取的部署计划
+type planFile struct {
+	CreatedAt time.Time `json:"created_at"`
+	// 生成计划时是否使用了 -force，apply 时按相同的策略重新判断
+	Force   bool         `json:"force"`
+	Targets []targetPlan `json:"targets"`
+}
+
+// 单个目标的部署计划
+type targetPlan struct {
+	Target string `json:"target"`
+	Type   string `json:"type"`
+	Cert   string `json:"cert"`
+	Renew  bool   `json:"renew"`
+	Reason string `json:"reason"`
+	// 生成计划时的远端证书和本地证书，apply 时不一致说明状态已变化
+	CurrentId          string        `json:"current_id,omitempty"`
+	CurrentFingerprint string        `json:"current_fingerprint,omitempty"`
+	LocalFingerprint   string        `json:"local_fingerprint,omitempty"`
+	Steps              []target.Step `json:"steps,omitempty"`
+	// 无法生成计划时的错误
+	Error string `json:"error,omitempty"`
+}
+
+// 生成部署计划并输出，planPath 不为空时保存供 apply 执行
+func runPlan(configPath string, selector string, planPath string, force bool) int {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		utils.Println(err)
+		return exitConfigError
+	}
+	if force {
+		cfg.Force()
+	}
+	selector = defaultSelector(cfg, selector)
+	targets := cfg.SelectTargets(selector)
+	if len(targets) == 0 {
+		utils.Println("没有匹配的部署目标：", selector)
+		return exitConfigError
+	}
+
+	plan := planFile{CreatedAt: time.Now(), Force: force}
+	failed := 0
+	ctx := context.Background()
+	for _, targetConfig := range targets {
+		cert, _ := cfg.FindCert(targetConfig.Cert)
+		targetPlan := planTarget(ctx, targetConfig, cert)
+		if targetPlan.Error != "" {
+			failed++
+		}
+		plan.Targets = append(plan.Targets, targetPlan)
+		utils.Println("")
+	}
+	printPlan(plan)
+	if outputFormat == outputJson {
+		encoder := json.NewEncoder(reportOut)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(plan); err != nil {
+			utils.ErrorLog("输出部署计划异常：", err)
+		}
+	}
+
+	if planPath != "" {
+		if err := savePlan(planPath, plan); err != nil {
+			utils.Println(err)
+			return exitFailure
+		}
+		utils.Println("部署计划已保存到", planPath, "，执行 apply 按计划部署")
+	}
+	if failed > 0 {
+		return exitFailure
+	}
+	return 0
+}
+
+// 获取远端证书并生成单个目标的部署计划，不修改远端
+func planTarget(ctx context.Context, targetConfig config.Target, cert config.Cert) targetPlan {
+	plan := targetPlan{Target: targetConfig.Name, Type: targetConfig.Type, Cert: targetConfig.Cert}
+	prepared, err := prepareTarget(ctx, targetConfig, cert)
+	if err != nil {
+		plan.Error = err.Error()
+		return plan
+	}
+	plan.Renew = prepared.renew
+	plan.Reason = prepared.reason
+	plan.LocalFingerprint = utils.CertFingerprint(prepared.local.Bundle.Leaf)
+	if prepared.current != nil {
+		plan.CurrentId = prepared.current.Id
+		plan.CurrentFingerprint = prepared.current.Fingerprint
+	}
+	if !prepared.renew {
+		plan.Steps = []target.Step{{Action: target.StepSkip, Detail: prepared.reason}}
+		return plan
+	}
+	plan.Steps, err = target.PlanSteps(ctx, prepared.target, prepared.current, prepared.local)
+	if err != nil {
+		plan.Error = err.Error()
+	}
+	return plan
+}
+
+// 输出各目标的部署计划
+func printPlan(plan planFile) {
+	utils.Println("====================================")
+	utils.Println("部署计划")
+	utils.Println("====================================")
+	renew := 0
+	for _, targetPlan := range plan.Targets {
+		utils.Printf("%s（%s）：\n", targetPlan.Target, targetPlan.Type)
+		if targetPlan.Error != "" {
+			utils.Println("  无法生成计划：", targetPlan.Error)
+			continue
+		}
+		if targetPlan.Renew {
+			renew++
+			utils.Println("  原因：", targetPlan.Reason)
+		}
+		for _, step := range targetPlan.Steps {
+			utils.Println("  -", step)
+		}
+	}
+	utils.Printf("共 %d 个目标，%d 个需要部署\n", len(plan.Targets), renew)
+}
+
+func savePlan(planPath string, plan planFile) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("保存部署计划异常：%w", err)
+	}
+	if err := os.WriteFile(planPath, data, 0o600); err != nil {
+		return fmt.Errorf("保存部署计划异常：%w", err)
+	}
+	return nil
+}
+
+func loadPlan(planPath string) (planFile, error) {
+	var plan planFile
+	data, err := os.ReadFile(planPath)
+	if err != nil {
+		return plan, fmt.Errorf("读取部署计划异常，请先执行 plan：%w", err)
+	}
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return plan, fmt.Errorf("部署计划 %s 格式不正确：%w", planPath, err)
+	}
+	return plan, nil
+}
+
+// 按部署计划执行：只部署计划中需要部署的目标，远端或本地证书与计划时不一致的目标不执行
+func runApply(configPath string, planPath string) int {
+	plan, err := loadPlan(planPath)
+	if err != nil {
+		utils.Println(err)
+		return exitConfigError
+	}
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		utils.Println(err)
+		return exitConfigError
+	}
+	if plan.Force {
+		cfg.Force()
+	}
+	utils.Println("执行部署计划", planPath, "（生成于", plan.CreatedAt.Local().Format("2006-01-02 15:04:05"), "）")
+
+	ctx := context.Background()
+	reports := make([]targetReport, 0, len(plan.Targets))
+	for _, targetPlan := range plan.Targets {
+		// 生成计划时就失败的目标无法执行，按失败处理
+		if targetPlan.Error != "" {
+			err := fmt.Errorf("生成计划时出错，请重新执行 plan：%s", targetPlan.Error)
+			report := targetReport{Target: targetPlan.Target, Type: targetPlan.Type,
+				Action: actionFailed, Error: err.Error(), err: err}
+			reportResult(report)
+			reports = append(reports, report)
+			utils.Println("")
+			continue
+		}
+		if !targetPlan.Renew {
+			utils.Println(targetPlan.Target, "计划中无需部署，跳过")
+			reports = append(reports, targetReport{Target: targetPlan.Target, Type: targetPlan.Type,
+				Action: actionSkipped, Reason: targetPlan.Reason})
+			continue
+		}
+		report := applyTarget(ctx, cfg, targetPlan)
+		reportResult(report)
+		reports = append(reports, report)
+		utils.Println("")
+	}
+	return finishReports(reportOut, reports)
+}
+
+// 重新获取远端证书并生成部署步骤，确认与计划一致后部署
+func applyTarget(ctx context.Context, cfg *config.Config, plan targetPlan) targetReport {
+	targetConfig := config.Target{Name: plan.Target, Type: plan.Type}
+	found := false
+	for _, candidate := range cfg.Targets {
+		if candidate.Name == plan.Target {
+			targetConfig, found = candidate, true
+		}
+	}
+	return executeTarget(ctx, targetConfig, func() (*preparedTarget, error) {
+		if !found {
+			return nil, fmt.Errorf("配置中没有部署目标 %s", plan.Target)
+		}
+		cert, _ := cfg.FindCert(targetConfig.Cert)
+		prepared, err := prepareTarget(ctx, targetConfig, cert)
+		if err != nil {
+			return nil, err
+		}
+
+		if fingerprint := utils.CertFingerprint(prepared.local.Bundle.Leaf); fingerprint != plan.LocalFingerprint {
+			return nil, fmt.Errorf("本地证书 %s 在生成计划后已变化，请重新执行 plan", prepared.local.Name)
+		}
+		currentId, currentFingerprint := "", ""
+		if prepared.current != nil {
+			currentId, currentFingerprint = prepared.current.Id, prepared.current.Fingerprint
+		}
+		if currentId != plan.CurrentId || currentFingerprint != plan.CurrentFingerprint || !prepared.renew {
+			return nil, fmt.Errorf("远端证书在生成计划后已变化（计划时 %s，当前 %s），请重新执行 plan",
+				displayCertId(plan.CurrentId), displayCertId(currentId))
+		}
+		// 证书一致但部署步骤变化时（例如 CAS 中已有的证书被删除）同样不执行
+		steps, err := target.PlanSteps(ctx, prepared.target, prepared.current, prepared.local)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Equal(steps, plan.Steps) {
+			return nil, fmt.Errorf("部署步骤在生成计划后已变化（计划时 %v，当前 %v），请重新执行 plan", plan.Steps, steps)
+		}
+		return prepared, nil
+	})
+}
+
+// 日志中展示的远端证书 ID
+func displayCertId(certId string) string {
+	if certId == "" {
+		return "未绑定"
+	}
+	return certId
+}
+
+// 未指定目标时，使用 .env 默认更新雷池，使用配置文件默认更新全部目标
+func defaultSelector(cfg *config.Config, selector string) string {
+	if selector != "" {
+		return selector
+	}
+	if cfg.Legacy {
+		return config.TypeSafeline
+	}
+	return "all"
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/target"
+)
+
+// 写入包含两个目标的配置文件
+func writeTestConfig(t *testing.T) string {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	content := `certs:
+  - name: example
+    crt_path: /nonexistent/cert.pem
+    key_path: /nonexistent/key.pem
+targets:
+  - name: waf
+    type: safeline
+    cert: example
+  - name: oss
+    type: aliyun-oss
+    cert: example
+`
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return configPath
+}
+
+func TestRunApplyPlanError(t *testing.T) {
+	configPath := writeTestConfig(t)
+	tests := []struct {
+		name    string
+		targets []targetPlan
+		want    int
+	}{
+		{"全部无法生成计划", []targetPlan{
+			{Target: "waf", Type: "safeline", Error: "连接超时"},
+		}, exitFailure},
+		{"部分无法生成计划", []targetPlan{
+			{Target: "waf", Type: "safeline", Error: "连接超时"},
+			{Target: "oss", Type: "aliyun-oss", Reason: "证书仍然有效"},
+		}, exitPartialFailure},
+		{"全部无需部署", []targetPlan{
+			{Target: "oss", Type: "aliyun-oss", Reason: "证书仍然有效"},
+		}, exitNothingToDo},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			planPath := filepath.Join(t.TempDir(), "plan.json")
+			if err := savePlan(planPath, planFile{CreatedAt: time.Now(), Targets: test.targets}); err != nil {
+				t.Fatal(err)
+			}
+			if code := runApply(configPath, planPath); code != test.want {
+				t.Fatalf("退出码 = %d，期望 %d", code, test.want)
+			}
+		})
+	}
+}
+
+// 部署步骤可以在测试中修改的目标
+type planTestTarget struct{}
+
+var (
+	planTestSteps    []target.Step
+	planTestDeployed bool
+)
+
+func init() {
+	target.Register("plan-test", func(config.Target) (target.Target, error) {
+		return planTestTarget{}, nil
+	})
+}
+
+func (planTestTarget) Name() string      { return "plan-test" }
+func (planTestTarget) Domains() []string { return []string{"example.com"} }
+func (planTestTarget) Describe(ctx context.Context) (*target.RemoteCert, error) {
+	return nil, nil
+}
+func (planTestTarget) NeedRenew(ctx context.Context, current *target.RemoteCert, local target.Cert) (bool, string, error) {
+	return true, "未绑定证书", nil
+}
+func (planTestTarget) Plan(ctx context.Context, current *target.RemoteCert, local target.Cert) ([]target.Step, error) {
+	return planTestSteps, nil
+}
+func (planTestTarget) Deploy(ctx context.Context, local target.Cert) error {
+	planTestDeployed = true
+	return nil
+}
+func (planTestTarget) Verify(ctx context.Context, local target.Cert) error { return nil }
+
+func TestRunApplyStepsChanged(t *testing.T) {
+	dir := t.TempDir()
+	crtPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, crtPath, keyPath)
+	configPath := filepath.Join(dir, "config.yaml")
+	content := fmt.Sprintf(`certs:
+  - name: example
+    crt_path: %s
+    key_path: %s
+targets:
+  - name: fake
+    type: plan-test
+    cert: example
+`, crtPath, keyPath)
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	planned := []target.Step{{Action: target.StepSkip, Detail: "CAS 中已有相同指纹的证书"}, {Action: target.StepBind, Detail: "切换到新证书"}}
+	tests := []struct {
+		name  string
+		steps []target.Step
+		want  int
+	}{
+		{"部署步骤一致", planned, 0},
+		{"部署步骤变化", []target.Step{{Action: target.StepCreate, Detail: "上传本地证书"}, planned[1]}, exitFailure},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			planPath := filepath.Join(t.TempDir(), "plan.json")
+			planTestSteps, planTestDeployed = planned, false
+			if code := runPlan(configPath, "fake", planPath, false); code != 0 {
+				t.Fatalf("plan 退出码 = %d", code)
+			}
+			planTestSteps = test.steps
+			if code := runApply(configPath, planPath); code != test.want {
+				t.Fatalf("退出码 = %d，期望 %d", code, test.want)
+			}
+			if planTestDeployed != (test.want == 0) {
+				t.Fatalf("是否部署 = %v", planTestDeployed)
+			}
+		})
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/metrics"
+	"whoyang.cn/update_cert/target"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 探测的默认端口、握手超时和部署后重新探测的间隔
+const (
+	defaultProbePort    = "443"
+	defaultProbeTimeout = 10 * time.Second
+	probeInterval       = 10 * time.Second
+)
+
+// 单个地址的探测结果
+type endpointProbe struct {
+	Endpoint   string `json:"endpoint"`
+	ServerName string `json:"server_name"`
+	Address    string `json:"address"`
+	// 服务端返回的叶子证书
+	Domains     []string   `json:"domains,omitempty"`
+	Issuer      string     `json:"issuer,omitempty"`
+	Serial      string     `json:"serial,omitempty"`
+	Fingerprint string     `json:"fingerprint,omitempty"`
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+	// 证书链中最早的过期时间，中间证书先于叶子证书过期时与 not_after 不同
+	ChainNotAfter *time.Time `json:"chain_not_after,omitempty"`
+	// 证书链不受信任或与域名不匹配的原因
+	Untrusted string `json:"untrusted,omitempty"`
+	// 是否与本地证书一致
+	Match bool   `json:"match"`
+	Error string `json:"error,omitempty"`
+}
+
+// 单个目标的探测结果
+type targetProbe struct {
+	Target           string          `json:"target"`
+	Type             string          `json:"type"`
+	Cert             string          `json:"cert"`
+	LocalFingerprint string          `json:"local_fingerprint,omitempty"`
+	Endpoints        []endpointProbe `json:"endpoints,omitempty"`
+	// 无法探测时的错误
+	Error string `json:"error,omitempty"`
+}
+
+// 探测各目标对外提供的证书，与本地证书比对，不修改远端
+func runProbe(configPath string, selector string) int {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		utils.Println(err)
+		return exitConfigError
+	}
+	selector = defaultSelector(cfg, selector)
+	targets := cfg.SelectTargets(selector)
+	if len(targets) == 0 {
+		utils.Println("没有匹配的部署目标：", selector)
+		return exitConfigError
+	}
+
+	ctx := context.Background()
+	probes := make([]targetProbe, 0, len(targets))
+	total, failed := 0, 0
+	for _, targetConfig := range targets {
+		cert, _ := cfg.FindCert(targetConfig.Cert)
+		probe := probeTarget(ctx, targetConfig, cert)
+		if probe.Error != "" {
+			utils.Println(probe.Target, "无法探测：", probe.Error)
+			total++
+			failed++
+		}
+		for _, endpoint := range probe.Endpoints {
+			total++
+			if !endpoint.Match {
+				failed++
+			}
+		}
+		probes = append(probes, probe)
+		utils.Println("")
+	}
+
+	code := 0
+	switch {
+	case failed > 0 && failed == total:
+		code = exitFailure
+	case failed > 0:
+		code = exitPartialFailure
+	}
+	utils.Printf("共探测 %d 个地址，%d 个与本地证书不一致或探测失败\n", total, failed)
+	if outputFormat == outputJson {
+		encoder := json.NewEncoder(reportOut)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(struct {
+			Targets  []targetProbe `json:"targets"`
+			ExitCode int           `json:"exit_code"`
+		}{probes, code})
+		if err != nil {
+			utils.ErrorLog("输出探测结果异常：", err)
+		}
+	}
+	return code
+}
+
+// 读取本地证书，未配置探测地址时从远端获取目标的域名，再逐个探测
+func probeTarget(ctx context.Context, targetConfig config.Target, cert config.Cert) targetProbe {
+	utils.Println("====================================")
+	utils.Println("探测目标：", targetConfig.Name, "（", targetConfig.Type, "）")
+	utils.Println("====================================")
+
+	probe := targetProbe{Target: targetConfig.Name, Type: targetConfig.Type, Cert: targetConfig.Cert}
+	local, err := target.LoadCert(cert.Name, cert.CrtPath, cert.KeyPath)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	probe.LocalFingerprint = utils.CertFingerprint(local.Bundle.Leaf)
+
+	timeout, err := probeTimeout(targetConfig.Probe)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	endpoints := targetConfig.Probe.Endpoints
+	if len(endpoints) == 0 {
+		if endpoints, err = deployedDomains(ctx, targetConfig, local); err != nil {
+			probe.Error = err.Error()
+			return probe
+		}
+	}
+	probe.Endpoints = probeEndpoints(ctx, targetConfig.Name, endpoints, local, timeout)
+	return probe
+}
+
+// 从远端获取目标当前使用的域名
+func deployedDomains(ctx context.Context, targetConfig config.Target, local target.Cert) ([]string, error) {
+	t, err := target.New(targetConfig)
+	if err != nil {
+		return nil, err
+	}
+	if resolver, ok := t.(target.Resolver); ok {
+		if err := resolver.Resolve(ctx, local); err != nil {
+			return nil, err
+		}
+	}
+	current, err := t.Describe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	domains := probeDomains(t, current, local)
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("没有可探测的域名，请配置 probe.endpoints")
+	}
+	return domains, nil
+}
+
+// 目标和远端证书覆盖的域名，都为空时使用本地证书的域名；通配符域名无法直接访问，跳过
+func probeDomains(t target.Target, current *target.RemoteCert, local target.Cert) []string {
+	candidates := t.Domains()
+	if current != nil {
+		candidates = append(candidates, current.Domains...)
+	}
+	if len(candidates) == 0 {
+		candidates = local.Bundle.Leaf.DNSNames
+	}
+	var domains []string
+	seen := make(map[string]bool)
+	for _, domain := range candidates {
+		if strings.HasPrefix(domain, "*.") || seen[domain] {
+			continue
+		}
+		seen[domain] = true
+		domains = append(domains, domain)
+	}
+	return domains
+}
+
+// 逐个探测并与本地证书比对
+func probeEndpoints(ctx context.Context, targetName string, endpoints []string, local target.Cert, timeout time.Duration) []endpointProbe {
+	localFingerprint := utils.CertFingerprint(local.Bundle.Leaf)
+	results := make([]endpointProbe, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		result := probeEndpoint(ctx, endpoint, timeout)
+		result.Match = result.Error == "" && result.Fingerprint == localFingerprint
+		if result.NotAfter != nil {
+			metrics.SetServedNotAfter(targetName, endpoint, *result.NotAfter)
+		}
+		printEndpointProbe(result, localFingerprint)
+		results = append(results, result)
+	}
+	return results
+}
+
+func probeEndpoint(ctx context.Context, endpoint string, timeout time.Duration) endpointProbe {
+	result := endpointProbe{Endpoint: endpoint}
+	serverName, address, err := parseProbeEndpoint(endpoint)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.ServerName, result.Address = serverName, address
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	probe, err := utils.ProbeTLS(ctx, address, serverName)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	leaf := probe.Chain[0]
+	result.Domains, result.Issuer = leaf.DNSNames, leaf.Issuer.CommonName
+	result.Serial, result.Fingerprint = utils.CertSerial(leaf), utils.CertFingerprint(leaf)
+	notAfter, chainNotAfter := leaf.NotAfter, probe.NotAfter()
+	result.NotAfter, result.ChainNotAfter = &notAfter, &chainNotAfter
+	if probe.VerifyError != nil {
+		result.Untrusted = probe.VerifyError.Error()
+	}
+	return result
+}
+
+// 解析探测地址：域名、域名:端口，或 域名@地址:端口，端口默认 443
+func parseProbeEndpoint(endpoint string) (serverName string, address string, err error) {
+	serverName, address, found := strings.Cut(endpoint, "@")
+	if !found {
+		address = endpoint
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		host, port = strings.Trim(address, "[]"), defaultProbePort
+	}
+	if host == "" {
+		return "", "", fmt.Errorf("探测地址 %s 不合法", endpoint)
+	}
+	if !found {
+		serverName = host
+	}
+	return serverName, net.JoinHostPort(host, port), nil
+}
+
+// 输出单个地址的探测结果
+func printEndpointProbe(result endpointProbe, localFingerprint string) {
+	if result.Error != "" {
+		utils.Println(result.Endpoint, "探测失败：", result.Error)
+		return
+	}
+	utils.Printf("%s（%s）：指纹 %s…，序列号 %s，颁发机构 %s，有效期至 %s\n", result.Endpoint, result.Address,
+		result.Fingerprint[:16], result.Serial, result.Issuer, result.NotAfter.Local().Format("2006-01-02 15:04:05"))
+	if result.ChainNotAfter.Before(*result.NotAfter) {
+		utils.Println("  证书链中的中间证书", result.ChainNotAfter.Local().Format("2006-01-02 15:04:05"), "过期，早于叶子证书")
+	}
+	if time.Now().After(*result.ChainNotAfter) {
+		utils.Println("  返回的证书链已过期")
+	}
+	if result.Untrusted != "" {
+		utils.Println("  证书链校验失败：", result.Untrusted)
+	}
+	if result.Match {
+		utils.Println("  与本地证书一致")
+	} else {
+		utils.Printf("  与本地证书不一致（本地指纹 %s…）\n", localFingerprint[:16])
+	}
+}
+
+// 单个地址的握手超时
+func probeTimeout(probeConfig config.Probe) (time.Duration, error) {
+	if probeConfig.Timeout == "" {
+		return defaultProbeTimeout, nil
+	}
+	timeout, err := config.ParseDuration(probeConfig.Timeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("probe.timeout %s 不合法", probeConfig.Timeout)
+	}
+	return timeout, nil
+}
+
+// 部署后探测对外提供的证书，在 probe.wait 内每隔 probeInterval 重新探测，直到全部地址返回新证书
+func (p *preparedTarget) verifyServed(ctx context.Context) error {
+	timeout, err := probeTimeout(p.probe)
+	if err != nil {
+		return err
+	}
+	var wait time.Duration
+	if p.probe.Wait != "" {
+		if wait, err = config.ParseDuration(p.probe.Wait); err != nil || wait < 0 {
+			return fmt.Errorf("probe.wait %s 不合法", p.probe.Wait)
+		}
+	}
+	endpoints := p.probe.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = probeDomains(p.target, p.current, p.local)
+	}
+	if len(endpoints) == 0 {
+		return fmt.Errorf("部署后探测没有可探测的域名，请配置 probe.endpoints")
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		utils.Println("部署后探测对外提供的证书")
+		var mismatched []string
+		for _, result := range probeEndpoints(ctx, p.target.Name(), endpoints, p.local, timeout) {
+			if !result.Match {
+				mismatched = append(mismatched, result.Endpoint)
+			}
+		}
+		if len(mismatched) == 0 {
+			return nil
+		}
+		if time.Now().Add(probeInterval).After(deadline) {
+			return fmt.Errorf("部署后探测 %s 返回的证书与本地证书不一致", strings.Join(mismatched, "、"))
+		}
+		utils.Println("新证书尚未生效，", probeInterval, "后重新探测")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(probeInterval):
+		}
+	}
+}
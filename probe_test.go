@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"whoyang.cn/update_cert/utils"
+)
+
+func TestParseProbeEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint   string
+		serverName string
+		address    string
+	}{
+		{"example.com", "example.com", "example.com:443"},
+		{"example.com:8443", "example.com", "example.com:8443"},
+		{"example.com@10.0.0.1:8443", "example.com", "10.0.0.1:8443"},
+		{"example.com@10.0.0.1", "example.com", "10.0.0.1:443"},
+		{"www.example.com@cdn.example.net:443", "www.example.com", "cdn.example.net:443"},
+		{"[::1]:8443", "::1", "[::1]:8443"},
+		{"[::1]", "::1", "[::1]:443"},
+		{"example.com@[::1]:8443", "example.com", "[::1]:8443"},
+	}
+	for _, test := range tests {
+		serverName, address, err := parseProbeEndpoint(test.endpoint)
+		if err != nil || serverName != test.serverName || address != test.address {
+			t.Errorf("parseProbeEndpoint(%q) = %q, %q, %v，期望 %q, %q", test.endpoint, serverName, address, err,
+				test.serverName, test.address)
+		}
+	}
+
+	for _, endpoint := range []string{"", ":443", "example.com@", "example.com@:443"} {
+		if _, _, err := parseProbeEndpoint(endpoint); err == nil {
+			t.Errorf("parseProbeEndpoint(%q) 应返回错误", endpoint)
+		}
+	}
+}
+
+func TestProbeEndpoint(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "https://")
+
+	result := probeEndpoint(context.Background(), "example.com@"+address, 5*time.Second)
+	if result.Error != "" {
+		t.Fatal(result.Error)
+	}
+	leaf := server.Certificate()
+	if result.ServerName != "example.com" || result.Address != address {
+		t.Errorf("探测地址 = %s（%s）", result.ServerName, result.Address)
+	}
+	if result.Fingerprint != utils.CertFingerprint(leaf) || result.Serial != utils.CertSerial(leaf) {
+		t.Errorf("指纹 = %s，序列号 = %s", result.Fingerprint, result.Serial)
+	}
+	if result.NotAfter == nil || !result.NotAfter.Equal(leaf.NotAfter) || result.ChainNotAfter == nil {
+		t.Errorf("过期时间 = %v，证书链过期时间 = %v", result.NotAfter, result.ChainNotAfter)
+	}
+	// httptest 的证书不受系统根证书信任
+	if result.Untrusted == "" {
+		t.Error("证书不受信任时应记录原因")
+	}
+
+	if result := probeEndpoint(context.Background(), ":443", time.Second); !strings.Contains(result.Error, "不合法") {
+		t.Errorf("地址不合法时错误 = %q", result.Error)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/metrics"
+	"whoyang.cn/update_cert/notify"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 输出格式
+const (
+	outputText = "text"
+	outputJson = "json"
+)
+
+// json 输出时标准输出只保留执行结果，运行过程改为输出到标准错误
+var (
+	outputFormat           = outputText
+	reportOut    io.Writer = os.Stdout
+)
+
+// 设置输出格式
+func setOutput(format string) error {
+	switch format {
+	case outputText:
+	case outputJson:
+		utils.SetOutput(os.Stderr)
+	default:
+		return fmt.Errorf("输出格式 %s 不支持，可选：%s、%s", format, outputText, outputJson)
+	}
+	outputFormat = format
+	return nil
+}
+
+// 单个目标执行后的操作
+const (
+	actionCreated = "created"
+	actionUpdated = "updated"
+	actionSkipped = "skipped"
+	actionFailed  = "failed"
+)
+
+// 单个目标的执行结果
+type targetReport struct {
+	Target string `json:"target"`
+	Type   string `json:"type"`
+	// created、updated、skipped、failed
+	Action string `json:"action"`
+	// 是否需要更新的判断依据
+	Reason string `json:"reason,omitempty"`
+	// 证书的域名和颁发机构：部署成功时为新证书，否则为远端当前证书
+	Domains []string `json:"domains,omitempty"`
+	Issuer  string   `json:"issuer,omitempty"`
+	// 部署前远端证书的序列号和过期时间，远端未绑定证书时为空
+	OldSerial   string     `json:"old_serial,omitempty"`
+	OldNotAfter *time.Time `json:"old_not_after,omitempty"`
+	// 部署的本地证书的序列号和过期时间，未部署时为空
+	NewSerial   string     `json:"new_serial,omitempty"`
+	NewNotAfter *time.Time `json:"new_not_after,omitempty"`
+	DurationMs  int64      `json:"duration_ms"`
+	Error       string     `json:"error,omitempty"`
+
+	err error
+	// 本地证书的过期时间，读取本地证书失败时为空
+	localNotAfter *time.Time
+}
+
+// 准备并部署单个目标，记录新旧证书、耗时和结果
+func executeTarget(ctx context.Context, targetConfig config.Target, prepare func() (*preparedTarget, error)) targetReport {
+	start := time.Now()
+	report := targetReport{Target: targetConfig.Name, Type: targetConfig.Type}
+	prepared, err := prepare()
+	if err == nil {
+		report.Reason = prepared.reason
+		if current := prepared.current; current != nil {
+			report.OldSerial = current.Serial
+			if !current.NotAfter.IsZero() {
+				notAfter := current.NotAfter
+				report.OldNotAfter = &notAfter
+			}
+		}
+		err = prepared.deploy(ctx)
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+	report.err = err
+	if prepared != nil {
+		leaf := prepared.local.Bundle.Leaf
+		report.Domains, report.Issuer = leaf.DNSNames, leaf.Issuer.CommonName
+		report.localNotAfter = &leaf.NotAfter
+		if current := prepared.current; err != nil && current != nil {
+			report.Domains, report.Issuer = current.Domains, current.Issuer
+		}
+	}
+
+	switch {
+	case err == nil:
+		report.Action = actionUpdated
+		if prepared.current == nil {
+			report.Action = actionCreated
+		}
+		leaf := prepared.local.Bundle.Leaf
+		report.NewSerial = utils.CertSerial(leaf)
+		report.NewNotAfter = &leaf.NotAfter
+	case errors.Is(err, utils.ErrStillValid):
+		report.Action = actionSkipped
+	default:
+		report.Action = actionFailed
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// 输出单个目标的执行结果并发送通知，返回是否成功，无需更新也视为成功
+func reportResult(report targetReport) bool {
+	observeResult(report)
+	notifyResult(report)
+	name, err := report.Target, report.err
+	switch {
+	case err == nil:
+		utils.Println(name, "证书更新操作完成")
+	case errors.Is(err, utils.ErrStillValid):
+		utils.Println(name, err)
+	default:
+		utils.Println(name, "证书更新失败：", err)
+		if errors.Is(err, utils.ErrAuth) {
+			utils.Println("请检查 API TOKEN 或 AccessKey 配置")
+		}
+		return false
+	}
+	return true
+}
+
+// 记录证书过期时间和本次检查结果，部署成功时远端证书为新证书
+func observeResult(report targetReport) {
+	if report.localNotAfter != nil {
+		metrics.SetLocalNotAfter(report.Target, *report.localNotAfter)
+	}
+	remoteNotAfter := report.OldNotAfter
+	if report.NewNotAfter != nil {
+		remoteNotAfter = report.NewNotAfter
+	}
+	if remoteNotAfter != nil {
+		metrics.SetRemoteNotAfter(report.Target, *remoteNotAfter)
+	}
+	metrics.SetLastSync(report.Target, time.Now(), report.Action != actionFailed)
+}
+
+// 通知渠道，加载配置时更新
+var notifier = notify.NewManager()
+
+// 按执行结果发送通知，远端证书即将过期且未能更新时另外发送即将过期通知
+func notifyResult(report targetReport) {
+	event := notify.Event{
+		Target:  report.Target,
+		Type:    report.Type,
+		Domains: report.Domains,
+		Issuer:  report.Issuer,
+		Reason:  report.Reason,
+		Error:   report.Error,
+	}
+	validBefore, serial := report.OldNotAfter, report.OldSerial
+	switch report.Action {
+	case actionCreated, actionUpdated:
+		event.Kind = notify.EventSuccess
+		validBefore, serial = report.NewNotAfter, report.NewSerial
+	case actionSkipped:
+		event.Kind = notify.EventSkip
+	default:
+		event.Kind = notify.EventFailure
+	}
+	if validBefore != nil {
+		event.ValidBefore = *validBefore
+	}
+	event.Serial = serial
+
+	ctx := context.Background()
+	if err := notifier.Notify(ctx, event); err != nil {
+		utils.ErrorLog("发送通知失败：", err)
+	}
+	if event.Kind != notify.EventSuccess && validBefore != nil && time.Until(*validBefore) < notifier.ExpiryWarning() {
+		event.Kind = notify.EventExpiry
+		if err := notifier.Notify(ctx, event); err != nil {
+			utils.ErrorLog("发送通知失败：", err)
+		}
+	}
+}
+
+// 向通知渠道发送测试通知，用于检查通知配置
+func runNotifyTest(configPath string, name string) int {
+	if _, err := loadConfig(configPath); err != nil {
+		utils.Println(err)
+		return exitConfigError
+	}
+	event := notify.Event{
+		Kind:        notify.EventSkip,
+		Target:      "notify-test",
+		Type:        "test",
+		Domains:     []string{"example.com", "*.example.com"},
+		Issuer:      "update_cert",
+		ValidBefore: time.Now().Add(90 * 24 * time.Hour),
+		Reason:      "测试通知，请忽略",
+	}
+	if err := notifier.Test(context.Background(), name, event); err != nil {
+		utils.Println(err)
+		return exitFailure
+	}
+	return 0
+}
+
+// 按全部目标的结果确定退出码：全部成功、无需更新、部分失败、全部失败
+func exitCode(reports []targetReport) int {
+	failed, deployed := 0, 0
+	for _, report := range reports {
+		switch report.Action {
+		case actionFailed:
+			failed++
+		case actionCreated, actionUpdated:
+			deployed++
+		}
+	}
+	switch {
+	case len(reports) > 0 && failed == len(reports):
+		return exitFailure
+	case failed > 0:
+		return exitPartialFailure
+	case deployed == 0:
+		return exitNothingToDo
+	}
+	return 0
+}
+
+// json 输出时把全部目标的执行结果写入 out，返回退出码
+func finishReports(out io.Writer, reports []targetReport) int {
+	if outputFormat == outputJson {
+		if err := writeJsonReport(out, reports); err != nil {
+			utils.ErrorLog("输出执行结果异常：", err)
+		}
+	}
+	return exitCode(reports)
+}
+
+// 以 JSON 输出全部目标的执行结果
+func writeJsonReport(out io.Writer, reports []targetReport) error {
+	summary := map[string]int{}
+	for _, report := range reports {
+		summary[report.Action]++
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Targets  []targetReport `json:"targets"`
+		Summary  map[string]int `json:"summary"`
+		ExitCode int            `json:"exit_code"`
+	}{reports, summary, exitCode(reports)})
+}
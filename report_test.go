@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"whoyang.cn/update_cert/utils"
+)
+
+func reportsOf(actions ...string) []targetReport {
+	reports := make([]targetReport, 0, len(actions))
+	for i, action := range actions {
+		reports = append(reports, targetReport{Target: string(rune('a' + i)), Type: "safeline", Action: action})
+	}
+	return reports
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		reports []targetReport
+		want    int
+	}{
+		{"全部更新", reportsOf(actionUpdated, actionCreated), 0},
+		{"部分更新部分无需更新", reportsOf(actionUpdated, actionSkipped), 0},
+		{"全部无需更新", reportsOf(actionSkipped, actionSkipped), exitNothingToDo},
+		{"没有目标", nil, exitNothingToDo},
+		{"全部失败", reportsOf(actionFailed, actionFailed), exitFailure},
+		{"部分失败", reportsOf(actionUpdated, actionFailed), exitPartialFailure},
+		{"失败和无需更新", reportsOf(actionSkipped, actionFailed), exitPartialFailure},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := exitCode(test.reports); got != test.want {
+				t.Fatalf("退出码 = %d，期望 %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFinishReports(t *testing.T) {
+	defer func(format string) { outputFormat = format }(outputFormat)
+	reports := reportsOf(actionCreated, actionSkipped, actionFailed)
+
+	var out bytes.Buffer
+	outputFormat = outputText
+	if code := finishReports(&out, reports); code != exitPartialFailure || out.Len() != 0 {
+		t.Fatalf("text 输出：退出码 = %d，输出 %q", code, out.String())
+	}
+
+	outputFormat = outputJson
+	if code := finishReports(&out, reports); code != exitPartialFailure {
+		t.Fatalf("json 输出：退出码 = %d，期望 %d", code, exitPartialFailure)
+	}
+	var result struct {
+		Targets  []targetReport `json:"targets"`
+		Summary  map[string]int `json:"summary"`
+		ExitCode int            `json:"exit_code"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatalf("执行结果不是 JSON：%v\n%s", err, out.String())
+	}
+	if len(result.Targets) != 3 || result.ExitCode != exitPartialFailure {
+		t.Fatalf("执行结果 = %+v", result)
+	}
+	want := map[string]int{actionCreated: 1, actionSkipped: 1, actionFailed: 1}
+	for action, count := range want {
+		if result.Summary[action] != count {
+			t.Errorf("summary[%s] = %d，期望 %d", action, result.Summary[action], count)
+		}
+	}
+}
+
+func TestJsonReportSchema(t *testing.T) {
+	oldNotAfter := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	newNotAfter := oldNotAfter.AddDate(0, 3, 0)
+	reports := []targetReport{
+		{
+			Target: "waf", Type: "safeline", Action: actionUpdated, Reason: "远端证书即将过期",
+			Domains: []string{"example.com"}, Issuer: "R3",
+			OldSerial: "01", OldNotAfter: &oldNotAfter, NewSerial: "02", NewNotAfter: &newNotAfter,
+			DurationMs: 15, localNotAfter: &newNotAfter,
+		},
+		{Target: "oss", Type: "oss", Action: actionFailed, Error: "连接超时", err: errors.New("连接超时")},
+		{Target: "cdn", Type: "cdn", Action: actionSkipped, err: utils.ErrStillValid},
+	}
+	var out bytes.Buffer
+	if err := writeJsonReport(&out, reports); err != nil {
+		t.Fatal(err)
+	}
+
+	var result map[string]any
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"targets", "summary", "exit_code"} {
+		if _, ok := result[key]; !ok {
+			t.Errorf("缺少 %s", key)
+		}
+	}
+	if result["exit_code"] != float64(exitPartialFailure) {
+		t.Errorf("exit_code = %v", result["exit_code"])
+	}
+	targets := result["targets"].([]any)
+	updated := targets[0].(map[string]any)
+	want := map[string]any{
+		"target": "waf", "type": "safeline", "action": actionUpdated, "reason": "远端证书即将过期",
+		"issuer": "R3", "old_serial": "01", "old_not_after": "2030-01-02T03:04:05Z",
+		"new_serial": "02", "new_not_after": "2030-04-02T03:04:05Z", "duration_ms": float64(15),
+	}
+	for key, value := range want {
+		if updated[key] != value {
+			t.Errorf("%s = %v，期望 %v", key, updated[key], value)
+		}
+	}
+	if len(updated) != len(want)+1 {
+		t.Errorf("字段 = %v，不应输出其他字段", updated)
+	}
+
+	// 空字段省略，duration_ms 始终输出
+	failed := targets[1].(map[string]any)
+	if failed["error"] != "连接超时" || failed["duration_ms"] != float64(0) {
+		t.Errorf("失败目标 = %v", failed)
+	}
+	for _, key := range []string{"reason", "domains", "issuer", "old_serial", "old_not_after", "new_serial", "new_not_after"} {
+		if _, ok := failed[key]; ok {
+			t.Errorf("失败目标不应输出空字段 %s", key)
+		}
+	}
+}
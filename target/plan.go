@@ -0,0 +1,46 @@
+package target
+
+import (
+	"context"
+	"fmt"
+)
+
+// 部署计划中的操作
+const (
+	// 不需要更新，或者复用已有的证书
+	StepSkip = "skip"
+	// 新建证书，例如上传到 CAS 或在雷池中新建证书
+	StepCreate = "create"
+	// 原地更新证书内容
+	StepUpdate = "update"
+	// 将部署位置切换到新证书
+	StepBind = "bind"
+	// 新证书生效后删除旧证书
+	StepDeleteOld = "delete_old"
+)
+
+// 部署计划中的一步
+type Step struct {
+	Action string `json:"action"`
+	Detail string `json:"detail"`
+}
+
+func (s Step) String() string {
+	return fmt.Sprintf("[%s] %s", s.Action, s.Detail)
+}
+
+// 需要详细描述部署步骤的目标实现该接口，在 NeedRenew 之后调用，不能修改远端
+type Planner interface {
+	Plan(ctx context.Context, current *RemoteCert, local Cert) ([]Step, error)
+}
+
+// 获取部署步骤，目标未实现 Planner 时按远端是否已有证书给出新建或更新
+func PlanSteps(ctx context.Context, t Target, current *RemoteCert, local Cert) ([]Step, error) {
+	if planner, ok := t.(Planner); ok {
+		return planner.Plan(ctx, current, local)
+	}
+	if current == nil {
+		return []Step{{Action: StepCreate, Detail: "部署本地证书 " + local.Name}}, nil
+	}
+	return []Step{{Action: StepUpdate, Detail: fmt.Sprintf("将证书 %s 替换为本地证书 %s", current.Id, local.Name)}}, nil
+}
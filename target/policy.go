@@ -0,0 +1,124 @@
+package target
+
+import (
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 更新策略
+const (
+	// 过期前固定时长内更新
+	PolicyBeforeExpiry = "before_expiry"
+	// 剩余有效期不足总有效期的一定比例时更新
+	PolicyLifetimeFraction = "lifetime_fraction"
+	// 每次都部署
+	PolicyAlways = "always"
+	// 只在本地证书比远端证书新时部署
+	PolicyNewerOnly = "newer_only"
+)
+
+// 默认在证书过期前 72 小时内进行更新
+const DefaultRenewBefore = 72 * time.Hour
+
+// 默认剩余 1/3 有效期时更新
+const DefaultRenewFraction = 1.0 / 3
+
+// 证书更新策略
+type Policy struct {
+	Mode     string
+	Before   time.Duration
+	Fraction float64
+}
+
+// 根据配置创建更新策略，未配置时使用过期前 72 小时更新
+func NewPolicy(renew config.Renew) (Policy, error) {
+	policy := Policy{
+		Mode:     renew.Policy,
+		Before:   DefaultRenewBefore,
+		Fraction: DefaultRenewFraction,
+	}
+	if policy.Mode == "" {
+		policy.Mode = PolicyBeforeExpiry
+	}
+
+	if renew.BeforeExpiry != "" {
+		before, err := config.ParseDuration(renew.BeforeExpiry)
+		if err != nil {
+			return Policy{}, fmt.Errorf("更新策略 before_expiry 不合法：%w", err)
+		}
+		policy.Before = before
+	}
+	if renew.Fraction != 0 {
+		if renew.Fraction <= 0 || renew.Fraction >= 1 {
+			return Policy{}, fmt.Errorf("更新策略 fraction 需要在 0 到 1 之间：%v", renew.Fraction)
+		}
+		policy.Fraction = renew.Fraction
+	}
+
+	switch policy.Mode {
+	case PolicyBeforeExpiry, PolicyLifetimeFraction, PolicyAlways, PolicyNewerOnly:
+		return policy, nil
+	}
+	return Policy{}, fmt.Errorf("更新策略 %s 不支持，可选：%s、%s、%s、%s", policy.Mode,
+		PolicyBeforeExpiry, PolicyLifetimeFraction, PolicyAlways, PolicyNewerOnly)
+}
+
+// 比较远端证书与本地证书并按策略判断是否需要更新：
+// 指纹一致时跳过（always 除外），本地证书更新时立即部署，否则按策略的过期条件判断
+func (p Policy) Decide(current *RemoteCert, local Cert, now time.Time) (bool, string) {
+	if p.Mode == PolicyAlways {
+		return true, "更新策略为 always，强制部署"
+	}
+	if current == nil {
+		return true, "远端未绑定证书"
+	}
+
+	leaf := local.Bundle.Leaf
+	if current.Fingerprint != "" {
+		if current.Fingerprint == utils.CertFingerprint(leaf) {
+			return false, "远端证书与本地证书一致（指纹 " + current.Fingerprint[:16] + "…）"
+		}
+		if leaf.NotBefore.After(current.NotBefore) {
+			return true, fmt.Sprintf("本地证书（序列号 %s）比远端证书（序列号 %s）更新，上传本地证书替换",
+				utils.CertSerial(leaf), current.Serial)
+		}
+	}
+
+	left := current.NotAfter.Sub(now)
+	switch p.Mode {
+	case PolicyNewerOnly:
+		// 远端证书没有指纹时无法确认是否为同一张证书，按有效期判断本地证书是否更新
+		if current.Fingerprint == "" && newerThan(leaf, current) {
+			return true, fmt.Sprintf("本地证书（有效期至 %s）比远端证书（有效期至 %s）更新，上传本地证书替换",
+				leaf.NotAfter.Local().Format("2006-01-02 15:04:05"), current.NotAfter.Local().Format("2006-01-02 15:04:05"))
+		}
+		return false, fmt.Sprintf("本地证书不比远端证书新，证书剩余有效期 %.0f 小时", left.Hours())
+	case PolicyLifetimeFraction:
+		// 远端证书没有生效时间时无法计算总有效期，按固定时长判断
+		if !current.NotBefore.IsZero() {
+			lifetime := current.NotAfter.Sub(current.NotBefore)
+			threshold := time.Duration(float64(lifetime) * p.Fraction)
+			if left > threshold {
+				return false, fmt.Sprintf("证书剩余有效期 %.0f 小时，超过总有效期的 %.0f%%", left.Hours(), p.Fraction*100)
+			}
+			return true, fmt.Sprintf("证书剩余有效期 %.0f 小时，不足总有效期的 %.0f%%，上传本地证书替换", left.Hours(), p.Fraction*100)
+		}
+	}
+
+	if left > p.Before {
+		return false, fmt.Sprintf("证书剩余有效期 %.0f 小时", left.Hours())
+	}
+	return true, fmt.Sprintf("证书将要过期（剩余 %.0f 小时），上传本地证书替换", left.Hours())
+}
+
+// 本地证书的生效时间或过期时间是否晚于远端证书，远端证书没有生效时间时只比较过期时间
+func newerThan(leaf *x509.Certificate, current *RemoteCert) bool {
+	if !current.NotBefore.IsZero() && leaf.NotBefore.After(current.NotBefore) {
+		return true
+	}
+	return leaf.NotAfter.After(current.NotAfter)
+}
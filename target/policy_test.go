@@ -0,0 +1,156 @@
+package target
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 生成有效期为 notBefore 到 notAfter 的本地证书
+func newLocalCert(t *testing.T, notBefore time.Time, notAfter time.Time) Cert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(notBefore.UnixNano()),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Cert{Name: "example", Bundle: &utils.CertBundle{Leaf: leaf, PrivateKey: key}}
+}
+
+// 与本地证书相同的远端证书
+func remoteOf(cert Cert) *RemoteCert {
+	leaf := cert.Bundle.Leaf
+	return &RemoteCert{
+		NotBefore:   leaf.NotBefore,
+		NotAfter:    leaf.NotAfter,
+		Fingerprint: utils.CertFingerprint(leaf),
+		Serial:      utils.CertSerial(leaf),
+	}
+}
+
+type decideCase struct {
+	name    string
+	policy  Policy
+	current *RemoteCert
+	local   Cert
+	want    bool
+	reason  string
+}
+
+func runDecideCases(t *testing.T, now time.Time, tests []decideCase) {
+	t.Helper()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, reason := test.policy.Decide(test.current, test.local, now)
+			if got != test.want || !strings.Contains(reason, test.reason) {
+				t.Fatalf("Decide = %v，%q，期望 %v，包含 %q", got, reason, test.want, test.reason)
+			}
+		})
+	}
+}
+
+func TestDecideFingerprint(t *testing.T) {
+	now := time.Now()
+	policy := Policy{Mode: PolicyBeforeExpiry, Before: DefaultRenewBefore}
+	old := newLocalCert(t, now.AddDate(0, -2, 0), now.Add(24*time.Hour))
+	renewed := newLocalCert(t, now.Add(-time.Hour), now.AddDate(0, 3, 0))
+	// 本地证书比远端证书旧时不因指纹不同而部署
+	stale := remoteOf(renewed)
+	stale.Fingerprint = "0123456789ABCDEF0123456789ABCDEF"
+
+	runDecideCases(t, now, []decideCase{
+		{"远端未绑定证书", policy, nil, renewed, true, "远端未绑定证书"},
+		{"指纹一致且即将过期", policy, remoteOf(old), old, false, "远端证书与本地证书一致"},
+		{"本地证书更新", policy, remoteOf(old), renewed, true, "比远端证书"},
+		{"本地证书不比远端新", policy, stale, old, false, "剩余有效期"},
+		{"远端没有指纹时按有效期判断", policy, &RemoteCert{NotAfter: now.Add(24 * time.Hour)}, renewed, true, "将要过期"},
+	})
+}
+
+func TestDecidePolicy(t *testing.T) {
+	now := time.Now()
+	// 远端证书总有效期 90 天，剩余 20 天
+	remote := &RemoteCert{NotBefore: now.AddDate(0, 0, -70), NotAfter: now.AddDate(0, 0, 20), Fingerprint: "0123456789ABCDEF0123456789ABCDEF"}
+	// 本地证书不比远端新，只按过期条件判断
+	local := newLocalCert(t, now.AddDate(0, 0, -80), now.AddDate(0, 0, 10))
+	same := newLocalCert(t, now.AddDate(0, 0, -1), now.AddDate(0, 0, 1))
+
+	runDecideCases(t, now, []decideCase{
+		{"before_expiry 未到更新时间", Policy{Mode: PolicyBeforeExpiry, Before: 72 * time.Hour}, remote, local, false, "剩余有效期 480 小时"},
+		{"before_expiry 到达更新时间", Policy{Mode: PolicyBeforeExpiry, Before: 30 * 24 * time.Hour}, remote, local, true, "将要过期"},
+		{"lifetime_fraction 超过比例", Policy{Mode: PolicyLifetimeFraction, Fraction: 0.2}, remote, local, false, "超过总有效期的 20%"},
+		{"lifetime_fraction 不足比例", Policy{Mode: PolicyLifetimeFraction, Fraction: 1.0 / 3}, remote, local, true, "不足总有效期的 33%"},
+		{"lifetime_fraction 没有生效时间时按固定时长", Policy{Mode: PolicyLifetimeFraction, Fraction: 0.5, Before: 72 * time.Hour},
+			&RemoteCert{NotAfter: remote.NotAfter}, local, false, "剩余有效期 480 小时"},
+		{"always 指纹一致也部署", Policy{Mode: PolicyAlways}, remoteOf(same), same, true, "always"},
+		{"always 远端未绑定证书", Policy{Mode: PolicyAlways}, nil, same, true, "always"},
+		{"newer_only 即将过期也不部署", Policy{Mode: PolicyNewerOnly, Before: 30 * 24 * time.Hour}, remote, local, false, "不比远端证书新"},
+		{"newer_only 本地证书更新", Policy{Mode: PolicyNewerOnly}, remote, same, true, "比远端证书"},
+		{"newer_only 远端未绑定证书", Policy{Mode: PolicyNewerOnly}, nil, same, true, "远端未绑定证书"},
+		{"newer_only 远端没有指纹时本地证书有效期更晚", Policy{Mode: PolicyNewerOnly},
+			&RemoteCert{NotAfter: now.AddDate(0, 0, -1)}, same, true, "比远端证书（有效期至"},
+		{"newer_only 远端没有指纹时本地证书生效更晚", Policy{Mode: PolicyNewerOnly},
+			&RemoteCert{NotBefore: now.AddDate(0, 0, -70), NotAfter: now.AddDate(0, 0, 20)}, same, true, "更新"},
+		{"newer_only 远端没有指纹且有效期相同", Policy{Mode: PolicyNewerOnly},
+			&RemoteCert{NotBefore: same.Bundle.Leaf.NotBefore, NotAfter: same.Bundle.Leaf.NotAfter}, same, false, "不比远端证书新"},
+		{"newer_only 远端没有指纹且本地证书更旧", Policy{Mode: PolicyNewerOnly},
+			&RemoteCert{NotAfter: remote.NotAfter}, local, false, "不比远端证书新"},
+	})
+}
+
+func TestNewPolicy(t *testing.T) {
+	tests := []struct {
+		name  string
+		renew config.Renew
+		want  Policy
+		err   string
+	}{
+		{"默认", config.Renew{}, Policy{Mode: PolicyBeforeExpiry, Before: DefaultRenewBefore, Fraction: DefaultRenewFraction}, ""},
+		{"before_expiry", config.Renew{BeforeExpiry: "7d"}, Policy{Mode: PolicyBeforeExpiry, Before: 7 * 24 * time.Hour, Fraction: DefaultRenewFraction}, ""},
+		{"lifetime_fraction", config.Renew{Policy: PolicyLifetimeFraction, Fraction: 0.25}, Policy{Mode: PolicyLifetimeFraction, Before: DefaultRenewBefore, Fraction: 0.25}, ""},
+		{"before_expiry 不合法", config.Renew{BeforeExpiry: "abc"}, Policy{}, "before_expiry"},
+		{"fraction 超出范围", config.Renew{Policy: PolicyLifetimeFraction, Fraction: 1.5}, Policy{}, "fraction"},
+		{"fraction 为负数", config.Renew{Fraction: -0.1}, Policy{}, "fraction"},
+		{"策略不支持", config.Renew{Policy: "weekly"}, Policy{}, "weekly 不支持"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy, err := NewPolicy(test.renew)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("错误 = %v，期望包含 %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy != test.want {
+				t.Fatalf("策略 = %+v，期望 %+v", policy, test.want)
+			}
+		})
+	}
+}
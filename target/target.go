@@ -0,0 +1,121 @@
+package target
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 本地证书
+type Cert struct {
+	Name    string
+	CrtPath string
+	KeyPath string
+	// 解析后的证书链和私钥，部署时使用其中整理好顺序的 PEM
+	Bundle *utils.CertBundle
+}
+
+// 读取并解析本地证书
+func LoadCert(name string, crtPath string, keyPath string) (Cert, error) {
+	bundle, err := utils.LoadCertBundle(crtPath, keyPath)
+	if err != nil {
+		return Cert{}, fmt.Errorf("本地证书 %s 无法使用：%w", name, err)
+	}
+	return Cert{
+		Name:    name,
+		CrtPath: crtPath,
+		KeyPath: keyPath,
+		Bundle:  bundle,
+	}, nil
+}
+
+// 远端当前使用的证书
+type RemoteCert struct {
+	Id        string
+	Domains   []string
+	Issuer    string
+	NotBefore time.Time
+	NotAfter  time.Time
+	// 证书内容，部分目标无法获取时为空
+	Crt string
+	// 叶子证书的 SHA-256 指纹和序列号，无法获取证书内容时为空
+	Fingerprint string
+	Serial      string
+}
+
+// 根据远端证书内容补全指纹、序列号和有效期
+func (r *RemoteCert) FillFromPEM(crtPEM string) error {
+	leaf, err := utils.ParseLeafPEM(crtPEM)
+	if err != nil {
+		return fmt.Errorf("解析远端证书异常：%w", err)
+	}
+	r.Crt = crtPEM
+	r.Fingerprint = utils.CertFingerprint(leaf)
+	r.Serial = utils.CertSerial(leaf)
+	r.NotBefore = leaf.NotBefore
+	r.NotAfter = leaf.NotAfter
+	if r.Issuer == "" {
+		r.Issuer = leaf.Issuer.CommonName
+	}
+	if len(r.Domains) == 0 {
+		r.Domains = leaf.DNSNames
+	}
+	return nil
+}
+
+// 部署目标，新的部署位置实现该接口并通过 Register 注册即可
+type Target interface {
+	// 目标名称
+	Name() string
+	// 本地证书需要覆盖的域名，无法从配置得知时返回空
+	Domains() []string
+	// 获取远端当前证书，未绑定证书时返回 nil
+	Describe(ctx context.Context) (*RemoteCert, error)
+	// 判断是否需要更新，并返回原因
+	NeedRenew(ctx context.Context, current *RemoteCert, local Cert) (bool, string, error)
+	// 部署本地证书
+	Deploy(ctx context.Context, local Cert) error
+	// 部署后校验远端证书
+	Verify(ctx context.Context, local Cert) error
+}
+
+// 需要根据本地证书确定远端资源的目标实现该接口，在 Describe 之前调用
+type Resolver interface {
+	Resolve(ctx context.Context, local Cert) error
+}
+
+// 根据配置创建部署目标
+type Factory func(config config.Target) (Target, error)
+
+var factories = make(map[string]Factory)
+
+// 注册部署目标类型，一般在客户端包的 init 中调用
+func Register(targetType string, factory Factory) {
+	if _, ok := factories[targetType]; ok {
+		panic("部署目标类型重复注册：" + targetType)
+	}
+	factories[targetType] = factory
+}
+
+// 创建部署目标
+func New(config config.Target) (Target, error) {
+	factory, ok := factories[config.Type]
+	if !ok {
+		return nil, fmt.Errorf("部署目标 %s 的类型 %s 不支持，可选：%v", config.Name, config.Type, Types())
+	}
+	return factory(config)
+}
+
+// 已注册的目标类型
+func Types() []string {
+	var types []string
+	for targetType := range factories {
+		types = append(types, targetType)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -1,73 +1,348 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"github.com/joho/godotenv"
 	"os"
-	"whoyang.cn/update_cert/client/aliyun"
-	"whoyang.cn/update_cert/client/safeline"
+	"strings"
+	"time"
+	_ "whoyang.cn/update_cert/client/aliyun"
+	_ "whoyang.cn/update_cert/client/safeline"
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/target"
+	"whoyang.cn/update_cert/utils"
 )
 
-var aliyunVersion = "v0.1"
-var safelineVersion = "v0.2"
+var version = "v0.3"
+
+// 退出码
+const (
+	// 全部目标失败
+	exitFailure     = 1
+	exitConfigError = 2
+	// 全部目标都无需更新
+	exitNothingToDo = 3
+	// 部分目标失败
+	exitPartialFailure = 4
+)
+
+// 默认配置文件路径，不存在时回退到 .env 变量
+const defaultConfigPath = "config.yaml"
 
 func main() {
 
-	//加载.env文件
-	err := godotenv.Load(".env")
+	configPath := flag.String("config", "", "配置文件路径，默认读取当前目录的 config.yaml，不存在时使用 .env")
+	force := flag.Bool("force", false, "忽略更新策略，强制部署全部目标")
+	debounce := flag.Duration("debounce", 5*time.Second, "watch 模式下证书文件停止写入多久后部署")
+	dryRun := flag.Bool("dry-run", false, "只输出部署计划或将要删除的证书，不修改远端")
+	unbound := flag.Bool("unbound", false, "gc 时同时清理未部署到任何云产品、也不是配置的目标正在使用的证书")
+	planPath := flag.String("plan", defaultPlanPath, "plan 保存、apply 读取的部署计划文件")
+	listen := flag.String("listen", "", "daemon 模式下提供 /metrics、/healthz、/readyz 的监听地址，例如 :9464，为空时不启用")
+	output := flag.String("output", outputText, "输出格式 text 或 json，json 时标准输出只有执行结果，日志输出到标准错误")
+	flag.Parse()
+
+	// 参数可以写在子命令或目标之后，例如 all -dry-run、plan all -force
+	args, err := parseArgs(flag.CommandLine, flag.Args())
 	if err != nil {
-		fmt.Println("加载.env文件异常：", err)
-		return
+		utils.Println(err)
+		os.Exit(exitConfigError)
 	}
-
-	args := os.Args[1:]
 	var updateType = ""
 	if len(args) != 0 {
 		updateType = args[0]
 	}
+	// 子命令之后的目标、证书名称或通知渠道
+	var selector = ""
+	if len(args) > 1 {
+		selector = args[1]
+	}
+	if err := checkOutput(updateType, *output); err != nil {
+		utils.Println(err)
+		os.Exit(exitConfigError)
+	}
+
+	//daemon|watch [-config x.yaml] [目标]
+	if updateType == "daemon" || updateType == "watch" {
+		if selector == "" {
+			selector = "all"
+		}
+		if updateType == "watch" {
+			os.Exit(runWatch(*configPath, selector, *force, *debounce))
+		}
+		os.Exit(runDaemon(*configPath, selector, *force, *listen))
+	}
+
+	//issue|renew [-config x.yaml] [证书名称]
+	if updateType == "issue" || updateType == "renew" {
+		os.Exit(runIssue(*configPath, selector, updateType == "issue" || *force))
+	}
+
+	//gc [-dry-run] [-unbound] [目标]
+	if updateType == "gc" {
+		if selector == "" {
+			selector = "all"
+		}
+		os.Exit(runGC(*configPath, selector, *dryRun, *unbound))
+	}
+
+	//plan [-plan plan.json] [目标]
+	if updateType == "plan" {
+		exitOnOutputError(*output)
+		os.Exit(runPlan(*configPath, selector, *planPath, *force))
+	}
+
+	//apply [-plan plan.json]
+	if updateType == "apply" {
+		exitOnOutputError(*output)
+		os.Exit(runApply(*configPath, *planPath))
+	}
+
+	//probe [目标]
+	if updateType == "probe" {
+		exitOnOutputError(*output)
+		os.Exit(runProbe(*configPath, selector))
+	}
+
+	if updateType != "help" {
+		exitOnOutputError(*output)
+	}
+
+	//notify [通知渠道]
+	if updateType == "notify" {
+		os.Exit(runNotifyTest(*configPath, selector))
+	}
+
+	//-dry-run [目标]：只输出部署计划，不保存
+	if *dryRun {
+		os.Exit(runPlan(*configPath, updateType, "", *force))
+	}
 
 	if updateType == "help" {
-		fmt.Println("====================================")
-		fmt.Println("\t\t证书同步工具\t\t")
-		fmt.Println("====================================")
-		fmt.Println("")
-		fmt.Println("")
-		fmt.Println("all：更新同步阿里云OSS证书和长亭雷池证书")
-		fmt.Println("aliyun：更新同步阿里云 OSS 证书")
-		fmt.Println("safeline：更新长亭雷池证书")
-		fmt.Println("不填写默认执行长亭雷池更新的更新操作")
+		utils.Println("====================================")
+		utils.Println("\t\t证书同步工具 ", version)
+		utils.Println("====================================")
+		utils.Println("")
+		utils.Println("")
+		utils.Println("all：更新同步配置中的全部目标")
+		utils.Println("aliyun：更新同步全部阿里云目标，也可以指定类型，例如 aliyun-oss、aliyun-cdn")
+		utils.Println("safeline：更新长亭雷池证书")
+		utils.Println("<目标名称>：只更新配置文件中指定名称的目标")
+		utils.Println("daemon [目标]：常驻运行，按配置中的 schedule 定时检查并更新，加 -listen 提供指标和健康检查")
+		utils.Println("watch [目标]：监听证书文件，证书更新后立即部署")
+		utils.Println("issue [证书名称]：通过 ACME 签发证书并部署到引用该证书的目标")
+		utils.Println("renew [证书名称]：证书即将过期时通过 ACME 重新签发并部署")
+		utils.Println("gc [目标]：清理阿里云 CAS 中过期的上传证书，加 -unbound 同时清理未部署的证书，加 -dry-run 只列出不删除")
+		utils.Println("plan [目标]：获取远端证书并输出各目标的部署计划，不修改远端，计划保存到 -plan 指定的文件")
+		utils.Println("apply：按 plan 保存的计划部署，远端或本地证书已变化的目标不执行")
+		utils.Println("-dry-run [目标]：只输出部署计划，不保存也不部署")
+		utils.Println("notify [通知渠道]：向全部或指定的通知渠道发送测试通知")
+		utils.Println("probe [目标]：与各目标的域名 TLS 握手，检查对外提供的证书是否与本地证书一致")
+		utils.Println("使用 .env 时不填写默认执行长亭雷池更新的更新操作，使用配置文件时默认更新全部目标")
+		utils.Println("")
+		flag.PrintDefaults()
 		return
-	} else {
-		if updateType == "all" || updateType == "aliyun" {
-			aliyun.Run(aliyun.AccessConfig{
-				KeyId:  os.Getenv("ALIYUN_ACCESS_KEY_ID"),
-				Secret: os.Getenv("ALIYUN_ACCESS_SECRET"),
-			}, aliyun.OssConfig{
-				Endpoint:   os.Getenv("ALIYUN_OSS_Endpoint"),
-				BucketName: os.Getenv("ALIYUN_OSS_BUCKET_NAME"),
-				Domain:     os.Getenv("ALIYUN_OSS_DOMAIN"),
-			}, aliyun.CertConfig{
-				CrtPath: os.Getenv("CERT_CRT_PATH"),
-				KeyPath: os.Getenv("CERT_KEY_PATH"),
-			}, aliyunVersion)
-
-			fmt.Println("")
-			fmt.Println("阿里云 OSS 证书更新操作完成")
-			fmt.Println("")
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		utils.Println(err)
+		os.Exit(exitConfigError)
+	}
+
+	if *force {
+		cfg.Force()
+	}
+
+	updateType = defaultSelector(cfg, updateType)
+	targets := cfg.SelectTargets(updateType)
+	if len(targets) == 0 {
+		utils.Println("没有匹配的部署目标：", updateType)
+		os.Exit(exitConfigError)
+	}
+
+	ctx := context.Background()
+	reports := make([]targetReport, 0, len(targets))
+	for _, targetConfig := range targets {
+		cert, _ := cfg.FindCert(targetConfig.Cert)
+		report := runTarget(ctx, targetConfig, cert)
+		reportResult(report)
+		reports = append(reports, report)
+		utils.Println("")
+	}
+	os.Exit(finishReports(reportOut, reports))
+}
+
+// 解析子命令和目标之间、之后的参数，返回子命令和目标；子命令只接受一个目标，
+// 直接指定目标时不能再跟其他参数
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for len(args) > 0 {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	maxArgs := 1
+	if len(positional) > 0 && subcommands[positional[0]] {
+		maxArgs = 2
+	}
+	if len(positional) > maxArgs {
+		return nil, fmt.Errorf("多余的参数：%s，执行 help 查看用法", strings.Join(positional[maxArgs:], " "))
+	}
+	return positional, nil
+}
+
+// 可以跟一个目标、证书名称或通知渠道的子命令
+var subcommands = map[string]bool{
+	"daemon": true, "watch": true, "issue": true, "renew": true, "gc": true,
+	"plan": true, "probe": true, "notify": true,
+}
+
+// 只输出日志、没有 JSON 执行结果的子命令
+var textOnlyCommands = map[string]bool{
+	"issue":  true,
+	"renew":  true,
+	"gc":     true,
+	"daemon": true,
+	"watch":  true,
+	"notify": true,
+}
+
+// 子命令不支持 JSON 输出时拒绝 -output json，避免调用方等不到执行结果
+func checkOutput(command string, format string) error {
+	if format == outputJson && textOnlyCommands[command] {
+		return fmt.Errorf("%s 不支持 -output %s，只有部署目标、-dry-run、plan、apply、probe 支持", command, outputJson)
+	}
+	return nil
+}
+
+// 设置输出格式，不支持时退出
+func exitOnOutputError(format string) {
+	if err := setOutput(format); err != nil {
+		utils.Println(err)
+		os.Exit(exitConfigError)
+	}
+}
+
+// 加载配置并更新通知渠道
+func loadConfig(configPath string) (*config.Config, error) {
+	cfg, err := readConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := notifier.Configure(cfg.Notify); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// 读取配置：优先使用配置文件，否则回退到 .env 变量
+func readConfig(configPath string) (*config.Config, error) {
+	//加载.env文件，配置文件中可以通过 ${ENV} 引用其中的变量
+	envErr := godotenv.Load(".env")
+
+	if configPath == "" {
+		if _, err := os.Stat(defaultConfigPath); err == nil {
+			configPath = defaultConfigPath
 		}
+	}
+	if configPath != "" {
+		return config.Load(configPath)
+	}
+
+	if envErr != nil {
+		return nil, fmt.Errorf("加载.env文件异常：%w", envErr)
+	}
+	return config.FromEnv(), nil
+}
 
-		if updateType == "" || updateType == "all" || updateType == "safeline" {
-			safeline.Run(safeline.ServerConfig{
-				Url:      os.Getenv("BASE_SERVER_URL"),
-				ApiToken: os.Getenv("API_TOKEN"),
-				CertId:   os.Getenv("CERT_ID"),
-			}, false, safelineVersion)
+// 执行单个部署目标：获取远端证书、判断是否需要更新、部署并校验
+func runTarget(ctx context.Context, targetConfig config.Target, cert config.Cert) targetReport {
+	return executeTarget(ctx, targetConfig, func() (*preparedTarget, error) {
+		return prepareTarget(ctx, targetConfig, cert)
+	})
+}
 
-			fmt.Println("")
-			fmt.Println("长亭雷池证书更新操作完成")
-			fmt.Println("")
+// 获取远端证书并判断是否需要更新后的部署目标
+type preparedTarget struct {
+	target  target.Target
+	local   target.Cert
+	current *target.RemoteCert
+	renew   bool
+	reason  string
+	// 部署后探测对外提供的证书的配置
+	probe config.Probe
+}
+
+// 创建部署目标、读取本地证书、获取远端证书并判断是否需要更新，不修改远端
+func prepareTarget(ctx context.Context, targetConfig config.Target, cert config.Cert) (*preparedTarget, error) {
+	utils.Println("====================================")
+	utils.Println("部署目标：", targetConfig.Name, "（", targetConfig.Type, "）")
+	utils.Println("====================================")
+	utils.Println("")
+
+	t, err := target.New(targetConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := target.LoadCert(cert.Name, cert.CrtPath, cert.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if resolver, ok := t.(target.Resolver); ok {
+		if err := resolver.Resolve(ctx, local); err != nil {
+			return nil, err
 		}
+	}
 
+	current, err := t.Describe(ctx)
+	if err != nil {
+		return nil, err
 	}
 
+	//部署前校验本地证书：有效期、私钥、证书链以及是否覆盖目标域名
+	domains := t.Domains()
+	if current != nil {
+		domains = append(domains, current.Domains...)
+	}
+	if err := local.Bundle.Validate(domains, time.Now()); err != nil {
+		utils.Println(local.Bundle.Summary())
+		return nil, err
+	}
+
+	renew, reason, err := t.NeedRenew(ctx, current, local)
+	if err != nil {
+		return nil, err
+	}
+	return &preparedTarget{target: t, local: local, current: current, renew: renew, reason: reason,
+		probe: targetConfig.Probe}, nil
+}
+
+// 部署并校验，开启 probe.after_deploy 时再探测对外提供的证书，不需要更新时返回 ErrStillValid
+func (p *preparedTarget) deploy(ctx context.Context) error {
+	if !p.renew {
+		return fmt.Errorf("%s：%w", p.reason, utils.ErrStillValid)
+	}
+	utils.Printf("%s：%s\n", p.target.Name(), p.reason)
+
+	if err := p.target.Deploy(ctx, p.local); err != nil {
+		return err
+	}
+	if err := p.target.Verify(ctx, p.local); err != nil {
+		return err
+	}
+	if config.BoolValue(p.probe.AfterDeploy) {
+		return p.verifyServed(ctx)
+	}
+	return nil
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		want  []string
+		force bool
+		dry   bool
+		plan  string
+		err   string
+	}{
+		{name: "没有参数", args: nil, want: nil},
+		{name: "目标之后的参数", args: []string{"all", "-dry-run"}, want: []string{"all"}, dry: true},
+		{name: "雷池之后的参数", args: []string{"safeline", "-dry-run"}, want: []string{"safeline"}, dry: true},
+		{name: "强制部署", args: []string{"all", "-force"}, want: []string{"all"}, force: true},
+		{name: "子命令和目标之间的参数", args: []string{"plan", "-plan", "p.json", "waf", "-force"},
+			want: []string{"plan", "waf"}, force: true, plan: "p.json"},
+		{name: "子命令没有目标", args: []string{"gc", "-dry-run"}, want: []string{"gc"}, dry: true},
+		{name: "子命令多余的目标", args: []string{"plan", "waf", "oss"}, err: "多余的参数：oss"},
+		{name: "目标之后多余的参数", args: []string{"all", "waf"}, err: "多余的参数：waf"},
+		{name: "apply 不接受目标", args: []string{"apply", "waf"}, err: "多余的参数：waf"},
+		{name: "未知参数", args: []string{"all", "-unknown"}, err: "-unknown"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flags := flag.NewFlagSet("update_safelne", flag.ContinueOnError)
+			flags.SetOutput(io.Discard)
+			force := flags.Bool("force", false, "")
+			dryRun := flags.Bool("dry-run", false, "")
+			planPath := flags.String("plan", "", "")
+
+			got, err := parseArgs(flags, test.args)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("错误 = %v，期望包含 %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("参数 = %q，期望 %q", got, test.want)
+			}
+			if *force != test.force || *dryRun != test.dry || *planPath != test.plan {
+				t.Errorf("force = %v，dry-run = %v，plan = %q", *force, *dryRun, *planPath)
+			}
+		})
+	}
+}
+
+func TestCheckOutput(t *testing.T) {
+	tests := []struct {
+		command string
+		format  string
+		wantErr bool
+	}{
+		{command: "all", format: outputJson},
+		{command: "plan", format: outputJson},
+		{command: "apply", format: outputJson},
+		{command: "probe", format: outputJson},
+		{command: "issue", format: outputText},
+		{command: "issue", format: outputJson, wantErr: true},
+		{command: "renew", format: outputJson, wantErr: true},
+		{command: "gc", format: outputJson, wantErr: true},
+		{command: "daemon", format: outputJson, wantErr: true},
+		{command: "watch", format: outputJson, wantErr: true},
+		{command: "notify", format: outputJson, wantErr: true},
+	}
+	for _, test := range tests {
+		if err := checkOutput(test.command, test.format); (err != nil) != test.wantErr {
+			t.Errorf("%s -output %s：错误 = %v，期望出错 %v", test.command, test.format, err, test.wantErr)
+		}
+	}
+}
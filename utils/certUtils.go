@@ -0,0 +1,345 @@
+package utils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 解析后的本地证书：叶子证书、按签发顺序排列的中间证书和私钥
+type CertBundle struct {
+	Leaf          *x509.Certificate
+	Intermediates []*x509.Certificate
+	PrivateKey    crypto.PrivateKey
+	// 证书文件中附带的根证书，只用于校验证书链，不随证书链下发
+	Root *x509.Certificate
+
+	// 按 叶子证书 -> 中间证书 顺序重新编码的证书链
+	CrtPEM string
+	KeyPEM string
+}
+
+// 证书校验未通过，Problems 中列出全部问题
+type CertValidationError struct {
+	Subject  string
+	Problems []string
+	// 归类后的通用错误，例如 ErrCertExpired，可能为空
+	Kind error
+}
+
+func (e *CertValidationError) Error() string {
+	return fmt.Sprintf("证书 %s 校验未通过：\n  - %s", e.Subject, strings.Join(e.Problems, "\n  - "))
+}
+
+func (e *CertValidationError) Unwrap() error {
+	return e.Kind
+}
+
+// 读取并解析证书和私钥文件
+func LoadCertBundle(crtPath string, keyPath string) (*CertBundle, error) {
+	if crtPath == "" {
+		return nil, fmt.Errorf("证书公钥地址不能为空")
+	}
+	if keyPath == "" {
+		return nil, fmt.Errorf("证书私钥地址不能为空")
+	}
+	crt, err := ReadFile(crtPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := ParseCertBundle([]byte(crt), []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("%s：%w", crtPath, err)
+	}
+	return bundle, nil
+}
+
+// 解析 PEM 格式的证书链和私钥，找出与私钥匹配的叶子证书并整理中间证书顺序
+func ParseCertBundle(crtPEM []byte, keyPEM []byte) (*CertBundle, error) {
+	var certs []*x509.Certificate
+	rest := crtPEM
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("解析证书异常：%w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("证书文件中没有 PEM 格式的证书")
+	}
+
+	privateKey, keyDER, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	// 找出与私钥匹配的叶子证书
+	leafIndex := -1
+	for i, cert := range certs {
+		if publicKeyMatches(cert.PublicKey, privateKey) {
+			leafIndex = i
+			break
+		}
+	}
+	if leafIndex < 0 {
+		return nil, fmt.Errorf("私钥与证书文件中的任何证书都不匹配")
+	}
+	leaf := certs[leafIndex]
+	others := append(append([]*x509.Certificate{}, certs[:leafIndex]...), certs[leafIndex+1:]...)
+
+	intermediates, root, err := orderChain(leaf, others)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &CertBundle{
+		Leaf:          leaf,
+		Intermediates: intermediates,
+		PrivateKey:    privateKey,
+		Root:          root,
+		KeyPEM:        string(pem.EncodeToMemory(keyDER)),
+	}
+	var chain bytes.Buffer
+	for _, cert := range append([]*x509.Certificate{leaf}, intermediates...) {
+		_ = pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	bundle.CrtPEM = chain.String()
+	return bundle, nil
+}
+
+// 解析私钥，支持 PKCS#1、PKCS#8 和 EC 格式
+func parsePrivateKey(keyPEM []byte) (crypto.PrivateKey, *pem.Block, error) {
+	rest := keyPEM
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, nil, fmt.Errorf("私钥文件中没有 PEM 格式的私钥")
+		}
+		if !strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			continue
+		}
+		if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			return key, block, nil
+		}
+		if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+			return key, block, nil
+		}
+		if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+			return key, block, nil
+		}
+		return nil, nil, fmt.Errorf("无法解析私钥，仅支持 RSA、ECDSA、Ed25519")
+	}
+}
+
+// 判断证书公钥与私钥是否匹配
+func publicKeyMatches(publicKey crypto.PublicKey, privateKey crypto.PrivateKey) bool {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return key.PublicKey.Equal(publicKey)
+	case *ecdsa.PrivateKey:
+		return key.PublicKey.Equal(publicKey)
+	case ed25519.PrivateKey:
+		return key.Public().(ed25519.PublicKey).Equal(publicKey)
+	}
+	return false
+}
+
+// 从叶子证书开始按签发关系排列中间证书，根证书不随证书链下发，单独返回
+func orderChain(leaf *x509.Certificate, others []*x509.Certificate) ([]*x509.Certificate, *x509.Certificate, error) {
+	var chain []*x509.Certificate
+	var root *x509.Certificate
+	used := make([]bool, len(others))
+	current := leaf
+	for {
+		next := -1
+		for i, cert := range others {
+			if !used[i] && bytes.Equal(current.RawIssuer, cert.RawSubject) && current.CheckSignatureFrom(cert) == nil {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			break
+		}
+		used[next] = true
+		current = others[next]
+		if isSelfSigned(current) {
+			root = current
+			continue
+		}
+		chain = append(chain, current)
+	}
+
+	for i, cert := range others {
+		if !used[i] {
+			return nil, nil, fmt.Errorf("证书链中的 %s 不属于 %s 的签发链", cert.Subject.String(), leaf.Subject.String())
+		}
+	}
+	return chain, root, nil
+}
+
+// 是否为自签名证书，不要求是 CA 证书，自签名的叶子证书同样适用
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+		cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// 校验证书有效期、中间证书有效期、证书链是否完整以及是否覆盖全部域名
+func (b *CertBundle) Validate(domains []string, now time.Time) error {
+	validationError := &CertValidationError{Subject: b.Leaf.Subject.CommonName}
+	if validationError.Subject == "" && len(b.Leaf.DNSNames) > 0 {
+		validationError.Subject = b.Leaf.DNSNames[0]
+	}
+
+	if now.Before(b.Leaf.NotBefore) {
+		validationError.Problems = append(validationError.Problems,
+			fmt.Sprintf("证书尚未生效，生效时间：%s", b.Leaf.NotBefore.Local().Format("2006-01-02 15:04:05")))
+	}
+	if now.After(b.Leaf.NotAfter) {
+		validationError.Kind = ErrCertExpired
+		validationError.Problems = append(validationError.Problems,
+			fmt.Sprintf("证书已过期，过期时间：%s", b.Leaf.NotAfter.Local().Format("2006-01-02 15:04:05")))
+	}
+	for _, cert := range b.Intermediates {
+		if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+			validationError.Problems = append(validationError.Problems,
+				fmt.Sprintf("中间证书 %s 不在有效期内", cert.Subject.CommonName))
+		}
+	}
+
+	// 只有叶子证书时缺少签发者通常是用错了证书文件，直接拒绝；有中间证书时可能是私有 CA，只提示
+	var unknownAuthority x509.UnknownAuthorityError
+	if err := b.verifyChain(now); errors.As(err, &unknownAuthority) {
+		if len(b.Intermediates) == 0 {
+			validationError.Problems = append(validationError.Problems,
+				fmt.Sprintf("证书链不完整，缺少签发者 %s 的证书，请使用包含中间证书的完整证书链（例如 fullchain.pem），私有 CA 签发的证书可在证书文件末尾附上根证书",
+					b.Leaf.Issuer.CommonName))
+		} else {
+			Println("证书链无法校验到系统信任的根证书：", err, "；私有 CA 签发的证书可在证书文件末尾附上根证书")
+		}
+	}
+
+	for _, domain := range domains {
+		if domain != "" && !CertCoversDomain(b.Leaf, domain) {
+			validationError.Problems = append(validationError.Problems,
+				fmt.Sprintf("证书域名 %v 不包含 %s", b.Leaf.DNSNames, domain))
+		}
+	}
+
+	if len(validationError.Problems) > 0 {
+		return validationError
+	}
+	return nil
+}
+
+// 按系统信任的根证书和证书文件中附带的根证书校验证书链，自签名证书不校验
+func (b *CertBundle) verifyChain(now time.Time) error {
+	if isSelfSigned(b.Leaf) {
+		return nil
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if b.Root != nil {
+		roots.AddCert(b.Root)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range b.Intermediates {
+		intermediates.AddCert(cert)
+	}
+	_, err = b.Leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
+
+// 判断证书的 SAN 是否覆盖域名，支持通配符证书，域名本身为通配符时要求完全一致
+func CertCoversDomain(cert *x509.Certificate, domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, name := range cert.DNSNames {
+		if MatchDomain(name, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断证书中的名称（可能为通配符）是否匹配域名
+func MatchDomain(pattern string, domain string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if pattern == domain {
+		return true
+	}
+	if !strings.HasPrefix(pattern, "*.") || strings.HasPrefix(domain, "*.") {
+		return false
+	}
+	// 通配符只匹配一级子域名
+	_, parent, found := strings.Cut(domain, ".")
+	return found && parent == pattern[2:]
+}
+
+// 证书概要信息，用于展示校验结果
+func (b *CertBundle) Summary() string {
+	return fmt.Sprintf("域名：%s\n颁发机构：%s\n生效时间：%s\n有效期至：%s\n序列号：%s\n中间证书：%d 张",
+		strings.Join(b.Leaf.DNSNames, ", "),
+		b.Leaf.Issuer.CommonName,
+		b.Leaf.NotBefore.Local().Format("2006-01-02 15:04:05"),
+		b.Leaf.NotAfter.Local().Format("2006-01-02 15:04:05"),
+		CertSerial(b.Leaf),
+		len(b.Intermediates))
+}
+
+// 证书指纹：DER 编码的 SHA-256，大写十六进制
+func CertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// 证书序列号，十六进制
+func CertSerial(cert *x509.Certificate) string {
+	return strings.ToUpper(cert.SerialNumber.Text(16))
+}
+
+// 解析 PEM 中的第一张证书，一般为叶子证书
+func ParseLeafPEM(crtPEM string) (*x509.Certificate, error) {
+	rest := []byte(crtPEM)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, fmt.Errorf("没有 PEM 格式的证书")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试用证书及其私钥
+type testCert struct {
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// 签发测试证书，parent 为空时自签名；名称中包含 "." 时作为叶子证书的域名
+func newTestCert(t *testing.T, name string, parent *testCert, notBefore time.Time, notAfter time.Time) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signTestCert(t, name, key, parent, notBefore, notAfter)
+}
+
+func signTestCert(t *testing.T, name string, key crypto.Signer, parent *testCert, notBefore time.Time, notAfter time.Time) *testCert {
+	t.Helper()
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	if strings.Contains(name, ".") {
+		template.DNSNames = []string{name}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	} else {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage = x509.KeyUsageCertSign
+	}
+	issuer, signer := template, key
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// 根证书 -> 中间证书 -> 叶子证书，有效期都包含当前时间
+type testChain struct {
+	root, intermediate, leaf *testCert
+}
+
+func newTestChain(t *testing.T, domain string) testChain {
+	t.Helper()
+	now := time.Now()
+	root := newTestCert(t, "Test Root", nil, now.Add(-time.Hour), now.AddDate(10, 0, 0))
+	intermediate := newTestCert(t, "Test Intermediate", root, now.Add(-time.Hour), now.AddDate(5, 0, 0))
+	leaf := newTestCert(t, domain, intermediate, now.Add(-time.Hour), now.AddDate(0, 3, 0))
+	return testChain{root: root, intermediate: intermediate, leaf: leaf}
+}
+
+func encodeCerts(certs ...*testCert) []byte {
+	var data []byte
+	for _, cert := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.cert.Raw})...)
+	}
+	return data
+}
+
+func encodeKey(t *testing.T, cert *testCert) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(cert.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestMatchDomain(t *testing.T) {
+	tests := []struct {
+		pattern string
+		domain  string
+		want    bool
+	}{
+		{"example.com", "example.com", true},
+		{"Example.COM.", "example.com", true},
+		{"example.com", "www.example.com", false},
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "WWW.example.com.", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "a.b.example.com", false},
+		{"*.example.com", "wwwexample.com", false},
+		{"*.example.com", "*.example.com", true},
+		{"*.example.com", "*.a.example.com", false},
+		{"www.example.com", "*.example.com", false},
+		{"*.b.example.com", "a.b.example.com", true},
+	}
+	for _, test := range tests {
+		if got := MatchDomain(test.pattern, test.domain); got != test.want {
+			t.Errorf("MatchDomain(%q, %q) = %v，期望 %v", test.pattern, test.domain, got, test.want)
+		}
+	}
+}
+
+func TestCertCoversDomain(t *testing.T) {
+	cert := &x509.Certificate{DNSNames: []string{"example.com", "*.example.com", "*.api.example.com"}}
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"example.com", true},
+		{"www.example.com", true},
+		{"v1.api.example.com", true},
+		{"a.b.example.com", false},
+		{"*.example.com", true},
+		{"example.org", false},
+		{"EXAMPLE.com.", true},
+	}
+	for _, test := range tests {
+		if got := CertCoversDomain(cert, test.domain); got != test.want {
+			t.Errorf("CertCoversDomain(%q) = %v，期望 %v", test.domain, got, test.want)
+		}
+	}
+}
+
+func TestParseCertBundle(t *testing.T) {
+	chain := newTestChain(t, "www.example.com")
+	other := newTestChain(t, "other.example.com")
+	tests := []struct {
+		name string
+		crt  []byte
+		key  []byte
+		want string
+	}{
+		{"叶子证书在最前", encodeCerts(chain.leaf, chain.intermediate), encodeKey(t, chain.leaf), ""},
+		{"叶子证书不在最前", encodeCerts(chain.root, chain.intermediate, chain.leaf), encodeKey(t, chain.leaf), ""},
+		{"私钥不匹配", encodeCerts(chain.leaf, chain.intermediate), encodeKey(t, other.leaf), "私钥与证书文件中的任何证书都不匹配"},
+		{"包含其他签发链的证书", encodeCerts(chain.leaf, chain.intermediate, other.intermediate), encodeKey(t, chain.leaf), "不属于"},
+		{"没有证书", []byte("not a cert"), encodeKey(t, chain.leaf), "没有 PEM 格式的证书"},
+		{"没有私钥", encodeCerts(chain.leaf), []byte("not a key"), "没有 PEM 格式的私钥"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bundle, err := ParseCertBundle(test.crt, test.key)
+			if test.want != "" {
+				if err == nil || !strings.Contains(err.Error(), test.want) {
+					t.Fatalf("错误 = %v，期望包含 %q", err, test.want)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bundle.Leaf.Equal(chain.leaf.cert) {
+				t.Errorf("叶子证书 = %s", bundle.Leaf.Subject)
+			}
+			// 根证书不随证书链下发
+			if len(bundle.Intermediates) != 1 || !bundle.Intermediates[0].Equal(chain.intermediate.cert) {
+				t.Errorf("中间证书 = %v", bundle.Intermediates)
+			}
+			if want := string(encodeCerts(chain.leaf, chain.intermediate)); bundle.CrtPEM != want {
+				t.Errorf("证书链应按 叶子证书 -> 中间证书 重新编码：\n%s", bundle.CrtPEM)
+			}
+			if bundle.KeyPEM != string(test.key) {
+				t.Errorf("私钥 = %s", bundle.KeyPEM)
+			}
+		})
+	}
+
+	// 证书文件中附带的根证书单独保存，用于校验证书链
+	bundle, err := ParseCertBundle(encodeCerts(chain.leaf, chain.intermediate, chain.root), encodeKey(t, chain.leaf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle.Root == nil || !bundle.Root.Equal(chain.root.cert) {
+		t.Fatalf("根证书 = %v", bundle.Root)
+	}
+	if err := bundle.Validate([]string{"www.example.com"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseCertBundleKeyFormats(t *testing.T) {
+	now := time.Now()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCert := signTestCert(t, "rsa.example.com", rsaKey, nil, now, now.Add(time.Hour))
+	ecCert := newTestCert(t, "ec.example.com", nil, now, now.Add(time.Hour))
+	ecDer, _ := x509.MarshalECPrivateKey(ecCert.key.(*ecdsa.PrivateKey))
+	pkcs8, _ := pem.Decode(encodeKey(t, rsaCert))
+
+	tests := []struct {
+		name string
+		cert *testCert
+		key  *pem.Block
+	}{
+		{"PKCS#1", rsaCert, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}},
+		{"PKCS#8", rsaCert, pkcs8},
+		{"EC", ecCert, &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDer}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// 私钥前的其他 PEM 块被忽略
+			keyPEM := append(pem.EncodeToMemory(&pem.Block{Type: "EC PARAMETERS", Bytes: []byte{6, 8}}), pem.EncodeToMemory(test.key)...)
+			bundle, err := ParseCertBundle(encodeCerts(test.cert), keyPEM)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bundle.Leaf.Equal(test.cert.cert) || len(bundle.Intermediates) != 0 {
+				t.Fatalf("叶子证书 = %s，中间证书 %d 张", bundle.Leaf.Subject, len(bundle.Intermediates))
+			}
+		})
+	}
+}
+
+func TestOrderChain(t *testing.T) {
+	now := time.Now()
+	root := newTestCert(t, "Root", nil, now, now.AddDate(1, 0, 0))
+	first := newTestCert(t, "First", root, now, now.AddDate(1, 0, 0))
+	second := newTestCert(t, "Second", first, now, now.AddDate(1, 0, 0))
+	leaf := newTestCert(t, "www.example.com", second, now, now.AddDate(1, 0, 0))
+
+	chain, chainRoot, err := orderChain(leaf.cert, []*x509.Certificate{root.cert, first.cert, second.cert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 2 || !chain[0].Equal(second.cert) || !chain[1].Equal(first.cert) {
+		t.Fatalf("中间证书顺序不正确：%v", chain)
+	}
+	if chainRoot == nil || !chainRoot.Equal(root.cert) {
+		t.Fatalf("根证书 = %v", chainRoot)
+	}
+
+	// 缺少中间的一级时剩余证书不属于签发链
+	if _, _, err := orderChain(leaf.cert, []*x509.Certificate{first.cert}); err == nil {
+		t.Fatal("证书不属于签发链时应返回错误")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Now()
+	root := newTestCert(t, "Root", nil, now.AddDate(-2, 0, 0), now.AddDate(10, 0, 0))
+	expiredIntermediate := newTestCert(t, "Old Intermediate", root, now.AddDate(-2, 0, 0), now.AddDate(0, 0, -1))
+	wildcardKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "*.example.com"},
+		DNSNames:     []string{"*.example.com", "example.com"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(0, 3, 0),
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, root.cert, wildcardKey.Public(), root.key)
+	wildcard, _ := x509.ParseCertificate(der)
+	chain := newTestChain(t, "www.example.com")
+
+	tests := []struct {
+		name     string
+		bundle   *CertBundle
+		domains  []string
+		at       time.Time
+		problems []string
+		expired  bool
+	}{
+		{"有效", &CertBundle{Leaf: wildcard, Root: root.cert}, []string{"example.com", "www.example.com", ""}, now, nil, false},
+		{"缺少中间证书", &CertBundle{Leaf: wildcard}, nil, now, []string{"证书链不完整，缺少签发者 Root 的证书"}, false},
+		// 有中间证书但无法校验到受信任的根证书时可能是私有 CA，只提示
+		{"私有 CA 的中间证书", &CertBundle{Leaf: chain.leaf.cert, Intermediates: []*x509.Certificate{chain.intermediate.cert}},
+			nil, now, nil, false},
+		{"通配符不覆盖多级子域名", &CertBundle{Leaf: wildcard, Root: root.cert}, []string{"a.b.example.com"}, now, []string{"不包含 a.b.example.com"}, false},
+		{"尚未生效", &CertBundle{Leaf: wildcard, Root: root.cert}, nil, now.Add(-2 * time.Hour), []string{"尚未生效"}, false},
+		{"已过期", &CertBundle{Leaf: wildcard, Root: root.cert}, nil, now.AddDate(1, 0, 0), []string{"已过期"}, true},
+		{"中间证书已过期", &CertBundle{Leaf: wildcard, Intermediates: []*x509.Certificate{expiredIntermediate.cert}, Root: root.cert},
+			nil, now, []string{"中间证书 Old Intermediate 不在有效期内"}, false},
+		{"列出全部问题", &CertBundle{Leaf: wildcard, Intermediates: []*x509.Certificate{expiredIntermediate.cert}, Root: root.cert},
+			[]string{"example.org"}, now.AddDate(1, 0, 0), []string{"已过期", "中间证书", "不包含 example.org"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.bundle.Validate(test.domains, test.at)
+			if len(test.problems) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var validationError *CertValidationError
+			if !errors.As(err, &validationError) {
+				t.Fatalf("错误 = %v，期望 CertValidationError", err)
+			}
+			if validationError.Subject != "*.example.com" || len(validationError.Problems) != len(test.problems) {
+				t.Fatalf("校验结果 = %+v", validationError)
+			}
+			for i, want := range test.problems {
+				if !strings.Contains(validationError.Problems[i], want) {
+					t.Errorf("问题 %q 不包含 %q", validationError.Problems[i], want)
+				}
+			}
+			if errors.Is(err, ErrCertExpired) != test.expired {
+				t.Errorf("errors.Is(err, ErrCertExpired) 期望为 %v", test.expired)
+			}
+		})
+	}
+}
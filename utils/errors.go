@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 通用错误类型，可以通过 errors.Is 判断
+var (
+	// 认证失败：Token 或 AccessKey 无效、无权限
+	ErrAuth = errors.New("认证失败")
+	// 资源不存在：证书、域名、Bucket 等
+	ErrNotFound = errors.New("资源不存在")
+	// 证书已过期
+	ErrCertExpired = errors.New("证书已过期")
+	// 证书还在有效期，跳过更新
+	ErrStillValid = errors.New("证书还在有效期，暂不更新")
+)
+
+// 接口调用异常，包含服务端返回的错误码
+type APIError struct {
+	// 服务名称，例如 SafeLine、OSS、CAS
+	Service string
+	// 调用的接口
+	Operation  string
+	StatusCode int
+	Code       string
+	Message    string
+	RequestId  string
+	// 归类后的通用错误，可能为空
+	Kind error
+}
+
+func (e *APIError) Error() string {
+	message := fmt.Sprintf("%s %s 接口调用异常", e.Service, e.Operation)
+	if e.StatusCode != 0 {
+		message += fmt.Sprintf("，状态码：%d", e.StatusCode)
+	}
+	if e.Code != "" {
+		message += "，错误码：" + e.Code
+	}
+	if e.Message != "" {
+		message += "，错误信息：" + e.Message
+	}
+	if e.RequestId != "" {
+		message += "，RequestId：" + e.RequestId
+	}
+	return message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Kind
+}
+
+// 根据状态码和错误码归类，返回可以被 errors.Is 识别的 APIError
+func NewAPIError(service string, operation string, statusCode int, code string, message string) *APIError {
+	apiError := &APIError{
+		Service:    service,
+		Operation:  operation,
+		StatusCode: statusCode,
+		Code:       code,
+		Message:    message,
+	}
+
+	lowerCode := strings.ToLower(code)
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden,
+		strings.Contains(lowerCode, "accesskey"),
+		strings.Contains(lowerCode, "signature"),
+		strings.Contains(lowerCode, "forbidden"),
+		strings.Contains(lowerCode, "nopermission"),
+		strings.Contains(lowerCode, "accessdenied"),
+		strings.Contains(lowerCode, "unauthorized"):
+		apiError.Kind = ErrAuth
+	case statusCode == http.StatusNotFound,
+		strings.Contains(lowerCode, "notfound"),
+		strings.Contains(lowerCode, "notexist"):
+		apiError.Kind = ErrNotFound
+	}
+	return apiError
+}
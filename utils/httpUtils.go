@@ -1,24 +1,17 @@
 package utils
 
 import (
-	"crypto/tls"
+	"fmt"
 	"io"
 	"net/http"
 	netUrl "net/url"
 	"strings"
 )
 
-// 获取 client 客户端
+// 获取 client 客户端，使用系统根证书校验服务端证书
 func getClient() *http.Client {
-	//跳过不安全的验证
-	transport := http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-		},
-	}
-
-	client := http.Client{Transport: &transport}
-	return &client
+	client, _ := NewHTTPClient(TLSOptions{})
+	return client
 }
 
 // 获取请求体
@@ -46,33 +39,29 @@ func getRequest(url string, method string, header map[string][]string, body any)
 	return request
 }
 
-func get(url string) (any, int) {
+func get(url string) (string, error) {
 	client := getClient()
 	request := getRequest(url, "GET", nil, nil)
 
 	response, err := client.Do(&request)
 	if err != nil {
-		TraceLog("", err)
-		return "", 500
+		return "", fmt.Errorf("Get请求 %s 异常：%w", url, err)
 	}
+	defer response.Body.Close()
 
 	bodyByte, err := io.ReadAll(response.Body)
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-		}
-	}(response.Body)
-
+	if err != nil {
+		return "", fmt.Errorf("读取 %s 返回体异常：%w", url, err)
+	}
 	responseBody := string(bodyByte)
 
 	if response.StatusCode != http.StatusOK {
-		ErrorLog("Get请求失败，返回码为：", response.StatusCode, "，异常返回体为：", responseBody)
-		return "", response.StatusCode
+		return "", NewAPIError("HTTP", "GET "+url, response.StatusCode, "", responseBody)
 	}
-	return responseBody, response.StatusCode
+	return responseBody, nil
 }
 
-func post(url string, body any) (any, int) {
+func post(url string, body any) (string, error) {
 	client := getClient()
 	var headers = map[string][]string{
 		"Content-Type": {"application/json"},
@@ -82,22 +71,18 @@ func post(url string, body any) (any, int) {
 
 	response, err := client.Do(&request)
 	if err != nil {
-		TraceLog("", err)
-		return "", 500
+		return "", fmt.Errorf("Post请求 %s 异常：%w", url, err)
 	}
+	defer response.Body.Close()
 
 	bodyByte, err := io.ReadAll(response.Body)
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-		}
-	}(response.Body)
-
+	if err != nil {
+		return "", fmt.Errorf("读取 %s 返回体异常：%w", url, err)
+	}
 	responseBody := string(bodyByte)
 
 	if response.StatusCode != http.StatusOK {
-		ErrorLog("Post请求失败，返回码为：", response.StatusCode, "，异常返回体为：", responseBody)
-		return "", response.StatusCode
+		return "", NewAPIError("HTTP", "POST "+url, response.StatusCode, "", responseBody)
 	}
-	return responseBody, response.StatusCode
+	return responseBody, nil
 }
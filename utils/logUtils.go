@@ -1,6 +1,11 @@
 package utils
 
-import "log"
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
 
 func DebugLog(v ...any) {
 	log.Println(" [debug] \t", v)
@@ -11,5 +16,26 @@ func ErrorLog(v ...any) {
 }
 
 func TraceLog(v ...any) {
-	log.Fatal(" [trace] \t", v)
+	log.Println(" [trace] \t", v)
+}
+
+// 运行过程的输出，默认为标准输出，启动时设置，之后不再修改
+var output io.Writer = os.Stdout
+
+// 设置运行过程的输出，json 输出时改为标准错误，标准输出只保留执行结果
+func SetOutput(w io.Writer) {
+	output = w
+}
+
+// 运行过程的输出，供需要 io.Writer 的日志使用
+func Output() io.Writer {
+	return output
+}
+
+func Println(v ...any) {
+	_, _ = fmt.Fprintln(output, v...)
+}
+
+func Printf(format string, v ...any) {
+	_, _ = fmt.Fprintf(output, format, v...)
 }
@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTP 客户端的 TLS 校验选项
+type TLSOptions struct {
+	// 自定义 CA 证书文件（PEM），为空时使用系统根证书
+	CAFile string
+	// 服务端证书公钥（SubjectPublicKeyInfo）的 SHA-256，支持 sha256/Base64 和十六进制格式。
+	// 配置后叶子证书的公钥匹配，或叶子证书能校验到服务端返回的公钥匹配的证书（例如自建 CA）即可，适用于自签名的管理证书
+	PinSHA256 []string
+	// 跳过证书校验，需要显式开启
+	Insecure bool
+}
+
+// 默认请求超时时间
+const defaultHTTPTimeout = 30 * time.Second
+
+// 根据 TLS 选项创建 HTTP 客户端，所有访问管理接口的客户端都应通过这里创建
+func NewHTTPClient(options TLSOptions) (*http.Client, error) {
+	tlsConfig, err := NewTLSConfig(options)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport, Timeout: defaultHTTPTimeout}, nil
+}
+
+// 根据 TLS 选项创建 tls.Config
+func NewTLSConfig(options TLSOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if options.Insecure {
+		ErrorLog("已开启 insecure，将跳过 TLS 证书校验")
+		tlsConfig.InsecureSkipVerify = true
+		return tlsConfig, nil
+	}
+
+	if options.CAFile != "" {
+		caPEM, err := ReadFile(options.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(caPEM)) {
+			return nil, fmt.Errorf("CA 证书文件 %s 中没有可用的证书", options.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if len(options.PinSHA256) == 0 {
+		return tlsConfig, nil
+	}
+
+	pins := make(map[string]bool)
+	for _, pin := range options.PinSHA256 {
+		digest, err := parsePin(pin)
+		if err != nil {
+			return nil, err
+		}
+		pins[digest] = true
+	}
+
+	// 配置了公钥固定时由 VerifyConnection 自行校验：
+	// 配置了 CA 时校验证书链，再要求链中的证书公钥匹配；
+	// 否则以公钥匹配的证书作为信任锚，叶子证书本身匹配或能校验到匹配的证书才通过，
+	// 不能只看服务端返回的证书中是否有匹配的，否则附带一张公开的证书即可绕过
+	roots := tlsConfig.RootCAs
+	verifyChain := options.CAFile != ""
+	tlsConfig.InsecureSkipVerify = true
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return fmt.Errorf("服务端没有返回证书")
+		}
+		leaf := state.PeerCertificates[0]
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		var chains [][]*x509.Certificate
+		if verifyChain {
+			var err error
+			chains, err = leaf.Verify(x509.VerifyOptions{
+				DNSName:       state.ServerName,
+				Roots:         roots,
+				Intermediates: intermediates,
+			})
+			if err != nil {
+				return fmt.Errorf("服务端证书校验失败：%w", err)
+			}
+		} else {
+			if pins[PublicKeyPin(leaf)] {
+				return nil
+			}
+			pinned := x509.NewCertPool()
+			for _, cert := range state.PeerCertificates[1:] {
+				if pins[PublicKeyPin(cert)] {
+					pinned.AddCert(cert)
+				}
+			}
+			chains, _ = leaf.Verify(x509.VerifyOptions{
+				Roots:         pinned,
+				Intermediates: intermediates,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+			})
+		}
+		for _, chain := range chains {
+			for _, cert := range chain {
+				if pins[PublicKeyPin(cert)] {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("服务端证书公钥 sha256/%s 与配置的 pin_sha256 不匹配", PublicKeyPin(leaf))
+	}
+	return tlsConfig, nil
+}
+
+// 证书公钥的 SHA-256，Base64 编码
+func PublicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// 将 sha256/Base64 或十六进制格式的公钥指纹统一转换为 Base64
+func parsePin(pin string) (string, error) {
+	value := strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+	if digest, err := base64.StdEncoding.DecodeString(value); err == nil && len(digest) == sha256.Size {
+		return base64.StdEncoding.EncodeToString(digest), nil
+	}
+	if digest, err := hex.DecodeString(strings.ReplaceAll(value, ":", "")); err == nil && len(digest) == sha256.Size {
+		return base64.StdEncoding.EncodeToString(digest), nil
+	}
+	return "", fmt.Errorf("公钥指纹 %s 不合法，需要 SHA-256 的 Base64 或十六进制格式", pin)
+}
+
+// TLS 探测结果
+type TLSProbe struct {
+	// 服务端返回的证书链，第一张为叶子证书
+	Chain []*x509.Certificate
+	// 按系统根证书和 SNI 校验证书链的结果，校验通过时为空
+	VerifyError error
+}
+
+// 使用 SNI serverName 与 address 握手，返回服务端实际提供的证书链；
+// 握手时不校验证书，证书不受信任时也能取得证书链，校验结果记录在 VerifyError 中
+func ProbeTLS(ctx context.Context, address string, serverName string) (*TLSProbe, error) {
+	dialer := &tls.Dialer{Config: &tls.Config{ServerName: serverName, InsecureSkipVerify: true}}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("与 %s 握手失败：%w", address, err)
+	}
+	defer conn.Close()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil, fmt.Errorf("%s 没有返回证书", address)
+	}
+	probe := &TLSProbe{Chain: state.PeerCertificates}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, probe.VerifyError = state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       serverName,
+		Intermediates: intermediates,
+	})
+	return probe, nil
+}
+
+// 证书链中最早的过期时间
+func (p *TLSProbe) NotAfter() time.Time {
+	notAfter := p.Chain[0].NotAfter
+	for _, cert := range p.Chain[1:] {
+		if cert.NotAfter.Before(notAfter) {
+			notAfter = cert.NotAfter
+		}
+	}
+	return notAfter
+}
@@ -0,0 +1,292 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 启动 HTTPS 服务，证书为 httptest 内置的自签名证书
+func newTLSServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writePEM(t *testing.T, certs ...*x509.Certificate) string {
+	t.Helper()
+	var data []byte
+	for _, cert := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	server := newTLSServer(t)
+	serverCert := server.Certificate()
+	pin := PublicKeyPin(serverCert)
+	sum := sha256.Sum256(serverCert.RawSubjectPublicKeyInfo)
+	otherCA := newTestChain(t, "www.example.com").root.cert
+
+	tests := []struct {
+		name    string
+		options TLSOptions
+		want    string
+	}{
+		{"系统根证书不信任自签名证书", TLSOptions{}, "certificate"},
+		{"CA 文件", TLSOptions{CAFile: writePEM(t, serverCert)}, ""},
+		{"其他 CA", TLSOptions{CAFile: writePEM(t, otherCA)}, "certificate"},
+		{"公钥匹配", TLSOptions{PinSHA256: []string{"sha256/" + pin}}, ""},
+		{"十六进制公钥匹配任一", TLSOptions{PinSHA256: []string{
+			"sha256/" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size)),
+			strings.ToUpper(hex.EncodeToString(sum[:])),
+		}}, ""},
+		{"公钥不匹配", TLSOptions{PinSHA256: []string{base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))}}, "不匹配"},
+		{"公钥匹配但 CA 不匹配", TLSOptions{CAFile: writePEM(t, otherCA), PinSHA256: []string{pin}}, "服务端证书校验失败"},
+		{"公钥和 CA 都匹配", TLSOptions{CAFile: writePEM(t, serverCert), PinSHA256: []string{pin}}, ""},
+		{"insecure", TLSOptions{Insecure: true}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := NewHTTPClient(test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response, err := client.Get(server.URL)
+			if test.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				response.Body.Close()
+				return
+			}
+			if err == nil {
+				response.Body.Close()
+				t.Fatalf("期望请求失败，错误包含 %q", test.want)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("错误 = %v，期望包含 %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfigErrors(t *testing.T) {
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, []byte("no certs"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		options TLSOptions
+		want    string
+	}{
+		{"CA 文件不存在", TLSOptions{CAFile: filepath.Join(t.TempDir(), "missing.pem")}, "missing.pem"},
+		{"CA 文件中没有证书", TLSOptions{CAFile: empty}, "没有可用的证书"},
+		{"公钥指纹不合法", TLSOptions{PinSHA256: []string{"abc"}}, "公钥指纹 abc 不合法"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := NewTLSConfig(test.options)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("错误 = %v，期望包含 %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestParsePin(t *testing.T) {
+	digest := sha256.Sum256([]byte("public key"))
+	want := base64.StdEncoding.EncodeToString(digest[:])
+	hexDigest := hex.EncodeToString(digest[:])
+	var colons []string
+	for i := 0; i < len(hexDigest); i += 2 {
+		colons = append(colons, hexDigest[i:i+2])
+	}
+
+	tests := []struct {
+		name  string
+		pin   string
+		valid bool
+	}{
+		{"sha256/Base64", "sha256/" + want, true},
+		{"Base64", want, true},
+		{"首尾空白", "  sha256/" + want + "\n", true},
+		{"十六进制", hexDigest, true},
+		{"大写十六进制", strings.ToUpper(hexDigest), true},
+		{"冒号分隔的十六进制", strings.Join(colons, ":"), true},
+		{"长度不是 SHA-256", base64.StdEncoding.EncodeToString(digest[:16]), false},
+		{"十六进制长度不对", hexDigest[:40], false},
+		{"不合法", "sha256/not-a-pin", false},
+		{"空", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parsePin(test.pin)
+			if !test.valid {
+				if err == nil {
+					t.Fatalf("parsePin(%q) = %q，期望返回错误", test.pin, got)
+				}
+				return
+			}
+			if err != nil || got != want {
+				t.Fatalf("parsePin(%q) = %q，%v，期望 %q", test.pin, got, err, want)
+			}
+		})
+	}
+}
+
+func TestProbeTLS(t *testing.T) {
+	server := newTLSServer(t)
+	address := strings.TrimPrefix(server.URL, "https://")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// 证书不受信任时仍返回证书链，校验结果记录在 VerifyError
+	probe, err := ProbeTLS(ctx, address, "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(probe.Chain) == 0 || !probe.Chain[0].Equal(server.Certificate()) {
+		t.Fatalf("证书链 = %v", probe.Chain)
+	}
+	var unknownAuthority x509.UnknownAuthorityError
+	if !errors.As(probe.VerifyError, &unknownAuthority) {
+		t.Errorf("VerifyError = %v，期望 UnknownAuthorityError", probe.VerifyError)
+	}
+
+	if _, err := ProbeTLS(ctx, "127.0.0.1:1", "example.com"); err == nil {
+		t.Fatal("无法连接时应返回错误")
+	}
+}
+
+func TestProbeNotAfter(t *testing.T) {
+	now := time.Now()
+	root := newTestCert(t, "Root", nil, now, now.AddDate(10, 0, 0))
+	intermediate := newTestCert(t, "Intermediate", root, now, now.AddDate(0, 1, 0))
+	leaf := newTestCert(t, "www.example.com", intermediate, now, now.AddDate(0, 3, 0))
+
+	probe := &TLSProbe{Chain: []*x509.Certificate{leaf.cert, intermediate.cert}}
+	if got := probe.NotAfter(); !got.Equal(intermediate.cert.NotAfter) {
+		t.Fatalf("NotAfter = %s，期望中间证书的过期时间 %s", got, intermediate.cert.NotAfter)
+	}
+	probe.Chain = probe.Chain[:1]
+	if got := probe.NotAfter(); !got.Equal(leaf.cert.NotAfter) {
+		t.Fatalf("NotAfter = %s，期望叶子证书的过期时间", got)
+	}
+}
+
+// 启动使用指定证书链的 HTTPS 服务
+func newChainServer(t *testing.T, leaf *testCert, chain ...*testCert) *httptest.Server {
+	t.Helper()
+	certificate := tls.Certificate{Certificate: [][]byte{leaf.cert.Raw}, PrivateKey: leaf.key}
+	for _, cert := range chain {
+		certificate.Certificate = append(certificate.Certificate, cert.cert.Raw)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{certificate}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPinWithoutCA(t *testing.T) {
+	trusted := newTestChain(t, "www.example.com")
+	attacker := newTestChain(t, "www.example.com")
+	intermediatePin := "sha256/" + PublicKeyPin(trusted.intermediate.cert)
+
+	tests := []struct {
+		name   string
+		server *httptest.Server
+		pin    string
+		want   string
+	}{
+		{"叶子证书匹配", newChainServer(t, trusted.leaf), "sha256/" + PublicKeyPin(trusted.leaf.cert), ""},
+		{"叶子证书由匹配的中间证书签发", newChainServer(t, trusted.leaf, trusted.intermediate), intermediatePin, ""},
+		{"其他叶子证书附带匹配的证书", newChainServer(t, attacker.leaf, trusted.intermediate), intermediatePin, "不匹配"},
+		{"其他证书链附带匹配的证书", newChainServer(t, attacker.leaf, attacker.intermediate, trusted.intermediate), intermediatePin, "不匹配"},
+		{"匹配的证书不在证书链中", newChainServer(t, trusted.leaf, trusted.intermediate), "sha256/" + PublicKeyPin(trusted.root.cert), "不匹配"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := NewHTTPClient(TLSOptions{PinSHA256: []string{test.pin}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			response, err := client.Get(test.server.URL)
+			if test.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				response.Body.Close()
+				return
+			}
+			if err == nil {
+				response.Body.Close()
+				t.Fatalf("期望握手失败，错误包含 %q", test.want)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("错误 = %v，期望包含 %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestPinWithCAUsesVerifiedChain(t *testing.T) {
+	trusted := newTestChain(t, "127.0.0.1")
+	other := newTestChain(t, "127.0.0.1")
+	caFile := writePEM(t, other.root.cert)
+	tests := []struct {
+		name   string
+		server *httptest.Server
+		pin    string
+		want   string
+	}{
+		{"证书链可信且中间证书匹配", newChainServer(t, other.leaf, other.intermediate), PublicKeyPin(other.intermediate.cert), ""},
+		// 证书链可信，但匹配的证书只是附带的，不在校验通过的证书链中
+		{"附带匹配的证书", newChainServer(t, other.leaf, other.intermediate, trusted.intermediate), PublicKeyPin(trusted.intermediate.cert), "不匹配"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client, err := NewHTTPClient(TLSOptions{CAFile: caFile, PinSHA256: []string{test.pin}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			response, err := client.Get(test.server.URL)
+			if test.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				response.Body.Close()
+				return
+			}
+			if err == nil {
+				response.Body.Close()
+				t.Fatalf("期望握手失败，错误包含 %q", test.want)
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Fatalf("错误 = %v，期望包含 %q", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 证书和私钥不匹配时的最大重试次数，超过后等待下一次文件变化
+const watchMaxAttempts = 12
+
+// 监听中的证书
+type watchedCert struct {
+	cert    config.Cert
+	targets []config.Target
+
+	// 防抖定时器和本轮已检查的次数，只在主循环中访问
+	timer    *time.Timer
+	attempts int
+
+	mu sync.Mutex
+	// 上一次全部目标部署成功的证书指纹，内容未变化时不重复部署
+	fingerprint string
+}
+
+func (w *watchedCert) deployedFingerprint() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.fingerprint
+}
+
+func (w *watchedCert) setDeployedFingerprint(fingerprint string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.fingerprint = fingerprint
+}
+
+// 待处理的证书，同一张证书重复加入时合并为一次，只保留最新的指纹；
+// 加入时不会阻塞，通过 signal 唤醒处理方
+type pendingCerts struct {
+	mu     sync.Mutex
+	certs  map[*watchedCert]string
+	signal chan struct{}
+}
+
+func newPendingCerts() *pendingCerts {
+	return &pendingCerts{certs: make(map[*watchedCert]string), signal: make(chan struct{}, 1)}
+}
+
+func (p *pendingCerts) add(watched *watchedCert, fingerprint string) {
+	p.mu.Lock()
+	p.certs[watched] = fingerprint
+	p.mu.Unlock()
+	select {
+	case p.signal <- struct{}{}:
+	default:
+	}
+}
+
+// 取出全部待处理的证书，按证书名称排序
+func (p *pendingCerts) take() []pendingCert {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	taken := make([]pendingCert, 0, len(p.certs))
+	for watched, fingerprint := range p.certs {
+		taken = append(taken, pendingCert{watched: watched, fingerprint: fingerprint})
+	}
+	clear(p.certs)
+	sort.Slice(taken, func(i, j int) bool { return taken[i].watched.cert.Name < taken[j].watched.cert.Name })
+	return taken
+}
+
+type pendingCert struct {
+	watched     *watchedCert
+	fingerprint string
+}
+
+// 部署引用该证书的全部目标，全部成功后才记录指纹，失败时下一次文件变化会重新部署
+func deployWatched(watched *watchedCert, fingerprint string, deploy func(config.Target) bool) {
+	succeeded := true
+	for _, targetConfig := range watched.targets {
+		if !deploy(targetConfig) {
+			succeeded = false
+		}
+	}
+	if succeeded {
+		watched.setDeployedFingerprint(fingerprint)
+	} else {
+		utils.Println("证书", watched.cert.Name, "有目标部署失败，下一次文件变化时重新部署")
+	}
+}
+
+// 监听证书文件变化：写入停止 debounce 后，证书和私钥能组成有效的一对时部署引用该证书的目标
+//
+// 监听的是证书所在目录，兼容 certbot 替换软链接、acme.sh 覆盖写入以及先写临时文件再改名的方式。
+func runWatch(configPath string, selector string, force bool, debounce time.Duration) int {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		utils.Println(err)
+		return exitConfigError
+	}
+	if force {
+		cfg.Force()
+	}
+	targets := cfg.SelectTargets(selector)
+	if len(targets) == 0 {
+		utils.Println("没有匹配的部署目标：", selector)
+		return exitConfigError
+	}
+
+	// 按证书分组，记录文件路径对应的证书
+	certs := make(map[string]*watchedCert)
+	paths := make(map[string]*watchedCert)
+	for _, targetConfig := range targets {
+		cert, _ := cfg.FindCert(targetConfig.Cert)
+		watched, ok := certs[cert.Name]
+		if !ok {
+			watched = &watchedCert{cert: cert}
+			certs[cert.Name] = watched
+			paths[filepath.Clean(cert.CrtPath)] = watched
+			paths[filepath.Clean(cert.KeyPath)] = watched
+			//启动时记录当前证书，文件未变化时不部署
+			if bundle, err := utils.LoadCertBundle(cert.CrtPath, cert.KeyPath); err == nil {
+				watched.fingerprint = utils.CertFingerprint(bundle.Leaf)
+			}
+		}
+		watched.targets = append(watched.targets, targetConfig)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		utils.Println("创建文件监听异常：", err)
+		return exitFailure
+	}
+	defer watcher.Close()
+
+	dirs := make(map[string]bool)
+	for path := range paths {
+		dir := filepath.Dir(path)
+		if dirs[dir] {
+			continue
+		}
+		if err := watcher.Add(dir); err != nil {
+			utils.Println("监听目录", dir, "异常：", err)
+			return exitConfigError
+		}
+		dirs[dir] = true
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	// 定时器到期后在主循环中检查证书，部署在单独的协程中依次执行；两者都通过待处理集合交接，主循环不会阻塞
+	ready := newPendingCerts()
+	deploys := newPendingCerts()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-deploys.signal:
+			}
+			for _, pending := range deploys.take() {
+				deployWatched(pending.watched, pending.fingerprint, func(targetConfig config.Target) bool {
+					succeeded := reportResult(runTarget(context.Background(), targetConfig, pending.watched.cert))
+					utils.Println("")
+					return succeeded
+				})
+			}
+		}
+	}()
+
+	utils.Println("开始监听证书文件变化，写入停止", debounce, "后部署")
+	for name := range certs {
+		utils.Println("证书", name, "：", certs[name].cert.CrtPath, certs[name].cert.KeyPath)
+	}
+
+	schedule := func(watched *watchedCert) {
+		if watched.timer == nil {
+			watched.timer = time.AfterFunc(debounce, func() { ready.add(watched, "") })
+			return
+		}
+		watched.timer.Reset(debounce)
+	}
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return exitFailure
+			}
+			watched, found := paths[filepath.Clean(event.Name)]
+			if !found || event.Op == fsnotify.Chmod {
+				continue
+			}
+			watched.attempts = 0
+			schedule(watched)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return exitFailure
+			}
+			utils.ErrorLog("文件监听异常：", err)
+
+		case <-ready.signal:
+			for _, pending := range ready.take() {
+				checkWatched(pending.watched, deploys, schedule)
+			}
+
+		case sig := <-signals:
+			utils.Println("收到", sig, "信号，等待进行中的部署完成后退出")
+			close(stop)
+			<-done
+			utils.Println("文件监听已退出")
+			return 0
+		}
+	}
+}
+
+// 检查证书和私钥，能组成有效的一对且与上次部署成功的证书不同时加入待部署集合，暂不可用时稍后重新检查
+func checkWatched(watched *watchedCert, deploys *pendingCerts, schedule func(*watchedCert)) {
+	bundle, err := utils.LoadCertBundle(watched.cert.CrtPath, watched.cert.KeyPath)
+	if err == nil {
+		err = bundle.Validate(nil, time.Now())
+	}
+	if err != nil {
+		//证书和私钥可能还未全部写入，稍后再检查
+		watched.attempts++
+		if watched.attempts < watchMaxAttempts {
+			utils.Println("证书", watched.cert.Name, "暂不可用，等待写入完成：", err)
+			schedule(watched)
+		} else {
+			utils.Println("证书", watched.cert.Name, "多次检查仍不可用，等待下一次文件变化：", err)
+		}
+		return
+	}
+	fingerprint := utils.CertFingerprint(bundle.Leaf)
+	if fingerprint == watched.deployedFingerprint() {
+		utils.Println("证书", watched.cert.Name, "内容未变化，跳过部署")
+		return
+	}
+	utils.Println("证书", watched.cert.Name, "已更新，开始部署")
+	deploys.add(watched, fingerprint)
+}
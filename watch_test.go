@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"whoyang.cn/update_cert/config"
+	"whoyang.cn/update_cert/utils"
+)
+
+// 写入一对自签名证书和私钥，返回证书指纹
+func writeTestCert(t *testing.T, crtPath string, keyPath string) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(crtPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return utils.CertFingerprint(leaf)
+}
+
+func TestPendingCertsCoalesce(t *testing.T) {
+	pending := newPendingCerts()
+	a := &watchedCert{cert: config.Cert{Name: "a"}}
+	b := &watchedCert{cert: config.Cert{Name: "b"}}
+
+	// 没有处理方时多次加入也不会阻塞
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			pending.add(a, "old")
+			pending.add(b, "b")
+		}
+		pending.add(a, "new")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("加入待处理集合时阻塞")
+	}
+
+	<-pending.signal
+	taken := pending.take()
+	if len(taken) != 2 || taken[0].watched != a || taken[0].fingerprint != "new" || taken[1].watched != b {
+		t.Fatalf("取出的证书 = %+v，期望合并为 a(new)、b", taken)
+	}
+	if len(pending.take()) != 0 {
+		t.Fatal("取出后集合应为空")
+	}
+}
+
+func TestDeployWatchedFingerprint(t *testing.T) {
+	watched := &watchedCert{
+		cert:        config.Cert{Name: "example"},
+		targets:     []config.Target{{Name: "waf"}, {Name: "oss"}},
+		fingerprint: "old",
+	}
+
+	// 任一目标失败时保留原指纹，下一次文件变化时重新部署
+	var deployed []string
+	deployWatched(watched, "new", func(targetConfig config.Target) bool {
+		deployed = append(deployed, targetConfig.Name)
+		return targetConfig.Name != "oss"
+	})
+	if len(deployed) != 2 {
+		t.Fatalf("部署的目标 = %v，失败后应继续部署其他目标", deployed)
+	}
+	if got := watched.deployedFingerprint(); got != "old" {
+		t.Fatalf("部署失败后指纹 = %q，期望保留 old", got)
+	}
+
+	deployWatched(watched, "new", func(config.Target) bool { return true })
+	if got := watched.deployedFingerprint(); got != "new" {
+		t.Fatalf("部署成功后指纹 = %q，期望 new", got)
+	}
+}
+
+func TestCheckWatched(t *testing.T) {
+	dir := t.TempDir()
+	crtPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	fingerprint := writeTestCert(t, crtPath, keyPath)
+	watched := &watchedCert{cert: config.Cert{Name: "example", CrtPath: crtPath, KeyPath: keyPath}}
+	deploys := newPendingCerts()
+	rescheduled := 0
+	schedule := func(*watchedCert) { rescheduled++ }
+
+	checkWatched(watched, deploys, schedule)
+	if taken := deploys.take(); len(taken) != 1 || taken[0].fingerprint != fingerprint {
+		t.Fatalf("待部署 = %+v，证书变化时应加入待部署集合", taken)
+	}
+	// 部署尚未成功，指纹不变
+	if watched.deployedFingerprint() != "" {
+		t.Fatal("加入待部署集合时不应更新指纹")
+	}
+
+	watched.setDeployedFingerprint(fingerprint)
+	checkWatched(watched, deploys, schedule)
+	if taken := deploys.take(); len(taken) != 0 {
+		t.Fatalf("待部署 = %+v，内容未变化时不应部署", taken)
+	}
+
+	// 私钥与证书不匹配时稍后重新检查
+	other := writeTestCert(t, filepath.Join(dir, "other.pem"), keyPath)
+	if other == fingerprint {
+		t.Fatal("生成的证书指纹重复")
+	}
+	checkWatched(watched, deploys, schedule)
+	if rescheduled != 1 || watched.attempts != 1 || len(deploys.take()) != 0 {
+		t.Fatalf("重新检查 %d 次，attempts = %d", rescheduled, watched.attempts)
+	}
+}